@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
 
 	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
 	appsv1 "k8s.io/api/apps/v1"
@@ -57,12 +58,19 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var sensitiveEnvVars string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&sensitiveEnvVars, "sensitive-env-vars", "",
+		"Comma separated names of DaemonSet environment variables, whose values are never logged, "+
+			"in addition to BMC credentials.")
 	flag.Parse()
 
+	// webhooks require serving certificates, which are only present if webhook is enabled in kustomize
+	enableWebhooks := os.Getenv("ENABLE_WEBHOOKS") == "true"
+
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
@@ -83,15 +91,27 @@ func main() {
 
 	ipammer := ipam.NewIpam(ctrl.Log.WithName("IPAM"), mgr.GetClient(),
 		os.Getenv("RUNTIME_NAMESPACE"))
+	if err = ipammer.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up IPAM")
+		os.Exit(1)
+	}
 
 	if err = (&controllers.VinoReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 		Ipam:   ipammer,
+
+		SensitiveEnvVars: splitNames(sensitiveEnvVars),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Vino")
 		os.Exit(1)
 	}
+	if enableWebhooks {
+		if err = (&vinov1.Vino{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Vino")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -100,3 +120,14 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// splitNames splits comma separated list of names, skipping empty ones
+func splitNames(list string) []string {
+	names := []string{}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -30,6 +30,10 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+
+	vinov1 "vino/pkg/api/v1"
+	"vino/pkg/inventory"
+	"vino/pkg/libvirt"
 )
 
 func main() {
@@ -46,6 +50,16 @@ func main() {
 		)
 	}
 
+	// sysfs and storage pool directory of the host are mounted to the container
+	sysRoot := inventory.DefaultSysRoot
+	if value, ok := os.LookupEnv("SYS_ROOT"); ok {
+		sysRoot = value
+	}
+	storagePoolPath := libvirt.DefaultStoragePoolPath
+	if value, ok := os.LookupEnv("STORAGE_POOL_PATH"); ok {
+		storagePoolPath = value
+	}
+
 	log.Info("Starting service",
 		zap.String("node", nodeName),
 	)
@@ -87,6 +101,23 @@ func main() {
 			}
 		}
 
+		// VMs are not checked against host capacity, until inventory is published
+		hostInventory, err := inventory.Collect(sysRoot, map[string]string{
+			libvirt.DefaultStoragePool: storagePoolPath,
+		})
+		if err != nil {
+			log.Error("Failed to collect host inventory", zap.Error(err))
+		} else {
+			data, err := json.Marshal(hostInventory)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			err = addAnnotationToNode(ctx, clientset, node, vinov1.VinoHostInventoryAnnotation, string(data))
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+		}
+
 		time.Sleep(600 * time.Second)
 	}
 }
@@ -129,3 +160,36 @@ func addLabelToNode(
 
 	return nil
 }
+
+func addAnnotationToNode(
+	ctx context.Context,
+	clientset *kubernetes.Clientset,
+	node *v1.Node,
+	key, value string) error {
+	log.Info("Applying node annotation",
+		zap.String(key, value),
+	)
+
+	originalNode, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+
+	if node.ObjectMeta.Annotations == nil {
+		node.ObjectMeta.Annotations = map[string]string{}
+	}
+	node.ObjectMeta.Annotations[key] = value
+
+	newNode, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+
+	patch, err := jsonpatch.CreateMergePatch(originalNode, newNode)
+	if err != nil {
+		return err
+	}
+
+	_, err = clientset.CoreV1().Nodes().Patch(ctx, node.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
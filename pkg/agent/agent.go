@@ -0,0 +1,261 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	vinov1 "vino/pkg/api/v1"
+	"vino/pkg/reporter"
+)
+
+// syncKey is the only key of agent work queue, every change of configs on the node leads to
+// the same sync of the config of own vino CR
+const syncKey = "sync"
+
+// Realizer creates networks and domains of the builder data file on the node
+type Realizer interface {
+	Realize(ctx context.Context, dataFile string) error
+}
+
+// PlaybookRealizer realizes builder data by running vino-builder ansible playbook. Playbook
+// runs of vino-builders of all vino CRs on the node are serialized by a lock file.
+type PlaybookRealizer struct {
+	Playbook string
+	// VarFiles are passed to the playbook as extra vars before the builder data file
+	VarFiles []string
+	LockFile string
+}
+
+// Realize runs the playbook, playbook output goes to the agent output
+func (p PlaybookRealizer) Realize(ctx context.Context, dataFile string) error {
+	args := []string{p.LockFile, "ansible-playbook", "-v"}
+	for _, varFile := range append(append([]string{}, p.VarFiles...), dataFile) {
+		args = append(args, "-e", "@"+varFile)
+	}
+	args = append(args, p.Playbook)
+	cmd := exec.CommandContext(ctx, "flock", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("vino-builder playbook failed: %w", err)
+	}
+	return nil
+}
+
+// Agent runs in vino-builder container. It watches VinoBuilderConfigs of its k8s node, and
+// realizes the config of its vino CR whenever any config on the node changes, so that cores of
+// domains no longer requested by other vino CRs are released as well. Container is ready only while
+// the latest configs are realized.
+type Agent struct {
+	// Owner is the vino CR, domains of which the agent realizes
+	Owner         client.ObjectKey
+	Realizer      Realizer
+	Reporter      *reporter.Reporter
+	DataFile      string
+	ReadinessFile string
+	Logger        logr.Logger
+
+	// converged is the state of configs on the node, that was realized last
+	converged string
+}
+
+// NewConfigInformer returns informer of VinoBuilderConfigs of all vino CRs on the k8s node
+func NewConfigInformer(config *rest.Config,
+	scheme *runtime.Scheme,
+	nodeName string,
+	resync time.Duration) (cache.SharedIndexInformer, error) {
+	restConfig := rest.CopyConfig(config)
+	restConfig.APIPath = "/apis"
+	restConfig.GroupVersion = &vinov1.GroupVersion
+	restConfig.NegotiatedSerializer = serializer.NewCodecFactory(scheme).WithoutConversion()
+	restClient, err := rest.RESTClientFor(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	selector := labels.Set{vinov1.VinoLabelNodeName: vinov1.NodeNameLabelValue(nodeName)}.String()
+	listWatch := cache.NewFilteredListWatchFromClient(restClient, "vinobuilderconfigs", metav1.NamespaceAll,
+		func(options *metav1.ListOptions) {
+			options.LabelSelector = selector
+		})
+	return cache.NewSharedIndexInformer(listWatch, &vinov1.VinoBuilderConfig{}, resync, cache.Indexers{}), nil
+}
+
+// Run syncs configs on every change seen by the informer until the context is done. Failed
+// syncs are retried with exponential backoff.
+func (a *Agent) Run(ctx context.Context, informer cache.SharedIndexInformer) error {
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+	enqueue := func(interface{}) { queue.Add(syncKey) }
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+		DeleteFunc: enqueue,
+	})
+
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return errors.New("timed out waiting for VinoBuilderConfigs informer to sync")
+	}
+	go func() {
+		<-ctx.Done()
+		queue.ShutDown()
+	}()
+
+	for {
+		key, shutdown := queue.Get()
+		if shutdown {
+			return nil
+		}
+		if err := a.Sync(ctx, configsFromStore(informer.GetStore())); err != nil {
+			a.Logger.Error(err, "failed to realize VinoBuilderConfig, retrying")
+			queue.AddRateLimited(key)
+		} else {
+			queue.Forget(key)
+		}
+		queue.Done(key)
+	}
+}
+
+// Sync realizes the config of own vino CR, if configs on the node have changed since they were
+// realized last, and reports state of domains to the config status
+func (a *Agent) Sync(ctx context.Context, configs []vinov1.VinoBuilderConfig) error {
+	own := a.ownConfig(configs)
+	if own == nil {
+		a.Logger.Info("Waiting for VinoBuilderConfig of vino CR", "vino", a.Owner)
+		a.converged = ""
+		return a.setReady(false)
+	}
+	state := configsState(configs)
+	if state == a.converged {
+		return nil
+	}
+	if err := a.setReady(false); err != nil {
+		return err
+	}
+
+	key := client.ObjectKeyFromObject(own)
+	logger := a.Logger.WithValues("config", key, "generation", own.Generation)
+	builder := own.Spec.Builder
+	buildErr := ValidateBuilder(builder)
+	if buildErr != nil {
+		buildErr = fmt.Errorf("invalid builder payload: %w", buildErr)
+	} else {
+		logger.Info("Realizing VinoBuilderConfig")
+		if buildErr = a.writeDataFile(builder, configs); buildErr == nil {
+			buildErr = a.Realizer.Realize(ctx, a.DataFile)
+		}
+	}
+
+	built, err := a.Reporter.Report(ctx, key, own.Generation, builder, buildErr)
+	switch {
+	case err != nil:
+		return err
+	case buildErr != nil:
+		return buildErr
+	case !built:
+		return fmt.Errorf("domains of VinoBuilderConfig %v are not realized", key)
+	}
+	logger.Info("VinoBuilderConfig is realized")
+	a.converged = state
+	return a.setReady(true)
+}
+
+// ownConfig returns config of vino CR of the agent
+func (a *Agent) ownConfig(configs []vinov1.VinoBuilderConfig) *vinov1.VinoBuilderConfig {
+	for i := range configs {
+		configLabels := configs[i].Labels
+		if configLabels[vinov1.VinoLabelDSNamespaceSelector] == a.Owner.Namespace &&
+			configLabels[vinov1.VinoLabelDSNameSelector] == a.Owner.Name {
+			return &configs[i]
+		}
+	}
+	return nil
+}
+
+// writeDataFile writes builder data for the playbook. Domains of all configs on the node are
+// added as nodeDomains, they are used to allocate cores. Owner is added, so that the playbook
+// removes domains of own vino CR, that are no longer requested, even if none are left.
+func (a *Agent) writeDataFile(builder vinov1.Builder, configs []vinov1.VinoBuilderConfig) error {
+	raw, err := json.Marshal(builder)
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{}
+	if err = json.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+	nodeDomains := []vinov1.BuilderDomain{}
+	for _, config := range configs {
+		nodeDomains = append(nodeDomains, config.Spec.Builder.Domains...)
+	}
+	data["nodeDomains"] = nodeDomains
+	data["domains"] = append([]vinov1.BuilderDomain{}, builder.Domains...)
+	data["owner"] = a.Owner.String()
+	if raw, err = json.Marshal(data); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(a.DataFile, raw, 0600)
+}
+
+// setReady creates readiness file if the agent has converged, and removes it otherwise
+func (a *Agent) setReady(ready bool) error {
+	if ready {
+		return ioutil.WriteFile(a.ReadinessFile, nil, 0644)
+	}
+	if err := os.Remove(a.ReadinessFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// configsState changes whenever any config on the node is created, updated or deleted
+func configsState(configs []vinov1.VinoBuilderConfig) string {
+	state := make([]string, 0, len(configs))
+	for _, config := range configs {
+		state = append(state, fmt.Sprintf("%s/%s/%d", config.Namespace, config.Name, config.Generation))
+	}
+	sort.Strings(state)
+	return strings.Join(state, ",")
+}
+
+func configsFromStore(store cache.Store) []vinov1.VinoBuilderConfig {
+	configs := []vinov1.VinoBuilderConfig{}
+	for _, obj := range store.List() {
+		if config, ok := obj.(*vinov1.VinoBuilderConfig); ok {
+			configs = append(configs, *config)
+		}
+	}
+	return configs
+}
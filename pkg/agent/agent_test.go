@@ -0,0 +1,172 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	vinov1 "vino/pkg/api/v1"
+	"vino/pkg/reporter"
+)
+
+// fakeVirsh returns canned outputs of virsh commands, commands without output fail
+type fakeVirsh map[string]string
+
+func (v fakeVirsh) Run(_ context.Context, args ...string) (string, error) {
+	command := strings.Join(args, " ")
+	out, ok := v[command]
+	if !ok {
+		return "", fmt.Errorf("virsh %s failed: domain not found", command)
+	}
+	return out, nil
+}
+
+// fakeRealizer records builder data it was called with
+type fakeRealizer struct {
+	data []map[string]interface{}
+}
+
+func (r *fakeRealizer) Realize(_ context.Context, dataFile string) error {
+	raw, err := ioutil.ReadFile(dataFile)
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{}
+	if err = json.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+	r.data = append(r.data, data)
+	return nil
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, vinov1.AddToScheme(scheme))
+	dir, err := ioutil.TempDir("", "vino-builder-agent")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := func(vinoName, domainName string, generation int64) vinov1.VinoBuilderConfig {
+		return vinov1.VinoBuilderConfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      vinoName + "-node01",
+				Namespace: "default",
+				Labels: map[string]string{
+					vinov1.VinoLabelNodeName:            "node01",
+					vinov1.VinoLabelDSNamespaceSelector: "default",
+					vinov1.VinoLabelDSNameSelector:      vinoName,
+				},
+				Generation:      generation,
+				ResourceVersion: "1",
+			},
+			Spec: vinov1.VinoBuilderConfigSpec{
+				NodeName: "node01",
+				Builder: vinov1.Builder{Domains: []vinov1.BuilderDomain{
+					{Name: domainName, Role: "worker", Owner: "default/" + vinoName},
+				}},
+			},
+		}
+	}
+	own := config("vino", "default-vino-node01-worker-0", 1)
+	other := config("other", "default-other-node01-worker-0", 1)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(own.DeepCopy()).Build()
+
+	realizer := &fakeRealizer{}
+	a := &Agent{
+		Owner:    client.ObjectKey{Namespace: "default", Name: "vino"},
+		Realizer: realizer,
+		Reporter: &reporter.Reporter{
+			Client: c,
+			Virsh: fakeVirsh{
+				"vol-list vino-default":                  "Name Path\n---\n default-vino-node01-worker-0 /pool/0\n",
+				"domstate default-vino-node01-worker-0":  "running\n",
+				"domuuid default-vino-node01-worker-0":   "8d1b6a62-3c3f-4f0e-9f55-4ab1b4f0e0a1\n",
+				"domiflist default-vino-node01-worker-0": "",
+			},
+			Logger: logr.Discard(),
+		},
+		DataFile:      filepath.Join(dir, "dynamic.yaml"),
+		ReadinessFile: filepath.Join(dir, "healthy"),
+		Logger:        logr.Discard(),
+	}
+	ready := func() bool {
+		_, err := os.Stat(a.ReadinessFile)
+		return err == nil
+	}
+
+	// config of own vino CR is not created yet
+	require.NoError(t, a.Sync(ctx, []vinov1.VinoBuilderConfig{other}))
+	assert.False(t, ready())
+	assert.Empty(t, realizer.data)
+
+	require.NoError(t, a.Sync(ctx, []vinov1.VinoBuilderConfig{own, other}))
+	assert.True(t, ready())
+	require.Len(t, realizer.data, 1)
+	nodeDomains := realizer.data[0]["nodeDomains"].([]interface{})
+	assert.Len(t, nodeDomains, 2)
+	assert.Len(t, realizer.data[0]["domains"].([]interface{}), 1)
+	assert.Equal(t, "default/vino", realizer.data[0]["owner"])
+
+	// nothing is realized again until configs change
+	require.NoError(t, a.Sync(ctx, []vinov1.VinoBuilderConfig{own, other}))
+	assert.Len(t, realizer.data, 1)
+	require.NoError(t, a.Sync(ctx, []vinov1.VinoBuilderConfig{own}))
+	require.Len(t, realizer.data, 2)
+	assert.Len(t, realizer.data[1]["nodeDomains"].([]interface{}), 1)
+	assert.True(t, ready())
+
+	// config without domains is realized, so that the playbook removes domains of own vino CR
+	empty := config("vino", "default-vino-node01-worker-0", 3)
+	empty.Spec.Builder.Domains = nil
+	require.NoError(t, a.Sync(ctx, []vinov1.VinoBuilderConfig{empty}))
+	require.Len(t, realizer.data, 3)
+	assert.Empty(t, realizer.data[2]["domains"])
+	assert.Equal(t, "default/vino", realizer.data[2]["owner"])
+	assert.True(t, ready())
+
+	// invalid payload is reported and never realized
+	invalid := config("vino", "default-vino-node01-worker-0", 4)
+	invalid.Spec.Builder.Domains = append(invalid.Spec.Builder.Domains, invalid.Spec.Builder.Domains[0])
+	err = a.Sync(ctx, []vinov1.VinoBuilderConfig{invalid})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicate domain name default-vino-node01-worker-0")
+	assert.False(t, ready())
+	assert.Len(t, realizer.data, 3)
+
+	result := &vinov1.VinoBuilderConfig{}
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(&own), result))
+	assert.Equal(t, int64(4), result.Status.ObservedGeneration)
+	condition := apimeta.FindStatusCondition(result.Status.Conditions, vinov1.ConditionTypeBuilt)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Contains(t, condition.Message, "invalid builder payload")
+}
@@ -0,0 +1,66 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"fmt"
+
+	kerror "k8s.io/apimachinery/pkg/util/errors"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+// ValidateBuilder checks that the builder payload can be realized by the playbook: networks and
+// domains are named uniquely, domain interfaces refer to existing networks, and VNC is configured
+// for domains that enable it. All problems are aggregated into the returned error.
+func ValidateBuilder(builder vinov1.Builder) error {
+	errs := []error{}
+	networks := map[string]struct{}{}
+	for i, network := range builder.Networks {
+		if network.Name == "" {
+			errs = append(errs, fmt.Errorf("networks[%d]: name is empty", i))
+			continue
+		}
+		if _, exists := networks[network.Name]; exists {
+			errs = append(errs, fmt.Errorf("networks[%d]: duplicate network name %s", i, network.Name))
+		}
+		networks[network.Name] = struct{}{}
+	}
+
+	domains := map[string]struct{}{}
+	for i, domain := range builder.Domains {
+		if domain.Name == "" {
+			errs = append(errs, fmt.Errorf("domains[%d]: name is empty", i))
+			continue
+		}
+		if _, exists := domains[domain.Name]; exists {
+			errs = append(errs, fmt.Errorf("domains[%d]: duplicate domain name %s", i, domain.Name))
+		}
+		domains[domain.Name] = struct{}{}
+		if domain.Role == "" {
+			errs = append(errs, fmt.Errorf("domain %s: role is empty", domain.Name))
+		}
+		if domain.EnableVNC && (domain.VNCPort == 0 || domain.VNCPasswordKey == "") {
+			errs = append(errs, fmt.Errorf("domain %s: VNC is enabled without port or password key", domain.Name))
+		}
+		for _, iface := range domain.Interfaces {
+			if _, exists := networks[iface.NetworkName]; !exists {
+				errs = append(errs, fmt.Errorf("domain %s: interface %s refers to undefined network %s",
+					domain.Name, iface.Name, iface.NetworkName))
+			}
+		}
+	}
+	return kerror.NewAggregate(errs)
+}
@@ -0,0 +1,87 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+func TestValidateBuilder(t *testing.T) {
+	network := func(name string) vinov1.BuilderNetwork {
+		return vinov1.BuilderNetwork{Network: vinov1.Network{Name: name}}
+	}
+	iface := func(name, network string) vinov1.BuilderNetworkInterface {
+		return vinov1.BuilderNetworkInterface{
+			NetworkInterface: vinov1.NetworkInterface{Name: name, NetworkName: network},
+		}
+	}
+	tests := []struct {
+		name    string
+		builder vinov1.Builder
+		errs    []string
+	}{
+		{
+			name: "valid",
+			builder: vinov1.Builder{
+				Networks: []vinov1.BuilderNetwork{network("pxe"), network("mgmt")},
+				Domains: []vinov1.BuilderDomain{{
+					Name:           "default-vino-node01-worker-0",
+					Role:           "worker",
+					EnableVNC:      true,
+					VNCPort:        5900,
+					VNCPasswordKey: "default-vino-node01-worker-0",
+					Interfaces:     []vinov1.BuilderNetworkInterface{iface("eth0", "pxe"), iface("eth1", "mgmt")},
+				}},
+			},
+		},
+		{
+			name: "invalid",
+			builder: vinov1.Builder{
+				Networks: []vinov1.BuilderNetwork{network("pxe"), network("pxe"), network("")},
+				Domains: []vinov1.BuilderDomain{
+					{Name: "worker-0", Role: "worker", EnableVNC: true, VNCPort: 5900},
+					{Name: "worker-0", Interfaces: []vinov1.BuilderNetworkInterface{iface("eth0", "mgmt")}},
+					{Role: "worker"},
+				},
+			},
+			errs: []string{
+				"networks[1]: duplicate network name pxe",
+				"networks[2]: name is empty",
+				"domain worker-0: VNC is enabled without port or password key",
+				"domains[1]: duplicate domain name worker-0",
+				"domain worker-0: role is empty",
+				"domain worker-0: interface eth0 refers to undefined network mgmt",
+				"domains[2]: name is empty",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateBuilder(tt.builder)
+			if len(tt.errs) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			for _, msg := range tt.errs {
+				assert.Contains(t, err.Error(), msg)
+			}
+		})
+	}
+}
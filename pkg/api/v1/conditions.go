@@ -33,6 +33,26 @@ const (
 	// the DaemonSet has succeeded.
 	ConditionTypeDaemonSetReady string = "DaemonSetReady"
 
+	// ConditionTypeVMsRealized represents the fact that vino-builders have reported all
+	// VMs of the resource as realized, so that BMHs are created for them.
+	ConditionTypeVMsRealized string = "VMsRealized"
+
+	// ConditionTypeScaledDown represents the fact that VMs above count of every node set of the
+	// resource have been removed.
+	ConditionTypeScaledDown string = "ScaledDown"
+
+	// ConditionTypePlacementSatisfied represents the fact that VMs of every node set of the
+	// resource are placed on k8s nodes according to node set selectors and anti-affinity.
+	ConditionTypePlacementSatisfied string = "PlacementSatisfied"
+
+	// ConditionTypeHostCapacitySufficient represents the fact that VMs of the resource together
+	// with VMs of other resources fit CPUs, memory and storage of every k8s node they are requested on.
+	ConditionTypeHostCapacitySufficient string = "HostCapacitySufficient"
+
+	// ConditionTypeTeardownComplete represents the fact that everything created
+	// for the resource has been removed and the finalizer can be released.
+	ConditionTypeTeardownComplete string = "TeardownComplete"
+
 	// ReconciliationSucceededReason represents the fact that reconciliation has succeeded.
 	ReconciliationSucceededReason string = "ReconciliationSucceeded"
 
@@ -42,4 +62,33 @@ const (
 	// ProgressingReason represents the fact that the reconciliation of the
 	// resource is underway.
 	ProgressingReason string = "Progressing"
+
+	// PhaseTimedOutReason represents the fact that the resource has stayed in a reconciliation
+	// phase for longer than its timeout. Reconciliation goes on, the condition clears when the
+	// phase is passed.
+	PhaseTimedOutReason string = "PhaseTimedOut"
+
+	// VMRealizationFailedReason represents the fact that vino-builders have failed to
+	// realize some VMs of the resource.
+	VMRealizationFailedReason string = "VMRealizationFailed"
+
+	// ScaleDownBlockedReason represents the fact that some VMs above count of their node set
+	// are kept, because their BMHs are protected from deletion or are in use.
+	ScaleDownBlockedReason string = "ScaleDownBlocked"
+
+	// PlacementUnsatisfiableReason represents the fact that fewer VMs of some node sets of the
+	// resource are placed than requested, because there are not enough k8s nodes for them.
+	PlacementUnsatisfiableReason string = "PlacementUnsatisfiable"
+
+	// InsufficientHostCapacityReason represents the fact that VMs of the resource are not
+	// requested, because some k8s node doesn't have capacity for them.
+	InsufficientHostCapacityReason string = "InsufficientHostCapacity"
+
+	// TeardownInProgressReason represents the fact that the resource is being deleted
+	// and objects created for it are being removed.
+	TeardownInProgressReason string = "TeardownInProgress"
+
+	// TeardownFailedReason represents the fact that some objects created for the resource
+	// could not be removed.
+	TeardownFailedReason string = "TeardownFailed"
 )
@@ -0,0 +1,56 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+// VinoHostInventoryAnnotation is the annotation of k8s nodes with JSON encoded HostInventory,
+// it is published by nodelabeler
+const VinoHostInventoryAnnotation = "airshipit.org/vino.host-inventory"
+
+// HostInventory describes resources of a k8s node, that VMs are built from. Totals are used to
+// check, that VMs of all vino CRs on the node fit it, free values are informational only, since
+// they already exclude resources of running VMs.
+type HostInventory struct {
+	// NUMANodes of the host
+	NUMANodes []NUMANodeInventory `json:"numaNodes"`
+	// StoragePools VM volumes are created in
+	StoragePools []StoragePoolInventory `json:"storagePools,omitempty"`
+}
+
+// NUMANodeInventory describes CPUs and memory of a host NUMA node
+type NUMANodeInventory struct {
+	// ID of the NUMA node, as in numaNode of VinoFlavor
+	ID int `json:"id"`
+	// CPUs are online cores of the NUMA node in cpuset list format, for example 0-3,8-11.
+	// CPUExclude of vino CR is not applied, it is applied when VMs are checked against them
+	CPUs string `json:"cpus"`
+	// MemoryMiB is the total memory of the NUMA node
+	MemoryMiB int64 `json:"memoryMiB"`
+	// FreeMemoryMiB is the memory of the NUMA node, that is not used
+	FreeMemoryMiB int64 `json:"freeMemoryMiB"`
+	// HugePages is the number of 1GiB huge pages reserved on the NUMA node
+	HugePages int64 `json:"hugepages"`
+	// FreeHugePages is the number of 1GiB huge pages, that are not used
+	FreeHugePages int64 `json:"freeHugepages"`
+}
+
+// StoragePoolInventory describes space of a libvirt storage pool directory
+type StoragePoolInventory struct {
+	// Name of the storage pool
+	Name string `json:"name"`
+	// CapacityGiB is the size of the file system of the storage pool
+	CapacityGiB int64 `json:"capacityGiB"`
+	// AvailableGiB is the free space of the file system of the storage pool
+	AvailableGiB int64 `json:"availableGiB"`
+}
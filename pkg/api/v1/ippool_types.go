@@ -33,7 +33,11 @@ type IPPoolSpec struct {
 	// MACPrefix defines the MAC prefix to use for VM mac addresses
 	MACPrefix string `json:"macPrefix"`
 	// NextMAC indicates the next MAC address (in sequence) that
-	// will be provisioned to a VM in this Subnet
+	// will be provisioned to a VM in this Subnet. MAC addresses are
+	// reused: when an IP is released, its MAC address is freed as well,
+	// and the lowest free MAC address starting from MACPrefix is always
+	// provisioned next. A MAC address is never given to two entities at
+	// the same time, but may be given to a new entity after it's released.
 	NextMAC string `json:"nextMAC"`
 }
 
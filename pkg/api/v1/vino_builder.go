@@ -30,17 +30,23 @@ type Builder struct {
 }
 
 type BuilderNetworkInterface struct {
-	IPAddress        string `json:"ipAddress,omitempty"`
-	NetMask          string `json:"netMask,omitempty"`
-	MACAddress       string `json:"macAddress,omitempty"`
+	IPAddress string `json:"ipAddress,omitempty"`
+	// NetMask is dotted decimal netmask for IPv4 and prefix length for IPv6 address
+	NetMask    string `json:"netMask,omitempty"`
+	MACAddress string `json:"macAddress,omitempty"`
+	// IPv6Address and IPv6NetMask, which is prefix length, are set if the network is dual-stack
+	IPv6Address      string `json:"ipv6Address,omitempty"`
+	IPv6NetMask      string `json:"ipv6NetMask,omitempty"`
 	NetworkInterface `json:",inline"`
 }
 
 type BuilderNetwork struct {
 	BridgeIP  string `json:"bridgeIP,omitempty"`
 	BridgeMAC string `json:"bridgeMAC,omitempty"`
-	Range     Range  `json:"range,omitempty"`
-	Network   `json:",inline"`
+	// BridgeIPv6 is set if the network is dual-stack
+	BridgeIPv6 string `json:"bridgeIPv6,omitempty"`
+	Range      Range  `json:"range,omitempty"`
+	Network    `json:",inline"`
 }
 
 // BuilderDomain represents a VINO libvirt domain
@@ -49,7 +55,16 @@ type BuilderDomain struct {
 	Role           string `json:"role,omitempty"`
 	BootMACAddress string `json:"bootMACAddress,omitempty"`
 	EnableVNC      bool   `json:"enableVNC,omitempty"`
-	VNCPassword    string `json:"vncPassword,omitempty"`
+	// VNCPasswordKey is a key of VNC password in the secret mounted to vino-builder,
+	// passwords are never put into VinoBuilderConfigs
+	VNCPasswordKey string `json:"vncPasswordKey,omitempty"`
+	VNCPort        int    `json:"vncPort,omitempty"`
+	// Owner is <namespace>/<name> of vino CR, that requested the domain. It is recorded in domain
+	// metadata, so that vino-builder of the vino CR finds and removes domains it no longer requests
+	Owner string `json:"owner,omitempty"`
+	// Flavor is the sizing of the domain resolved from VinoFlavor of its node set. vino-builder
+	// takes the flavor named after the domain role from its flavors, if it is not set
+	Flavor *VinoFlavorSpec `json:"flavor,omitempty"`
 
 	Interfaces []BuilderNetworkInterface `json:"interfaces,omitempty"`
 }
@@ -17,6 +17,8 @@ limitations under the License.
 package v1
 
 import (
+	"time"
+
 	corev1 "k8s.io/api/core/v1"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -35,14 +37,47 @@ const (
 	EnvVarVMInterfaceName = "VM_BRIDGE_INTERFACE"
 	// VinoDefaultGatewayBridgeLabel is used to identify ip address of the default gateway for the VM
 	VinoDefaultGatewayBridgeLabel = "airshipit.org/vino.nodebridgegw"
-	// VinoNodeNetworkValuesAnnotation vino controller saves ip and mac address information for the node in it
-	VinoNodeNetworkValuesAnnotation = "airshipit.org/vino.network-values"
 	// VinoNetworkDataTemplateDefaultKey expected template key networkdata template secret for vino node
 	VinoNetworkDataTemplateDefaultKey = "template"
 	// VinoDefaultRootDeviceName is default root device for the underlying libvirt VM
 	VinoDefaultRootDeviceName = "/dev/vda"
 	// VinoDefaultInstanceSubnetBitStep is the value for InstanceSubnetBitStep
 	VinoDefaultInstanceSubnetBitStep = 4
+	// VinoDefaultMACPrefix is a private RFC 1918 MAC range used if
+	// no MACPrefix is specified for a network in the ViNO CR
+	VinoDefaultMACPrefix = "02:00:00:00:00:00"
+	// VinoDefaultDaemonSetTemplateName is the name of the configmap in the runtime namespace
+	// that is used as DaemonSet template if none is specified in the ViNO CR
+	VinoDefaultDaemonSetTemplateName = "vino-daemonset-template"
+	// VinoDefaultScheduleTimeout is default time to wait for daemonset pods to be scheduled
+	VinoDefaultScheduleTimeout = 180 * time.Second
+	// VinoDefaultReadyTimeout is default time to wait for vino-builders to become ready
+	VinoDefaultReadyTimeout = 180 * time.Second
+	// VinoDefaultNodeRemovalGracePeriod is default time VMs of a removed k8s node are kept for
+	VinoDefaultNodeRemovalGracePeriod = 10 * time.Minute
+	// VinoDeletionProtectionAnnotation set to "true" on a BMH keeps its VM, when count of the node
+	// set is lowered below the VM index, even if ForceScaleDown is set on the node set
+	VinoDeletionProtectionAnnotation = "airshipit.org/vino.deletion-protection"
+	// VinoRotateCredentialsAnnotation triggers rotation of generated BMC credentials, when its
+	// value on vino CR differs from the last rotation recorded in vino status
+	VinoRotateCredentialsAnnotation = "airshipit.org/vino.rotate-credentials"
+)
+
+// VinoPhase is a step of vino CR reconciliation
+type VinoPhase string
+
+// Phases of vino CR reconciliation, in the order they are reached
+const (
+	// VinoPhaseDaemonSetApplied DaemonSet is created or updated in the cluster
+	VinoPhaseDaemonSetApplied VinoPhase = "DaemonSetApplied"
+	// VinoPhaseDaemonSetScheduled all DaemonSet pods are scheduled to the nodes
+	VinoPhaseDaemonSetScheduled VinoPhase = "DaemonSetScheduled"
+	// VinoPhaseVMsRequested VMs are requested from vino-builders
+	VinoPhaseVMsRequested VinoPhase = "VMsRequested"
+	// VinoPhaseBuildersReady all vino-builders reported that they are ready
+	VinoPhaseBuildersReady VinoPhase = "BuildersReady"
+	// VinoPhaseBMHsCreated BMHs for all VMs are created
+	VinoPhaseBMHsCreated VinoPhase = "BMHsCreated"
 )
 
 // Constants for BasicAuth
@@ -51,6 +86,28 @@ const (
 	// NOTE: gosec thinks this is a hard-coded password, when in reality it
 	// is the name of an environment variable. We'll suppress that error
 	EnvVarBasicAuthPassword = "BASIC_AUTH_PASSWORD" //nolint:gosec
+
+	// VinoBMCCredentialsUsernameKey is a key of the username in BMC credentials secret
+	VinoBMCCredentialsUsernameKey = "username"
+	// VinoBMCCredentialsPasswordKey is a key of the password in BMC credentials secret
+	VinoBMCCredentialsPasswordKey = "password" //nolint:gosec
+	// VinoBMCHtpasswdKey is a key of htpasswd file in the secret, that is mounted to sushy
+	VinoBMCHtpasswdKey = "htpasswd"
+	// VinoVNCPasswordKey is a key of the password in VNC password secret
+	VinoVNCPasswordKey = "password" //nolint:gosec
+	// VinoVNCBasePort is VNC port of the first VM on a node, following VMs get consecutive ports
+	VinoVNCBasePort = 5900
+)
+
+// CredentialsRotationPhase is a step of generated BMC credentials rotation
+type CredentialsRotationPhase string
+
+// Phases of BMC credentials rotation, in the order they are reached
+const (
+	// CredentialsRotationSushyUpdated sushy accepts both old and new credentials, BMHs use old ones
+	CredentialsRotationSushyUpdated CredentialsRotationPhase = "SushyUpdated"
+	// CredentialsRotationBMHsUpdated BMHs use new credentials, sushy still accepts old ones
+	CredentialsRotationBMHsUpdated CredentialsRotationPhase = "BMHsUpdated"
 )
 
 // VinoSpec defines the desired state of Vino
@@ -75,13 +132,26 @@ type VinoSpec struct {
 	PXEBootImageHost string `json:"pxeBootImageHost,omitempty"`
 	// PXEBootImageHostPort will be used to download the PXE boot image
 	PXEBootImageHostPort int `json:"pxeBootImageHostPort,omitempty"`
+	// NodeRemovalGracePeriod is how long VMs of a k8s node, that no longer runs vino-builder of
+	// vino CR, are kept. BMHs of the node are paused during the grace period, and are deleted
+	// together with IPAM allocations of the node after it. Default is 10m
+	NodeRemovalGracePeriod *metav1.Duration `json:"nodeRemovalGracePeriod,omitempty"`
 }
 
 // BMCCredentials contain credentials that will be used to create BMH nodes
 // sushy tools will use these credentials as well, to set up authentication
 type BMCCredentials struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	// Username is deprecated, it's used only if CredentialsSecretRef is not set
+	Username string `json:"username,omitempty"`
+	// Password is deprecated, it's used only if CredentialsSecretRef is not set
+	Password string `json:"password,omitempty"`
+	// CredentialsSecretRef references a secret with "username" and "password" keys.
+	// Namespace of vino CR is used if namespace of the secret is not set
+	CredentialsSecretRef *NamespacedName `json:"credentialsSecretRef,omitempty"`
+	// Generate makes vino generate unique BMC credentials for every VM. sushy accepts all of
+	// them, while each BMH uses credentials of its own VM. Generated credentials are rotated
+	// when the value of VinoRotateCredentialsAnnotation on vino CR changes.
+	Generate bool `json:"generate,omitempty"`
 }
 
 // NodeSelector identifies nodes to create VMs on
@@ -126,6 +196,20 @@ type Network struct {
 	// BridgeName is the name of the bridge to be created as libvirt network.
 	// works if AllocateNodeIP is sepcified
 	BridgeName string `json:"bridgeName,omitempty"`
+	// IPv6 makes the network dual-stack, SubNet and allocation ranges above define
+	// IPv4 addressing and IPv6 defines IPv6 addressing. VM interfaces and node bridges
+	// in a dual-stack network get addresses from both families.
+	IPv6 *IPv6Network `json:"ipv6,omitempty"`
+}
+
+// IPv6Network defines IPv6 addressing of a dual-stack network
+type IPv6Network struct {
+	// SubNet is IPv6 subnet of the network
+	SubNet string `json:"subnet"`
+	// StaticAllocationStart must be inside the SubNet range
+	StaticAllocationStart string `json:"staticAllocationStart"`
+	// StaticAllocationStop must be inside the SubNet range
+	StaticAllocationStop string `json:"staticAllocationStop"`
 }
 
 // VMRoutes defined
@@ -138,8 +222,25 @@ type VMRoutes struct {
 //NodeSet node definitions
 type NodeSet struct {
 	// Parameter for Node control-plane or worker
-	Name  string `json:"name,omitempty"`
-	Count int    `json:"count,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Count of VMs of the node set on every k8s node, or in total across k8s nodes if placement
+	// mode is Total. When it is lowered, VMs with the highest indexes are removed first, VMs whose
+	// BMHs are provisioned or consumed are kept, unless ForceScaleDown is set
+	Count int `json:"count,omitempty"`
+	// ForceScaleDown removes VMs above Count even if their BMHs are provisioned or consumed. VMs
+	// whose BMHs have VinoDeletionProtectionAnnotation are kept anyway
+	ForceScaleDown bool `json:"forceScaleDown,omitempty"`
+	// Placement defines how VMs of the node set are placed on k8s nodes. Count VMs are created on
+	// every k8s node, if it is not set
+	Placement *NodeSetPlacement `json:"placement,omitempty"`
+	// NodeSelector restricts VMs of the node set to k8s nodes with these labels, among the nodes
+	// selected by node selector of vino CR
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// HostAntiAffinity allows at most one VM of the node set on a k8s node
+	HostAntiAffinity bool `json:"hostAntiAffinity,omitempty"`
+	// Flavor is the name of VinoFlavor, that VMs of the node set are sized by. If it is not set,
+	// the flavor named after the node set is taken from vino-flavors config map
+	Flavor string `json:"flavor,omitempty"`
 	// BMHLabels labels will be copied directly to BMHs that will be created
 	// These labels will override keys from k8s node, that are specified in vino.NodeLabelKeysToCopy
 	BMHLabels                 map[string]string    `json:"bmhLabels,omitempty"`
@@ -155,6 +256,36 @@ type NodeSet struct {
 	BootInterfaceName string `json:"bootInterfaceName,omitempty"`
 	// EnableVNC create VNC for graphical interaction with the VM that will be created.
 	EnableVNC bool `json:"enableVNC,omitempty"`
+	// VNCPasswordSecretRef references a secret with "password" key, that is used as VNC password
+	// of all VMs in the node set. Unique password is generated for every VM if it is not set.
+	// Namespace of vino CR is used if namespace of the secret is not set
+	VNCPasswordSecretRef *NamespacedName `json:"vncPasswordSecretRef,omitempty"`
+}
+
+// PlacementMode defines what Count of a node set means
+// +kubebuilder:validation:Enum=PerNode;Total
+type PlacementMode string
+
+const (
+	// PlacementPerNode creates Count VMs of the node set on every k8s node
+	PlacementPerNode PlacementMode = "PerNode"
+	// PlacementTotal creates Count VMs of the node set in total, spread across k8s nodes
+	PlacementTotal PlacementMode = "Total"
+)
+
+// NodeSetPlacement defines how VMs of a node set are placed on k8s nodes
+type NodeSetPlacement struct {
+	// Mode defines whether Count of the node set is per k8s node or total, default is PerNode
+	// +kubebuilder:default=PerNode
+	Mode PlacementMode `json:"mode,omitempty"`
+	// TopologyKey is a label of k8s nodes, VMs are spread evenly across its values, for example
+	// rack or zone. k8s nodes without the label get no VMs of the node set. VMs are spread across
+	// k8s nodes, if it is not set. Used only in Total mode
+	TopologyKey string `json:"topologyKey,omitempty"`
+	// MaxSkew is the maximum difference between numbers of VMs of the node set in any two
+	// topology domains, default is 1. Used only in Total mode
+	// +kubebuilder:validation:Minimum=1
+	MaxSkew int `json:"maxSkew,omitempty"`
 }
 
 // NamespacedName to be used to spawn VMs
@@ -170,6 +301,12 @@ type DaemonSetOptions struct {
 	SushyImage   string         `json:"sushyImage,omitempty"`
 	VinoBuilder  string         `json:"vinoBuilderImage,omitempty"`
 	NodeLabeler  string         `json:"nodeAnnotatorImage,omitempty"`
+	// ScheduleTimeout is how long to wait for daemonset pods to be scheduled, before the wait
+	// is reported as timed out in vino CR conditions, default is 180s
+	ScheduleTimeout *metav1.Duration `json:"scheduleTimeout,omitempty"`
+	// ReadyTimeout is how long to wait for vino-builders to become ready after VMs are requested,
+	// before the wait is reported as timed out in vino CR conditions, default is 180s
+	ReadyTimeout *metav1.Duration `json:"readyTimeout,omitempty"`
 }
 
 // NetworkInterface define interface on the VM
@@ -225,6 +362,102 @@ func init() {
 type VinoStatus struct {
 	ConfigMapRef corev1.ObjectReference `json:"configMapRef,omitempty"`
 	Conditions   []metav1.Condition     `json:"conditions,omitempty"`
+	// Phase is the last reconciliation step that vino CR has reached
+	Phase VinoPhase `json:"phase,omitempty"`
+	// PhaseTransitionTime is the time when vino CR entered current phase
+	PhaseTransitionTime *metav1.Time `json:"phaseTransitionTime,omitempty"`
+	// Nodes lists k8s nodes vino-builders are running on, with VMs created on each of them
+	Nodes []NodeStatus `json:"nodes,omitempty"`
+	// Credentials is the state of generated BMC credentials
+	Credentials CredentialsStatus `json:"credentials,omitempty"`
+	// Placements of node sets in Total mode. VMs stay on k8s nodes they are placed on, until
+	// the nodes are gone or spread of VMs exceeds max skew
+	Placements []NodeSetPlacementStatus `json:"placements,omitempty"`
+}
+
+// NodeSetPlacementStatus is the number of VMs of a node set placed on each k8s node
+type NodeSetPlacementStatus struct {
+	// Name of the node set
+	Name string `json:"name"`
+	// Nodes the VMs are placed on
+	Nodes []NodePlacement `json:"nodes,omitempty"`
+}
+
+// NodePlacement is the number of VMs of a node set placed on a k8s node
+type NodePlacement struct {
+	// Name of the k8s node
+	Name string `json:"name"`
+	// Count of VMs of the node set on the k8s node
+	Count int `json:"count"`
+}
+
+// CredentialsStatus is the observed state of generated BMC credentials
+type CredentialsStatus struct {
+	// ObservedRotation is the value of rotation annotation, the last rotation was triggered by
+	ObservedRotation string `json:"observedRotation,omitempty"`
+	// RotationPhase is the phase of rotation in progress, empty if no rotation is in progress
+	RotationPhase CredentialsRotationPhase `json:"rotationPhase,omitempty"`
+	// RotationPhaseTransitionTime is the time when rotation entered current phase
+	RotationPhaseTransitionTime *metav1.Time `json:"rotationPhaseTransitionTime,omitempty"`
+}
+
+// NodeStatus is the observed state of a k8s node that vino-builder is running on
+type NodeStatus struct {
+	// Name of the k8s node
+	Name string `json:"name"`
+	// BuilderReady is true if vino-builder pod on the node is ready
+	BuilderReady bool `json:"builderReady"`
+	// Networks contain values allocated for the node in each of vino networks
+	Networks []NodeNetworkStatus `json:"networks,omitempty"`
+	// VMs created on the node
+	VMs []VMStatus `json:"vms,omitempty"`
+	// RemovedAt is set when vino-builder no longer runs on the node, VMs of the node are removed
+	// when NodeRemovalGracePeriod passes after it
+	RemovedAt *metav1.Time `json:"removedAt,omitempty"`
+}
+
+// NodeNetworkStatus contains values allocated for a k8s node in a vino network
+type NodeNetworkStatus struct {
+	// Name of the network
+	Name string `json:"name"`
+	// Range is DHCP range allocated for VMs on the node
+	Range Range `json:"range,omitempty"`
+	// BridgeIP is IP address of the network bridge on the node
+	BridgeIP string `json:"bridgeIP,omitempty"`
+	// BridgeMAC is MAC address of the network bridge on the node
+	BridgeMAC string `json:"bridgeMAC,omitempty"`
+	// BridgeIPv6 is IPv6 address of the network bridge on the node, if the network is dual-stack
+	BridgeIPv6 string `json:"bridgeIPv6,omitempty"`
+}
+
+// VMStatus is the observed state of a VM and BMH representing it
+type VMStatus struct {
+	// BMHName is the name of BareMetalHost created for the VM
+	BMHName string `json:"bmhName"`
+	// Role is the name of vino node set the VM belongs to
+	Role string `json:"role,omitempty"`
+	// BootMACAddress is MAC address of the VM boot interface
+	BootMACAddress string `json:"bootMACAddress,omitempty"`
+	// Interfaces of the VM with their allocated addresses
+	Interfaces []VMInterfaceStatus `json:"interfaces,omitempty"`
+	// ProvisioningState is mirrored from the BareMetalHost
+	ProvisioningState string `json:"provisioningState,omitempty"`
+	// VNCEndpoint is host IP and port of VM console, if VNC is enabled for the VM
+	VNCEndpoint string `json:"vncEndpoint,omitempty"`
+}
+
+// VMInterfaceStatus contains addresses allocated for a VM network interface
+type VMInterfaceStatus struct {
+	// Name of the interface
+	Name string `json:"name"`
+	// NetworkName is the name of vino network the interface is attached to
+	NetworkName string `json:"networkName,omitempty"`
+	// IPAddress allocated for the interface
+	IPAddress string `json:"ipAddress,omitempty"`
+	// MACAddress allocated for the interface
+	MACAddress string `json:"macAddress,omitempty"`
+	// IPv6Address allocated for the interface, if the network is dual-stack
+	IPv6Address string `json:"ipv6Address,omitempty"`
 }
 
 // VinoProgressing registers progress toward reconciling the given Vino
@@ -250,3 +483,14 @@ func VinoReady(v *Vino) {
 		ObservedGeneration: v.GetGeneration(),
 	})
 }
+
+// SetVinoPhase registers that vino CR has reached the given phase, phase transition time
+// is only changed if phase is different from the current one.
+func SetVinoPhase(v *Vino, phase VinoPhase) {
+	if v.Status.Phase == phase && v.Status.PhaseTransitionTime != nil {
+		return
+	}
+	now := metav1.Now()
+	v.Status.Phase = phase
+	v.Status.PhaseTransitionTime = &now
+}
@@ -0,0 +1,390 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// bmhSecretLongestSuffix is the longest suffix vino adds to BMH name to create a secret name
+	bmhSecretLongestSuffix = "-network-data"
+	// validatingWebhookPath is the path of vino validating webhook
+	validatingWebhookPath = "/validate-airship-airshipit-org-v1-vino"
+)
+
+var vinolog = logf.Log.WithName("vino-webhook")
+
+// SetupWebhookWithManager registers vino webhooks with the manager. Validating webhook lists
+// k8s nodes with the manager client, so it is registered with its own handler
+func (r *Vino) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	if err := ctrl.NewWebhookManagedBy(mgr).For(r).Complete(); err != nil {
+		return err
+	}
+	mgr.GetWebhookServer().Register(validatingWebhookPath,
+		&webhook.Admission{Handler: &vinoValidator{client: mgr.GetClient()}})
+	return nil
+}
+
+// +kubebuilder:webhook:path=/mutate-airship-airshipit-org-v1-vino,mutating=true,failurePolicy=fail,groups=airship.airshipit.org,resources=vinoes,verbs=create;update,versions=v1,name=mvino.airshipit.org
+
+var _ webhook.Defaulter = &Vino{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type. It writes
+// values that would otherwise be implied by the controller into the spec, so that changing
+// the defaults doesn't change already existing vino CRs
+func (r *Vino) Default() {
+	vinolog.Info("default", "name", r.Name, "namespace", r.Namespace)
+
+	for i := range r.Spec.Networks {
+		network := &r.Spec.Networks[i]
+		if network.MACPrefix == "" {
+			network.MACPrefix = VinoDefaultMACPrefix
+		}
+		if network.InstanceSubnetBitStep == 0 {
+			network.InstanceSubnetBitStep = VinoDefaultInstanceSubnetBitStep
+		}
+	}
+
+	for i := range r.Spec.Nodes {
+		if r.Spec.Nodes[i].RootDeviceName == "" {
+			r.Spec.Nodes[i].RootDeviceName = VinoDefaultRootDeviceName
+		}
+		if ref := r.Spec.Nodes[i].VNCPasswordSecretRef; ref != nil && ref.Namespace == "" {
+			ref.Namespace = r.Namespace
+		}
+	}
+
+	if ref := r.Spec.BMCCredentials.CredentialsSecretRef; ref != nil && ref.Namespace == "" {
+		ref.Namespace = r.Namespace
+	}
+
+	options := &r.Spec.DaemonSetOptions
+	if options.Template == (NamespacedName{}) {
+		options.Template = NamespacedName{
+			Name:      VinoDefaultDaemonSetTemplateName,
+			Namespace: os.Getenv("RUNTIME_NAMESPACE"),
+		}
+	}
+	if options.ScheduleTimeout == nil {
+		options.ScheduleTimeout = &metav1.Duration{Duration: VinoDefaultScheduleTimeout}
+	}
+	if options.ReadyTimeout == nil {
+		options.ReadyTimeout = &metav1.Duration{Duration: VinoDefaultReadyTimeout}
+	}
+	if r.Spec.NodeRemovalGracePeriod == nil {
+		r.Spec.NodeRemovalGracePeriod = &metav1.Duration{Duration: VinoDefaultNodeRemovalGracePeriod}
+	}
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-airship-airshipit-org-v1-vino,mutating=false,failurePolicy=fail,groups=airship.airshipit.org,resources=vinoes,versions=v1,name=vvino.airshipit.org
+
+// vinoValidator validates created and updated vino CRs, client is used to find k8s nodes that
+// vino CR is going to be scheduled to. webhook.Validator of controller-runtime gets neither
+// context nor client, so vino CR can't implement it without a package level client
+type vinoValidator struct {
+	client  client.Client
+	decoder *admission.Decoder
+}
+
+var _ admission.Handler = &vinoValidator{}
+var _ admission.DecoderInjector = &vinoValidator{}
+
+// InjectDecoder implements admission.DecoderInjector
+func (v *vinoValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+// Handle implements admission.Handler. Updates of vino CRs, that are being deleted, and updates,
+// that don't change the spec, are always allowed, so that finalizers and metadata of vino CRs,
+// that became invalid because of changes of k8s nodes, can still be updated
+func (v *vinoValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+	vino := &Vino{}
+	if err := v.decoder.Decode(req, vino); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if req.Operation == admissionv1.Update {
+		if vino.DeletionTimestamp != nil {
+			return admission.Allowed("vino CR is being deleted")
+		}
+		old := &Vino{}
+		if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if equality.Semantic.DeepEqual(vino.Spec, old.Spec) {
+			return admission.Allowed("spec is not changed")
+		}
+	}
+	vinolog.Info("validate", "operation", req.Operation, "name", vino.Name, "namespace", vino.Namespace)
+
+	err := vino.validate(ctx, v.client)
+	if err == nil {
+		return admission.Allowed("")
+	}
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) {
+		status := apiStatus.Status()
+		return admission.Response{AdmissionResponse: admissionv1.AdmissionResponse{Allowed: false, Result: &status}}
+	}
+	return admission.Denied(err.Error())
+}
+
+// validate checks vino CR, k8s nodes it is going to be scheduled to are listed with the client,
+// if it is not nil
+func (r *Vino) validate(ctx context.Context, c client.Client) error {
+	nodeNames, err := r.selectedNodeNames(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	allErrs := field.ErrorList{}
+	specPath := field.NewPath("spec")
+	networks := map[string]Network{}
+	for i, network := range r.Spec.Networks {
+		networks[network.Name] = network
+		allErrs = append(allErrs, validateNetwork(network, len(nodeNames), specPath.Child("networks").Index(i))...)
+	}
+
+	for i, node := range r.Spec.Nodes {
+		allErrs = append(allErrs,
+			r.validateNodeSet(node, networks, nodeNames, specPath.Child("nodes").Index(i))...)
+	}
+
+	allErrs = append(allErrs, validateBMCCredentials(r.Spec.BMCCredentials, specPath.Child("bmcCredentials"))...)
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Vino").GroupKind(), r.Name, allErrs)
+}
+
+// selectedNodeNames returns names of k8s nodes that match vino node selector
+func (r *Vino) selectedNodeNames(ctx context.Context, c client.Client) ([]string, error) {
+	if c == nil || r.Spec.NodeSelector == nil {
+		return nil, nil
+	}
+	nodeList := &corev1.NodeList{}
+	if err := c.List(ctx, nodeList,
+		client.MatchingLabels(r.Spec.NodeSelector.MatchLabels)); err != nil {
+		return nil, fmt.Errorf("unable to list nodes matching vino node selector: %w", err)
+	}
+	names := []string{}
+	for _, node := range nodeList.Items {
+		names = append(names, node.Name)
+	}
+	return names, nil
+}
+
+// validateBMCCredentials makes sure that credentials from a secret are not mixed with
+// deprecated plain text credentials
+func validateBMCCredentials(credentials BMCCredentials, path *field.Path) field.ErrorList {
+	ref := credentials.CredentialsSecretRef
+	if ref == nil {
+		return nil
+	}
+	allErrs := field.ErrorList{}
+	if ref.Name == "" {
+		allErrs = append(allErrs, field.Required(path.Child("credentialsSecretRef", "name"), ""))
+	}
+	if credentials.Username != "" || credentials.Password != "" {
+		allErrs = append(allErrs, field.Forbidden(path,
+			"username and password must not be set together with credentialsSecretRef"))
+	}
+	return allErrs
+}
+
+func validateNetwork(network Network, nodeCount int, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if network.MACPrefix != "" {
+		if mac, err := net.ParseMAC(network.MACPrefix); err != nil || len(mac) != 6 {
+			allErrs = append(allErrs, field.Invalid(path.Child("macPrefix"), network.MACPrefix,
+				"must be a MAC address in xx:xx:xx:xx:xx:xx notation"))
+		}
+	}
+
+	_, subnet, err := net.ParseCIDR(network.SubNet)
+	if err != nil {
+		return append(allErrs, field.Invalid(path.Child("subnet"), network.SubNet, err.Error()))
+	}
+
+	staticStart, staticStop, errs := validateRange(subnet,
+		network.StaticAllocationStart, path.Child("staticAllocationStart"),
+		network.StaticAllocationStop, path.Child("staticAllocationStop"))
+	allErrs = append(allErrs, errs...)
+
+	dhcpStart, dhcpStop, errs := validateRange(subnet,
+		network.DHCPAllocationStart, path.Child("dhcpAllocationStart"),
+		network.DHCPAllocationStop, path.Child("dhcpAllocationStop"))
+	allErrs = append(allErrs, errs...)
+
+	if subnet.IP.To4() == nil && network.IPv6 != nil {
+		allErrs = append(allErrs, field.Invalid(path.Child("subnet"), network.SubNet,
+			"must be an IPv4 subnet when ipv6 is configured for the network"))
+	}
+	allErrs = append(allErrs, validateIPv6Network(network.IPv6, path.Child("ipv6"))...)
+
+	if len(allErrs) != 0 {
+		return allErrs
+	}
+
+	if bytes.Compare(staticStart, dhcpStop) <= 0 && bytes.Compare(dhcpStart, staticStop) <= 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("dhcpAllocationStart"), network.DHCPAllocationStart,
+			fmt.Sprintf("DHCP range [%s,%s] overlaps with static range [%s,%s]",
+				network.DHCPAllocationStart, network.DHCPAllocationStop,
+				network.StaticAllocationStart, network.StaticAllocationStop)))
+	}
+
+	bitStep := network.InstanceSubnetBitStep
+	if bitStep == 0 {
+		bitStep = VinoDefaultInstanceSubnetBitStep
+	}
+	// addresses are kept in 16 bytes, the length of IPv4 address is taken from the subnet
+	addressBits := net.IPv6len * 8
+	if subnet.IP.To4() != nil {
+		addressBits = net.IPv4len * 8
+	}
+	if bitStep < 0 || bitStep > addressBits {
+		return append(allErrs, field.Invalid(path.Child("instanceSubnetBitStep"), bitStep,
+			"must be a positive number, not greater than address length in bits"))
+	}
+
+	// DHCP range must fit one per node range of 2^bitStep addresses for each selected node
+	if nodeCount == 0 {
+		nodeCount = 1
+	}
+	rangeSize := new(big.Int).Sub(new(big.Int).SetBytes(dhcpStop), new(big.Int).SetBytes(dhcpStart))
+	rangeSize.Add(rangeSize, big.NewInt(1))
+	required := new(big.Int).Lsh(big.NewInt(int64(nodeCount)), uint(bitStep))
+	if rangeSize.Cmp(required) < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("instanceSubnetBitStep"), bitStep,
+			fmt.Sprintf("DHCP range has %s addresses, which doesn't fit %d node ranges of %d bits",
+				rangeSize, nodeCount, bitStep)))
+	}
+	return allErrs
+}
+
+// validateIPv6Network checks IPv6 part of a dual-stack network
+func validateIPv6Network(network *IPv6Network, path *field.Path) field.ErrorList {
+	if network == nil {
+		return nil
+	}
+	_, subnet, err := net.ParseCIDR(network.SubNet)
+	if err != nil {
+		return field.ErrorList{field.Invalid(path.Child("subnet"), network.SubNet, err.Error())}
+	}
+	if subnet.IP.To4() != nil {
+		return field.ErrorList{field.Invalid(path.Child("subnet"), network.SubNet, "must be an IPv6 subnet")}
+	}
+	_, _, errs := validateRange(subnet,
+		network.StaticAllocationStart, path.Child("staticAllocationStart"),
+		network.StaticAllocationStop, path.Child("staticAllocationStop"))
+	return errs
+}
+
+// validateRange checks that start and stop are valid IP addresses within the subnet, and start
+// is not greater than stop. Addresses are returned in 16 byte representation
+func validateRange(subnet *net.IPNet,
+	start string, startPath *field.Path,
+	stop string, stopPath *field.Path) (net.IP, net.IP, field.ErrorList) {
+	allErrs := field.ErrorList{}
+	startIP := validateIPInSubnet(subnet, start, startPath, &allErrs)
+	stopIP := validateIPInSubnet(subnet, stop, stopPath, &allErrs)
+	if len(allErrs) != 0 {
+		return nil, nil, allErrs
+	}
+	if bytes.Compare(startIP, stopIP) > 0 {
+		allErrs = append(allErrs, field.Invalid(stopPath, stop, "must not be less than range start "+start))
+	}
+	return startIP, stopIP, allErrs
+}
+
+func validateIPInSubnet(subnet *net.IPNet, ip string, path *field.Path, allErrs *field.ErrorList) net.IP {
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		*allErrs = append(*allErrs, field.Invalid(path, ip, "must be a valid IP address"))
+	case !subnet.Contains(parsed):
+		*allErrs = append(*allErrs, field.Invalid(path, ip, "must be inside subnet "+subnet.String()))
+	}
+	return parsed.To16()
+}
+
+func (r *Vino) validateNodeSet(node NodeSet,
+	networks map[string]Network,
+	nodeNames []string,
+	path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	bootInterfaceFound := false
+	for i, iface := range node.NetworkInterfaces {
+		if _, exists := networks[iface.NetworkName]; !exists {
+			allErrs = append(allErrs, field.NotFound(
+				path.Child("networkInterfaces").Index(i).Child("network"), iface.NetworkName))
+		}
+		if iface.Name == node.BootInterfaceName {
+			bootInterfaceFound = true
+		}
+	}
+	if !bootInterfaceFound {
+		allErrs = append(allErrs, field.NotFound(path.Child("bootInterfaceName"), node.BootInterfaceName))
+	}
+	if ref := node.VNCPasswordSecretRef; ref != nil && ref.Name == "" {
+		allErrs = append(allErrs, field.Required(path.Child("vncPasswordSecretRef", "name"), ""))
+	}
+
+	if node.Count == 0 {
+		return allErrs
+	}
+
+	// Names of BMHs and their secrets are generated from vino CR, k8s node and node set,
+	// check the longest one for every node vino CR is going to be scheduled to
+	if len(nodeNames) == 0 {
+		nodeNames = []string{""}
+	}
+	for _, nodeName := range nodeNames {
+		name := fmt.Sprintf("%s-%s-%s-%s-%d%s",
+			r.Namespace, r.Name, nodeName, node.Name, node.Count-1, bmhSecretLongestSuffix)
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			allErrs = append(allErrs, field.Invalid(path.Child("name"), node.Name,
+				fmt.Sprintf("generated name %s is invalid: %s", name, msg)))
+		}
+	}
+	return allErrs
+}
@@ -0,0 +1,380 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func testVino() *Vino {
+	return &Vino{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "vino-test",
+			Namespace: "default",
+		},
+		Spec: VinoSpec{
+			NodeSelector: &NodeSelector{
+				MatchLabels: map[string]string{"vino": "enabled"},
+			},
+			Networks: []Network{
+				{
+					Name:                  "management",
+					SubNet:                "192.168.2.0/20",
+					StaticAllocationStart: "192.168.2.10",
+					StaticAllocationStop:  "192.168.2.24",
+					DHCPAllocationStart:   "192.168.4.0",
+					DHCPAllocationStop:    "192.168.7.255",
+					InstanceSubnetBitStep: 4,
+					MACPrefix:             "52:54:00:06:00:00",
+				},
+			},
+			Nodes: []NodeSet{
+				{
+					Name:              "master",
+					Count:             1,
+					BootInterfaceName: "management",
+					NetworkInterfaces: []NetworkInterface{
+						{
+							Name:        "management",
+							NetworkName: "management",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		mutate      func(*Vino)
+		nodes       []string
+		expectedErr string
+	}{
+		{
+			name:   "valid vino",
+			mutate: func(*Vino) {},
+			nodes:  []string{"node01", "node02"},
+		},
+		{
+			name: "interface references unknown network",
+			mutate: func(v *Vino) {
+				v.Spec.Nodes[0].NetworkInterfaces[0].NetworkName = "pxe"
+			},
+			expectedErr: `spec.nodes[0].networkInterfaces[0].network: Not found: "pxe"`,
+		},
+		{
+			name: "boot interface doesn't exist",
+			mutate: func(v *Vino) {
+				v.Spec.Nodes[0].BootInterfaceName = "pxe"
+			},
+			expectedErr: `spec.nodes[0].bootInterfaceName: Not found: "pxe"`,
+		},
+		{
+			name: "bad subnet",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].SubNet = "192.168.2.0/40"
+			},
+			expectedErr: "spec.networks[0].subnet: Invalid value",
+		},
+		{
+			name: "static range outside of subnet",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].StaticAllocationStop = "192.168.20.24"
+			},
+			expectedErr: "spec.networks[0].staticAllocationStop: Invalid value: \"192.168.20.24\": " +
+				"must be inside subnet 192.168.0.0/20",
+		},
+		{
+			name: "DHCP range start is greater than stop",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].DHCPAllocationStart = "192.168.8.0"
+			},
+			expectedErr: "must not be less than range start 192.168.8.0",
+		},
+		{
+			name: "DHCP range is not an IP address",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].DHCPAllocationStart = "192.168.8"
+			},
+			expectedErr: "spec.networks[0].dhcpAllocationStart: Invalid value: \"192.168.8\": " +
+				"must be a valid IP address",
+		},
+		{
+			name: "static and DHCP ranges overlap",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].DHCPAllocationStart = "192.168.2.20"
+			},
+			expectedErr: "overlaps with static range",
+		},
+		{
+			name: "valid dual-stack network",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].IPv6 = &IPv6Network{
+					SubNet:                "fd00:2::/64",
+					StaticAllocationStart: "fd00:2::10",
+					StaticAllocationStop:  "fd00:2::ff",
+				}
+			},
+		},
+		{
+			name: "IPv6 subnet is not IPv6",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].IPv6 = &IPv6Network{
+					SubNet:                "192.168.16.0/20",
+					StaticAllocationStart: "192.168.16.10",
+					StaticAllocationStop:  "192.168.16.24",
+				}
+			},
+			expectedErr: "spec.networks[0].ipv6.subnet: Invalid value: \"192.168.16.0/20\": must be an IPv6 subnet",
+		},
+		{
+			name: "IPv6 static range outside of subnet",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].IPv6 = &IPv6Network{
+					SubNet:                "fd00:2::/64",
+					StaticAllocationStart: "fd00:2::10",
+					StaticAllocationStop:  "fd00:3::ff",
+				}
+			},
+			expectedErr: "spec.networks[0].ipv6.staticAllocationStop: Invalid value",
+		},
+		{
+			name: "bad MAC prefix",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].MACPrefix = "52:54:00:06:00"
+			},
+			expectedErr: "spec.networks[0].macPrefix: Invalid value",
+		},
+		{
+			name: "DHCP range doesn't fit node ranges",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].DHCPAllocationStop = "192.168.4.31"
+			},
+			nodes:       []string{"node01", "node02", "node03"},
+			expectedErr: "DHCP range has 32 addresses, which doesn't fit 3 node ranges of 4 bits",
+		},
+		{
+			name: "IPv4 bit step is longer than address",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].InstanceSubnetBitStep = 33
+			},
+			expectedErr: "spec.networks[0].instanceSubnetBitStep: Invalid value: 33: " +
+				"must be a positive number, not greater than address length in bits",
+		},
+		{
+			name: "IPv4 bit step of address length",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].InstanceSubnetBitStep = 32
+			},
+			expectedErr: "DHCP range has 1024 addresses, which doesn't fit 1 node ranges of 32 bits",
+		},
+		{
+			name: "valid IPv6 network",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].SubNet = "fd00::/56"
+				v.Spec.Networks[0].StaticAllocationStart = "fd00::10"
+				v.Spec.Networks[0].StaticAllocationStop = "fd00::24"
+				v.Spec.Networks[0].DHCPAllocationStart = "fd00::1:0"
+				v.Spec.Networks[0].DHCPAllocationStop = "fd00::ff:ffff"
+				v.Spec.Networks[0].InstanceSubnetBitStep = 16
+			},
+			nodes: []string{"node01", "node02"},
+		},
+		{
+			name: "IPv6 bit step is longer than address",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].SubNet = "fd00::/56"
+				v.Spec.Networks[0].StaticAllocationStart = "fd00::10"
+				v.Spec.Networks[0].StaticAllocationStop = "fd00::24"
+				v.Spec.Networks[0].DHCPAllocationStart = "fd00::1:0"
+				v.Spec.Networks[0].DHCPAllocationStop = "fd00::ff:ffff"
+				v.Spec.Networks[0].InstanceSubnetBitStep = 129
+			},
+			expectedErr: "spec.networks[0].instanceSubnetBitStep: Invalid value: 129: " +
+				"must be a positive number, not greater than address length in bits",
+		},
+		{
+			name: "IPv6 bit step longer than IPv4 address",
+			mutate: func(v *Vino) {
+				v.Spec.Networks[0].SubNet = "fd00::/56"
+				v.Spec.Networks[0].StaticAllocationStart = "fd00::10"
+				v.Spec.Networks[0].StaticAllocationStop = "fd00::24"
+				v.Spec.Networks[0].DHCPAllocationStart = "fd00::1:0"
+				v.Spec.Networks[0].DHCPAllocationStop = "fd00::ff:ffff"
+				v.Spec.Networks[0].InstanceSubnetBitStep = 64
+			},
+			expectedErr: "DHCP range has 16711680 addresses, which doesn't fit 1 node ranges of 64 bits",
+		},
+		{
+			name: "credentials secret and plain text credentials",
+			mutate: func(v *Vino) {
+				v.Spec.BMCCredentials = BMCCredentials{
+					Password:             "password",
+					CredentialsSecretRef: &NamespacedName{Name: "bmc-credentials"},
+				}
+			},
+			expectedErr: "spec.bmcCredentials: Forbidden",
+		},
+		{
+			name: "credentials secret without name",
+			mutate: func(v *Vino) {
+				v.Spec.BMCCredentials.CredentialsSecretRef = &NamespacedName{Namespace: "default"}
+			},
+			expectedErr: "spec.bmcCredentials.credentialsSecretRef.name: Required value",
+		},
+		{
+			name: "VNC password secret without name",
+			mutate: func(v *Vino) {
+				v.Spec.Nodes[0].VNCPasswordSecretRef = &NamespacedName{Namespace: "default"}
+			},
+			expectedErr: "spec.nodes[0].vncPasswordSecretRef.name: Required value",
+		},
+		{
+			name: "BMH name is too long",
+			mutate: func(v *Vino) {
+				v.Spec.Nodes[0].Name = strings.Repeat("worker", 40)
+			},
+			expectedErr: "spec.nodes[0].name: Invalid value",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			clientBuilder := fake.NewClientBuilder()
+			for _, name := range tt.nodes {
+				clientBuilder.WithObjects(&corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:   name,
+						Labels: map[string]string{"vino": "enabled"},
+					},
+				})
+			}
+			vino := testVino()
+			tt.mutate(vino)
+			err := vino.validate(context.Background(), clientBuilder.Build())
+			if tt.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidatorHandle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, AddToScheme(scheme))
+	decoder, err := admission.NewDecoder(scheme)
+	require.NoError(t, err)
+	validator := &vinoValidator{client: fake.NewClientBuilder().Build()}
+	require.NoError(t, validator.InjectDecoder(decoder))
+
+	raw := func(vino *Vino) runtime.RawExtension {
+		data, err := json.Marshal(vino)
+		require.NoError(t, err)
+		return runtime.RawExtension{Raw: data}
+	}
+	request := func(operation admissionv1.Operation, vino, old *Vino) admission.Request {
+		req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: operation,
+			Object:    raw(vino),
+		}}
+		if old != nil {
+			req.OldObject = raw(old)
+		}
+		return req
+	}
+
+	vino := testVino()
+	response := validator.Handle(context.Background(), request(admissionv1.Create, vino, nil))
+	assert.True(t, response.Allowed)
+
+	invalid := testVino()
+	invalid.Spec.Nodes[0].BootInterfaceName = "pxe"
+	response = validator.Handle(context.Background(), request(admissionv1.Update, invalid, vino))
+	assert.False(t, response.Allowed)
+	require.NotNil(t, response.Result)
+	assert.Contains(t, response.Result.Message, `spec.nodes[0].bootInterfaceName: Not found: "pxe"`)
+
+	// metadata of vino CR, that is already invalid, can be updated, and it can be deleted
+	updated := invalid.DeepCopy()
+	updated.Finalizers = []string{"vino.airshipit.org"}
+	response = validator.Handle(context.Background(), request(admissionv1.Update, updated, invalid))
+	assert.True(t, response.Allowed)
+
+	updated.Finalizers = nil
+	updated.Spec.Nodes[0].Count++
+	now := metav1.Now()
+	updated.DeletionTimestamp = &now
+	response = validator.Handle(context.Background(), request(admissionv1.Update, updated, invalid))
+	assert.True(t, response.Allowed)
+
+	response = validator.Handle(context.Background(), request(admissionv1.Delete, vino, nil))
+	assert.True(t, response.Allowed)
+}
+
+func TestDefault(t *testing.T) {
+	require.NoError(t, os.Setenv("RUNTIME_NAMESPACE", "vino-system"))
+	defer os.Unsetenv("RUNTIME_NAMESPACE")
+
+	vino := testVino()
+	vino.Spec.Networks[0].MACPrefix = ""
+	vino.Spec.Networks[0].InstanceSubnetBitStep = 0
+	vino.Spec.BMCCredentials.CredentialsSecretRef = &NamespacedName{Name: "bmc-credentials"}
+	vino.Spec.Nodes[0].VNCPasswordSecretRef = &NamespacedName{Name: "vnc-password"}
+	vino.Default()
+
+	assert.Equal(t, VinoDefaultMACPrefix, vino.Spec.Networks[0].MACPrefix)
+	assert.Equal(t, VinoDefaultInstanceSubnetBitStep, vino.Spec.Networks[0].InstanceSubnetBitStep)
+	assert.Equal(t, VinoDefaultRootDeviceName, vino.Spec.Nodes[0].RootDeviceName)
+	assert.Equal(t, NamespacedName{
+		Name:      VinoDefaultDaemonSetTemplateName,
+		Namespace: "vino-system",
+	}, vino.Spec.DaemonSetOptions.Template)
+	assert.Equal(t, VinoDefaultScheduleTimeout, vino.Spec.DaemonSetOptions.ScheduleTimeout.Duration)
+	assert.Equal(t, VinoDefaultReadyTimeout, vino.Spec.DaemonSetOptions.ReadyTimeout.Duration)
+	assert.Equal(t, VinoDefaultNodeRemovalGracePeriod, vino.Spec.NodeRemovalGracePeriod.Duration)
+	assert.Equal(t, &NamespacedName{Name: "bmc-credentials", Namespace: "default"},
+		vino.Spec.BMCCredentials.CredentialsSecretRef)
+	assert.Equal(t, &NamespacedName{Name: "vnc-password", Namespace: "default"},
+		vino.Spec.Nodes[0].VNCPasswordSecretRef)
+
+	// explicitly set values are never overridden
+	vino = testVino()
+	vino.Spec.Nodes[0].RootDeviceName = "/dev/sda"
+	vino.Spec.DaemonSetOptions.Template = NamespacedName{Name: "custom", Namespace: "default"}
+	vino.Default()
+
+	assert.Equal(t, "52:54:00:06:00:00", vino.Spec.Networks[0].MACPrefix)
+	assert.Equal(t, "/dev/sda", vino.Spec.Nodes[0].RootDeviceName)
+	assert.Equal(t, NamespacedName{Name: "custom", Namespace: "default"}, vino.Spec.DaemonSetOptions.Template)
+}
@@ -0,0 +1,139 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// VinoLabelNodeName is set on VinoBuilderConfig to NodeNameLabelValue of k8s node, it's built
+	// on, vino-builder selects its configs by this label
+	VinoLabelNodeName = VinoLabel + "/" + "node-name"
+	// ConditionTypeBuilt is reported by vino-builder when VMs from the config are built
+	ConditionTypeBuilt = "Built"
+	// BuildSucceededReason is the reason of Built condition, when all domains are realized
+	BuildSucceededReason = "VMsBuilt"
+	// BuildFailedReason is the reason of Built condition, when some domains are not realized
+	BuildFailedReason = "BuildFailed"
+)
+
+// NodeNameLabelValue returns value of VinoLabelNodeName label for the k8s node. Node names, that
+// don't fit a label value, are shortened and end with a hash of the full name
+func NodeNameLabelValue(nodeName string) string {
+	return ShortenName(nodeName, validation.LabelValueMaxLength)
+}
+
+// ShortenName returns the name, if it is not longer than maxLength, otherwise its beginning,
+// that ends with a hash of the full name, so that shortened names of different names differ
+func ShortenName(name string, maxLength int) string {
+	if len(name) <= maxLength {
+		return name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	hash := fmt.Sprintf("%08x", h.Sum32())
+	// dots and dashes are not allowed next to each other in object names
+	prefix := strings.TrimRight(name[:maxLength-len(hash)-1], ".-_")
+	return prefix + "-" + hash
+}
+
+// DomainState is the state of libvirt domain reported by vino-builder
+type DomainState string
+
+const (
+	// DomainStateDefined domain is defined in libvirt, but is not running
+	DomainStateDefined DomainState = "Defined"
+	// DomainStateRunning domain is defined in libvirt and is running
+	DomainStateRunning DomainState = "Running"
+	// DomainStateNotDefined domain is not defined in libvirt
+	DomainStateNotDefined DomainState = "NotDefined"
+)
+
+// VinoBuilderConfigSpec is a request for VMs to vino-builder running on a k8s node
+type VinoBuilderConfigSpec struct {
+	// NodeName is the name of k8s node, vino-builder of which builds VMs from the config
+	NodeName string `json:"nodeName"`
+	// Builder contains networks and domains vino-builder creates on the node
+	Builder Builder `json:"builder,omitempty"`
+	// Resources is the amount of CPU and memory requested by domains of the config, vino controller
+	// sums resources of all configs on the node to check that VMs of several vino CRs fit on it
+	Resources corev1.ResourceList `json:"resources,omitempty"`
+}
+
+// VinoBuilderConfigStatus is reported by vino-builder
+type VinoBuilderConfigStatus struct {
+	// ObservedGeneration is the generation of the config, that vino-builder has processed last
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions of the config, vino-builder reports Built condition
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Domains are libvirt domains of the config, as they are found on the node after the build
+	Domains []DomainStatus `json:"domains,omitempty"`
+}
+
+// DomainStatus is the state of libvirt domain of the config on the node
+type DomainStatus struct {
+	// Name of libvirt domain, it is the same as the name of BMH created for the VM
+	Name string `json:"name"`
+	// State of the domain in libvirt
+	State DomainState `json:"state,omitempty"`
+	// UUID of the domain assigned by libvirt
+	UUID string `json:"uuid,omitempty"`
+	// MACAddresses of network interfaces attached to the domain
+	MACAddresses []string `json:"macAddresses,omitempty"`
+	// Volumes are storage volumes created for the domain
+	Volumes []string `json:"volumes,omitempty"`
+	// Error is set if the domain could not be realized as requested
+	Error string `json:"error,omitempty"`
+}
+
+// Realized returns true if the domain exists in libvirt as requested, so that BMH can be created for it
+func (s DomainStatus) Realized() bool {
+	return s.Error == "" && (s.State == DomainStateDefined || s.State == DomainStateRunning)
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.spec.nodeName`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// VinoBuilderConfig is the Schema for the vinobuilderconfigs API. It is created by vino controller
+// for every pair of vino CR and k8s node, and is owned by the vino CR.
+type VinoBuilderConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VinoBuilderConfigSpec   `json:"spec,omitempty"`
+	Status VinoBuilderConfigStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// VinoBuilderConfigList contains a list of VinoBuilderConfig
+type VinoBuilderConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VinoBuilderConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VinoBuilderConfig{}, &VinoBuilderConfigList{})
+}
@@ -0,0 +1,83 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CPUPinningPolicy defines how virtual CPUs of a VM are placed on host cores
+// +kubebuilder:validation:Enum=Dedicated;None
+type CPUPinningPolicy string
+
+const (
+	// CPUPinningDedicated pins every virtual CPU of a VM to its own host core
+	CPUPinningDedicated CPUPinningPolicy = "Dedicated"
+	// CPUPinningNone lets virtual CPUs of a VM float on host cores
+	CPUPinningNone CPUPinningPolicy = "None"
+)
+
+// VinoFlavorSpec defines sizing of VMs. Field names match VM flavors of vino-builder, so that the
+// spec is passed to vino-builder as is.
+type VinoFlavorSpec struct {
+	// VCPUs is the number of virtual CPUs of a VM
+	// +kubebuilder:validation:Minimum=1
+	VCPUs int64 `json:"vcpus"`
+	// Memory of a VM in GiB
+	// +kubebuilder:validation:Minimum=1
+	Memory int64 `json:"memory"`
+	// RootSize is the size of VM root disk in GB
+	// +kubebuilder:validation:Minimum=1
+	RootSize int64 `json:"rootSize"`
+	// HugePages backs memory of a VM by 1GiB huge pages
+	HugePages bool `json:"hugepages,omitempty"`
+	// CPUPinning defines whether virtual CPUs of a VM are pinned to dedicated host cores,
+	// default is Dedicated
+	// +kubebuilder:default=Dedicated
+	CPUPinning CPUPinningPolicy `json:"cpuPinning,omitempty"`
+	// NUMANode restricts dedicated cores of a VM to the host NUMA node. Cores are taken from
+	// any NUMA node, that has enough free cores, if it is not set
+	// +kubebuilder:validation:Minimum=0
+	NUMANode *int `json:"numaNode,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="VCPUs",type=integer,JSONPath=`.spec.vcpus`
+// +kubebuilder:printcolumn:name="Memory",type=integer,JSONPath=`.spec.memory`
+// +kubebuilder:printcolumn:name="Root Size",type=integer,JSONPath=`.spec.rootSize`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// VinoFlavor is the Schema for the vinoflavors API. Node sets of vino CRs reference flavors by
+// name, so that several node sets can share a flavor.
+type VinoFlavor struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec VinoFlavorSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// VinoFlavorList contains a list of VinoFlavor
+type VinoFlavorList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VinoFlavor `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VinoFlavor{}, &VinoFlavorList{})
+}
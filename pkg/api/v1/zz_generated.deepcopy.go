@@ -21,8 +21,9 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
@@ -59,6 +60,11 @@ func (in *AllocatedRange) DeepCopy() *AllocatedRange {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BMCCredentials) DeepCopyInto(out *BMCCredentials) {
 	*out = *in
+	if in.CredentialsSecretRef != nil {
+		in, out := &in.CredentialsSecretRef, &out.CredentialsSecretRef
+		*out = new(NamespacedName)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BMCCredentials.
@@ -104,6 +110,11 @@ func (in *Builder) DeepCopy() *Builder {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BuilderDomain) DeepCopyInto(out *BuilderDomain) {
 	*out = *in
+	if in.Flavor != nil {
+		in, out := &in.Flavor, &out.Flavor
+		*out = new(VinoFlavorSpec)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Interfaces != nil {
 		in, out := &in.Interfaces, &out.Interfaces
 		*out = make([]BuilderNetworkInterface, len(*in))
@@ -171,10 +182,39 @@ func (in *CPUConfiguration) DeepCopy() *CPUConfiguration {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CredentialsStatus) DeepCopyInto(out *CredentialsStatus) {
+	*out = *in
+	if in.RotationPhaseTransitionTime != nil {
+		in, out := &in.RotationPhaseTransitionTime, &out.RotationPhaseTransitionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CredentialsStatus.
+func (in *CredentialsStatus) DeepCopy() *CredentialsStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CredentialsStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DaemonSetOptions) DeepCopyInto(out *DaemonSetOptions) {
 	*out = *in
 	out.Template = in.Template
+	if in.ScheduleTimeout != nil {
+		in, out := &in.ScheduleTimeout, &out.ScheduleTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.ReadyTimeout != nil {
+		in, out := &in.ReadyTimeout, &out.ReadyTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DaemonSetOptions.
@@ -222,6 +262,56 @@ func (in *DiskOptions) DeepCopy() *DiskOptions {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DomainStatus) DeepCopyInto(out *DomainStatus) {
+	*out = *in
+	if in.MACAddresses != nil {
+		in, out := &in.MACAddresses, &out.MACAddresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Volumes != nil {
+		in, out := &in.Volumes, &out.Volumes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DomainStatus.
+func (in *DomainStatus) DeepCopy() *DomainStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DomainStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostInventory) DeepCopyInto(out *HostInventory) {
+	*out = *in
+	if in.NUMANodes != nil {
+		in, out := &in.NUMANodes, &out.NUMANodes
+		*out = make([]NUMANodeInventory, len(*in))
+		copy(*out, *in)
+	}
+	if in.StoragePools != nil {
+		in, out := &in.StoragePools, &out.StoragePools
+		*out = make([]StoragePoolInventory, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostInventory.
+func (in *HostInventory) DeepCopy() *HostInventory {
+	if in == nil {
+		return nil
+	}
+	out := new(HostInventory)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IPPool) DeepCopyInto(out *IPPool) {
 	*out = *in
@@ -326,6 +416,36 @@ func (in *IPPoolStatus) DeepCopy() *IPPoolStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPv6Network) DeepCopyInto(out *IPv6Network) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPv6Network.
+func (in *IPv6Network) DeepCopy() *IPv6Network {
+	if in == nil {
+		return nil
+	}
+	out := new(IPv6Network)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NUMANodeInventory) DeepCopyInto(out *NUMANodeInventory) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NUMANodeInventory.
+func (in *NUMANodeInventory) DeepCopy() *NUMANodeInventory {
+	if in == nil {
+		return nil
+	}
+	out := new(NUMANodeInventory)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NamespacedName) DeepCopyInto(out *NamespacedName) {
 	*out = *in
@@ -354,6 +474,11 @@ func (in *Network) DeepCopyInto(out *Network) {
 		*out = make([]VMRoutes, len(*in))
 		copy(*out, *in)
 	}
+	if in.IPv6 != nil {
+		in, out := &in.IPv6, &out.IPv6
+		*out = new(IPv6Network)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Network.
@@ -388,6 +513,37 @@ func (in *NetworkInterface) DeepCopy() *NetworkInterface {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeNetworkStatus) DeepCopyInto(out *NodeNetworkStatus) {
+	*out = *in
+	out.Range = in.Range
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeNetworkStatus.
+func (in *NodeNetworkStatus) DeepCopy() *NodeNetworkStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeNetworkStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePlacement) DeepCopyInto(out *NodePlacement) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePlacement.
+func (in *NodePlacement) DeepCopy() *NodePlacement {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePlacement)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeSelector) DeepCopyInto(out *NodeSelector) {
 	*out = *in
@@ -413,6 +569,18 @@ func (in *NodeSelector) DeepCopy() *NodeSelector {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeSet) DeepCopyInto(out *NodeSet) {
 	*out = *in
+	if in.Placement != nil {
+		in, out := &in.Placement, &out.Placement
+		*out = new(NodeSetPlacement)
+		**out = **in
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	if in.BMHLabels != nil {
 		in, out := &in.BMHLabels, &out.BMHLabels
 		*out = make(map[string]string, len(*in))
@@ -436,6 +604,11 @@ func (in *NodeSet) DeepCopyInto(out *NodeSet) {
 		}
 	}
 	out.NetworkDataTemplate = in.NetworkDataTemplate
+	if in.VNCPasswordSecretRef != nil {
+		in, out := &in.VNCPasswordSecretRef, &out.VNCPasswordSecretRef
+		*out = new(NamespacedName)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeSet.
@@ -448,6 +621,72 @@ func (in *NodeSet) DeepCopy() *NodeSet {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeSetPlacement) DeepCopyInto(out *NodeSetPlacement) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeSetPlacement.
+func (in *NodeSetPlacement) DeepCopy() *NodeSetPlacement {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeSetPlacement)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeSetPlacementStatus) DeepCopyInto(out *NodeSetPlacementStatus) {
+	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodePlacement, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeSetPlacementStatus.
+func (in *NodeSetPlacementStatus) DeepCopy() *NodeSetPlacementStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeSetPlacementStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeStatus) DeepCopyInto(out *NodeStatus) {
+	*out = *in
+	if in.Networks != nil {
+		in, out := &in.Networks, &out.Networks
+		*out = make([]NodeNetworkStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.VMs != nil {
+		in, out := &in.VMs, &out.VMs
+		*out = make([]VMStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RemovedAt != nil {
+		in, out := &in.RemovedAt, &out.RemovedAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeStatus.
+func (in *NodeStatus) DeepCopy() *NodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Range) DeepCopyInto(out *Range) {
 	*out = *in
@@ -463,6 +702,36 @@ func (in *Range) DeepCopy() *Range {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StoragePoolInventory) DeepCopyInto(out *StoragePoolInventory) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StoragePoolInventory.
+func (in *StoragePoolInventory) DeepCopy() *StoragePoolInventory {
+	if in == nil {
+		return nil
+	}
+	out := new(StoragePoolInventory)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VMInterfaceStatus) DeepCopyInto(out *VMInterfaceStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VMInterfaceStatus.
+func (in *VMInterfaceStatus) DeepCopy() *VMInterfaceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VMInterfaceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VMRoutes) DeepCopyInto(out *VMRoutes) {
 	*out = *in
@@ -478,6 +747,26 @@ func (in *VMRoutes) DeepCopy() *VMRoutes {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VMStatus) DeepCopyInto(out *VMStatus) {
+	*out = *in
+	if in.Interfaces != nil {
+		in, out := &in.Interfaces, &out.Interfaces
+		*out = make([]VMInterfaceStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VMStatus.
+func (in *VMStatus) DeepCopy() *VMStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VMStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Vino) DeepCopyInto(out *Vino) {
 	*out = *in
@@ -505,6 +794,195 @@ func (in *Vino) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VinoBuilderConfig) DeepCopyInto(out *VinoBuilderConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VinoBuilderConfig.
+func (in *VinoBuilderConfig) DeepCopy() *VinoBuilderConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(VinoBuilderConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VinoBuilderConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VinoBuilderConfigList) DeepCopyInto(out *VinoBuilderConfigList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]VinoBuilderConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VinoBuilderConfigList.
+func (in *VinoBuilderConfigList) DeepCopy() *VinoBuilderConfigList {
+	if in == nil {
+		return nil
+	}
+	out := new(VinoBuilderConfigList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VinoBuilderConfigList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VinoBuilderConfigSpec) DeepCopyInto(out *VinoBuilderConfigSpec) {
+	*out = *in
+	in.Builder.DeepCopyInto(&out.Builder)
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VinoBuilderConfigSpec.
+func (in *VinoBuilderConfigSpec) DeepCopy() *VinoBuilderConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VinoBuilderConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VinoBuilderConfigStatus) DeepCopyInto(out *VinoBuilderConfigStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Domains != nil {
+		in, out := &in.Domains, &out.Domains
+		*out = make([]DomainStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VinoBuilderConfigStatus.
+func (in *VinoBuilderConfigStatus) DeepCopy() *VinoBuilderConfigStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VinoBuilderConfigStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VinoFlavor) DeepCopyInto(out *VinoFlavor) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VinoFlavor.
+func (in *VinoFlavor) DeepCopy() *VinoFlavor {
+	if in == nil {
+		return nil
+	}
+	out := new(VinoFlavor)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VinoFlavor) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VinoFlavorList) DeepCopyInto(out *VinoFlavorList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]VinoFlavor, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VinoFlavorList.
+func (in *VinoFlavorList) DeepCopy() *VinoFlavorList {
+	if in == nil {
+		return nil
+	}
+	out := new(VinoFlavorList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VinoFlavorList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VinoFlavorSpec) DeepCopyInto(out *VinoFlavorSpec) {
+	*out = *in
+	if in.NUMANode != nil {
+		in, out := &in.NUMANode, &out.NUMANode
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VinoFlavorSpec.
+func (in *VinoFlavorSpec) DeepCopy() *VinoFlavorSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VinoFlavorSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VinoList) DeepCopyInto(out *VinoList) {
 	*out = *in
@@ -560,13 +1038,18 @@ func (in *VinoSpec) DeepCopyInto(out *VinoSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
-	out.DaemonSetOptions = in.DaemonSetOptions
-	out.BMCCredentials = in.BMCCredentials
+	in.DaemonSetOptions.DeepCopyInto(&out.DaemonSetOptions)
+	in.BMCCredentials.DeepCopyInto(&out.BMCCredentials)
 	if in.NodeLabelKeysToCopy != nil {
 		in, out := &in.NodeLabelKeysToCopy, &out.NodeLabelKeysToCopy
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.NodeRemovalGracePeriod != nil {
+		in, out := &in.NodeRemovalGracePeriod, &out.NodeRemovalGracePeriod
+		*out = new(metav1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VinoSpec.
@@ -590,6 +1073,25 @@ func (in *VinoStatus) DeepCopyInto(out *VinoStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.PhaseTransitionTime != nil {
+		in, out := &in.PhaseTransitionTime, &out.PhaseTransitionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodeStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.Credentials.DeepCopyInto(&out.Credentials)
+	if in.Placements != nil {
+		in, out := &in.Placements, &out.Placements
+		*out = make([]NodeSetPlacementStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VinoStatus.
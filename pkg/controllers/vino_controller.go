@@ -18,16 +18,22 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierror "k8s.io/apimachinery/pkg/api/errors"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	kerror "k8s.io/apimachinery/pkg/util/errors"
@@ -35,7 +41,11 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 	"sigs.k8s.io/yaml"
 
 	vinov1 "vino/pkg/api/v1"
@@ -45,25 +55,56 @@ import (
 
 const (
 	TemplateDefaultKey           = "template"
-	DaemonSetTemplateDefaultName = "vino-daemonset-template"
+	DaemonSetTemplateDefaultName = vinov1.VinoDefaultDaemonSetTemplateName
 
 	ContainerNameLibvirt = "libvirt"
+	ContainerNameSushy   = "sushy"
+	ContainerNameBuilder = "vino-builder"
 	ConfigMapKeyVinoSpec = "vino-spec"
+
+	// DaemonSetRequeueInterval is how often DaemonSet is checked while vino CR waits for it
+	DaemonSetRequeueInterval = 10 * time.Second
+
+	// EnvVarVinoOwner is <namespace>/<name> of vino CR, vino-builder selects its config by it
+	EnvVarVinoOwner = "VINO_OWNER"
+	// EnvVarSushyConfig is the path of sushy emulator configuration file
+	EnvVarSushyConfig = "SUSHY_EMULATOR_CONFIG"
+	// sushyConfigVolumeName is the name of the volume with htpasswd secret in DaemonSet pods
+	sushyConfigVolumeName = "vino-sushy-config"
+	// vncPasswordsVolumeName is the name of the volume with VNC passwords secret in DaemonSet pods
+	vncPasswordsVolumeName = "vino-vnc-passwords"
 )
 
+// RedactedValue replaces values of sensitive environment variables in logs
+const RedactedValue = "<redacted>"
+
+// DefaultSensitiveEnvVars are names of DaemonSet environment variables, whose values are never logged
+var DefaultSensitiveEnvVars = []string{
+	vinov1.EnvVarBasicAuthUsername,
+	vinov1.EnvVarBasicAuthPassword,
+}
+
 // VinoReconciler reconciles a Vino object
 type VinoReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 	Ipam   *ipam.Ipam
+	// SensitiveEnvVars are names of DaemonSet environment variables, whose values are never
+	// logged, in addition to DefaultSensitiveEnvVars and variables whose values come from secrets
+	SensitiveEnvVars []string
 }
 
 // +kubebuilder:rbac:groups=airship.airshipit.org,resources=vinoes,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=airship.airshipit.org,resources=vinoes/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=airship.airshipit.org,resources=ippools,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=airship.airshipit.org,resources=vinobuilderconfigs,verbs=get;list;watch
+// +kubebuilder:rbac:groups=airship.airshipit.org,resources=vinobuilderconfigs,verbs=create;update;patch;delete
+// +kubebuilder:rbac:groups=airship.airshipit.org,resources=vinoflavors,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=pods,verbs=list;watch
 // +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;update;patch
-// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=metal3.io,resources=baremetalhosts,verbs=get;list;watch;create;update;patch;delete
 
 func (r *VinoReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := logr.FromContext(ctx)
@@ -88,7 +129,7 @@ func (r *VinoReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	}
 
 	if !vino.ObjectMeta.DeletionTimestamp.IsZero() {
-		return ctrl.Result{}, r.finalize(ctx, vino)
+		return r.finalize(ctx, vino)
 	}
 
 	readyCondition := apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypeReady)
@@ -100,10 +141,15 @@ func (r *VinoReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		}
 	}
 
-	err = r.reconcileDaemonSet(ctx, vino)
+	result, err := r.reconcileDaemonSet(ctx, vino)
 	if err != nil {
 		return ctrl.Result{Requeue: true}, err
 	}
+	if !result.IsZero() {
+		logger.Info("VINO CR reconciliation is in progress, requeueing",
+			"phase", vino.Status.Phase, "requeue after", result.RequeueAfter)
+		return result, nil
+	}
 
 	vinov1.VinoReady(vino)
 	if err := r.patchStatus(ctx, vino); err != nil {
@@ -111,6 +157,16 @@ func (r *VinoReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		return ctrl.Result{Requeue: true}, err
 	}
 	logger.Info("successfully reconciled VINO CR")
+	if vino.Status.Credentials.RotationPhase != "" {
+		logger.Info("BMC credentials rotation is in progress, requeueing",
+			"rotation phase", vino.Status.Credentials.RotationPhase)
+		return ctrl.Result{RequeueAfter: managers.CredentialsPropagationDelay}, nil
+	}
+	// VMs of removed nodes are deleted when their grace period passes
+	if next := managers.NextNodeRemoval(vino, time.Now()); next > 0 {
+		logger.Info("VMs of removed nodes are kept until grace period passes, requeueing", "requeue after", next)
+		return ctrl.Result{RequeueAfter: next}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
@@ -127,8 +183,8 @@ func (r *VinoReconciler) patchStatus(ctx context.Context, vino *vinov1.Vino) err
 	return r.Client.Status().Patch(ctx, vino, client.MergeFrom(latest))
 }
 
-func (r *VinoReconciler) reconcileDaemonSet(ctx context.Context, vino *vinov1.Vino) error {
-	err := r.ensureDaemonSet(ctx, vino)
+func (r *VinoReconciler) reconcileDaemonSet(ctx context.Context, vino *vinov1.Vino) (ctrl.Result, error) {
+	result, err := r.ensureDaemonSet(ctx, vino)
 	if err != nil {
 		err = fmt.Errorf("could not reconcile DaemonSet: %w", err)
 		apimeta.SetStatusCondition(&vino.Status.Conditions, metav1.Condition{
@@ -149,8 +205,18 @@ func (r *VinoReconciler) reconcileDaemonSet(ctx context.Context, vino *vinov1.Vi
 			err = kerror.NewAggregate([]error{err, patchStatusErr})
 			err = fmt.Errorf("unable to patch status after DaemonSet reconciliation failed: %w", err)
 		}
-		return err
+		return ctrl.Result{}, err
 	}
+
+	if !result.IsZero() {
+		// DaemonSet or vino-builders are not ready yet, waitInPhase has reflected that on vino CR
+		if err := r.patchStatus(ctx, vino); err != nil {
+			err = fmt.Errorf("unable to patch status while waiting for DaemonSet: %w", err)
+			return ctrl.Result{}, err
+		}
+		return result, nil
+	}
+
 	apimeta.SetStatusCondition(&vino.Status.Conditions, metav1.Condition{
 		Status:             metav1.ConditionTrue,
 		Reason:             vinov1.ReconciliationSucceededReason,
@@ -160,16 +226,27 @@ func (r *VinoReconciler) reconcileDaemonSet(ctx context.Context, vino *vinov1.Vi
 	})
 	if err := r.patchStatus(ctx, vino); err != nil {
 		err = fmt.Errorf("unable to patch status after DaemonSet reconciliation succeeded: %w", err)
-		return err
+		return ctrl.Result{}, err
 	}
 
-	return nil
+	return ctrl.Result{}, nil
 }
 
-func (r *VinoReconciler) ensureDaemonSet(ctx context.Context, vino *vinov1.Vino) error {
+// ensureDaemonSet moves vino CR through reconciliation phases. Instead of waiting for DaemonSet
+// to reach desired state, it records current phase in vino status and returns non zero result,
+// so that reconciliation is requeued. Conditions of vino CR tell if it spends too much time in a phase.
+func (r *VinoReconciler) ensureDaemonSet(ctx context.Context, vino *vinov1.Vino) (ctrl.Result, error) {
 	ds, err := r.daemonSet(ctx, vino)
 	if err != nil {
-		return err
+		return ctrl.Result{}, err
+	}
+
+	if err = r.ensureBMCCredentials(ctx, vino); err != nil {
+		return ctrl.Result{}, err
+	}
+	// secret is mounted to vino-builder pods, so it must exist before they are created
+	if err = managers.EnsureVNCPasswordsSecret(ctx, r.Client, getRuntimeNamespace(), vino); err != nil {
+		return ctrl.Result{}, err
 	}
 
 	r.decorateDaemonSet(ctx, ds, vino)
@@ -183,20 +260,15 @@ func (r *VinoReconciler) ensureDaemonSet(ctx context.Context, vino *vinov1.Vino)
 		err = r.Patch(ctx, ds, client.MergeFrom(existDS))
 	}
 	if err != nil {
-		return err
+		return ctrl.Result{}, err
 	}
 
-	// TODO (kkalynovskyi) this function needs to add owner reference on the daemonset set and watch
-	// controller should watch for changes in daemonset to reconcile if it breaks, and change status
-	// of the vino object
-	// controlleruti.SetControllerReference(vino, ds, r.scheme)
-	scheduledTimeoutCtx, cancel := context.WithTimeout(ctx, time.Second*180)
-	defer cancel()
-
 	logger := logr.FromContext(ctx)
-	logger.Info("Waiting for daemonset to become scheduled")
-	if err = r.waitDaemonSet(scheduledTimeoutCtx, dsScheduled, ds); err != nil {
-		return err
+	if !dsScheduled(ds) {
+		logger.Info("DaemonSet is not scheduled yet", "status", ds.Status)
+		return waitInPhase(vino, vinov1.VinoPhaseDaemonSetApplied,
+			getTimeout(vino.Spec.DaemonSetOptions.ScheduleTimeout, vinov1.VinoDefaultScheduleTimeout),
+			"DaemonSet to become scheduled"), nil
 	}
 
 	bmhManager := &managers.BMHManager{
@@ -208,20 +280,189 @@ func (r *VinoReconciler) ensureDaemonSet(ctx context.Context, vino *vinov1.Vino)
 	}
 
 	logger.Info("Requesting Virtual Machines from vino-builders")
-	if err := bmhManager.ScheduleVMs(ctx); err != nil {
-		return err
+	err = bmhManager.ScheduleVMs(ctx)
+	setHostCapacityCondition(vino, err)
+	if err != nil {
+		vinov1.SetVinoPhase(vino, vinov1.VinoPhaseDaemonSetScheduled)
+		return ctrl.Result{}, err
 	}
 
-	waitTimeoutCtx, cancel := context.WithTimeout(ctx, time.Second*180)
-	defer cancel()
+	setScaledDownCondition(vino, bmhManager.ScaleDownBlocked())
+	setPlacementCondition(vino, bmhManager.UnsatisfiablePlacements())
+	vino.Status.Placements = bmhManager.Placements()
 
-	logger.Info("Waiting for daemonset to become ready")
-	if err := r.waitDaemonSet(waitTimeoutCtx, dsReady, ds); err != nil {
-		return err
+	if vino.Status.Nodes, err = bmhManager.NodeStatuses(ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if !dsReady(ds) {
+		logger.Info("DaemonSet is not ready yet", "status", ds.Status)
+		return waitInPhase(vino, vinov1.VinoPhaseVMsRequested,
+			getTimeout(vino.Spec.DaemonSetOptions.ReadyTimeout, vinov1.VinoDefaultReadyTimeout),
+			"vino-builders to become ready"), nil
 	}
 
 	logger.Info("Creating BaremetalHosts")
-	return bmhManager.CreateBMHs(ctx)
+	if err := bmhManager.CreateBMHs(ctx); err != nil {
+		vinov1.SetVinoPhase(vino, vinov1.VinoPhaseBuildersReady)
+		return ctrl.Result{}, err
+	}
+
+	// BMHs are created only for VMs, that vino-builders have reported as realized
+	realization := bmhManager.VMRealization()
+	setVMsRealizedCondition(vino, realization)
+	if len(realization.Failed) > 0 {
+		vinov1.SetVinoPhase(vino, vinov1.VinoPhaseBuildersReady)
+		return ctrl.Result{}, fmt.Errorf("vino-builders failed to realize %d VMs", len(realization.Failed))
+	}
+	if len(realization.Pending) > 0 {
+		logger.Info("Waiting for vino-builders to realize VMs", "pending BMHs", realization.Pending)
+		return waitInPhase(vino, vinov1.VinoPhaseBuildersReady,
+			getTimeout(vino.Spec.DaemonSetOptions.ReadyTimeout, vinov1.VinoDefaultReadyTimeout),
+			"vino-builders to realize VMs"), nil
+	}
+	vinov1.SetVinoPhase(vino, vinov1.VinoPhaseBMHsCreated)
+	return ctrl.Result{}, nil
+}
+
+// setVMsRealizedCondition reflects VMs, that vino-builders have failed to realize or haven't
+// realized yet, in VMsRealized condition of vino CR
+func setVMsRealizedCondition(vino *vinov1.Vino, realization managers.VMRealization) {
+	condition := metav1.Condition{
+		Status:             metav1.ConditionTrue,
+		Reason:             vinov1.ReconciliationSucceededReason,
+		Message:            "All VMs are realized",
+		Type:               vinov1.ConditionTypeVMsRealized,
+		ObservedGeneration: vino.GetGeneration(),
+	}
+	switch {
+	case len(realization.Failed) > 0:
+		bmhNames := make([]string, 0, len(realization.Failed))
+		for bmhName := range realization.Failed {
+			bmhNames = append(bmhNames, bmhName)
+		}
+		sort.Strings(bmhNames)
+		failures := make([]string, 0, len(bmhNames))
+		for _, bmhName := range bmhNames {
+			failures = append(failures, fmt.Sprintf("%s: %s", bmhName, realization.Failed[bmhName]))
+		}
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = vinov1.VMRealizationFailedReason
+		condition.Message = "Failed to realize VMs: " + strings.Join(failures, "; ")
+	case len(realization.Pending) > 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = vinov1.ProgressingReason
+		condition.Message = fmt.Sprintf("Waiting for vino-builders to realize %d VMs", len(realization.Pending))
+	}
+	apimeta.SetStatusCondition(&vino.Status.Conditions, condition)
+}
+
+// setScaledDownCondition reflects VMs, that are kept above count of their node sets, in ScaledDown
+// condition of vino CR
+func setScaledDownCondition(vino *vinov1.Vino, blocked map[string]string) {
+	condition := metav1.Condition{
+		Status:             metav1.ConditionTrue,
+		Reason:             vinov1.ReconciliationSucceededReason,
+		Message:            "All node sets are scaled to their count",
+		Type:               vinov1.ConditionTypeScaledDown,
+		ObservedGeneration: vino.GetGeneration(),
+	}
+	if len(blocked) > 0 {
+		bmhNames := make([]string, 0, len(blocked))
+		for bmhName := range blocked {
+			bmhNames = append(bmhNames, bmhName)
+		}
+		sort.Strings(bmhNames)
+		reasons := make([]string, 0, len(bmhNames))
+		for _, bmhName := range bmhNames {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", bmhName, blocked[bmhName]))
+		}
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = vinov1.ScaleDownBlockedReason
+		condition.Message = "VMs above node set count are kept: " + strings.Join(reasons, "; ")
+	}
+	apimeta.SetStatusCondition(&vino.Status.Conditions, condition)
+}
+
+// setPlacementCondition reflects node sets, whose VMs can't be placed on k8s nodes as requested,
+// in PlacementSatisfied condition of vino CR
+func setPlacementCondition(vino *vinov1.Vino, unsatisfiable map[string]string) {
+	condition := metav1.Condition{
+		Status:             metav1.ConditionTrue,
+		Reason:             vinov1.ReconciliationSucceededReason,
+		Message:            "VMs of all node sets are placed",
+		Type:               vinov1.ConditionTypePlacementSatisfied,
+		ObservedGeneration: vino.GetGeneration(),
+	}
+	if len(unsatisfiable) > 0 {
+		nodeSets := make([]string, 0, len(unsatisfiable))
+		for nodeSet := range unsatisfiable {
+			nodeSets = append(nodeSets, nodeSet)
+		}
+		sort.Strings(nodeSets)
+		reasons := make([]string, 0, len(nodeSets))
+		for _, nodeSet := range nodeSets {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", nodeSet, unsatisfiable[nodeSet]))
+		}
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = vinov1.PlacementUnsatisfiableReason
+		condition.Message = "Placement of node sets is unsatisfiable: " + strings.Join(reasons, "; ")
+	}
+	apimeta.SetStatusCondition(&vino.Status.Conditions, condition)
+}
+
+// setHostCapacityCondition reflects k8s nodes, that don't have capacity for VMs, in
+// HostCapacitySufficient condition of vino CR. Condition is left as is on other errors, since
+// capacity is not known to be checked then
+func setHostCapacityCondition(vino *vinov1.Vino, err error) {
+	condition := metav1.Condition{
+		Status:             metav1.ConditionTrue,
+		Reason:             vinov1.ReconciliationSucceededReason,
+		Message:            "All k8s nodes have capacity for VMs",
+		Type:               vinov1.ConditionTypeHostCapacitySufficient,
+		ObservedGeneration: vino.GetGeneration(),
+	}
+	if err != nil {
+		capacityErr := &managers.CapacityError{}
+		if !errors.As(err, &capacityErr) {
+			return
+		}
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = vinov1.InsufficientHostCapacityReason
+		condition.Message = capacityErr.Error()
+	}
+	apimeta.SetStatusCondition(&vino.Status.Conditions, condition)
+}
+
+// waitInPhase records that vino CR is waiting in the given phase in its Ready and DaemonSetReady
+// conditions and requeues reconciliation. If vino CR stays in the phase for longer than timeout,
+// the timeout is recorded in the conditions, and reconciliation is still requeued at the same
+// interval, so that vino CR proceeds as soon as the wait is over.
+func waitInPhase(vino *vinov1.Vino, phase vinov1.VinoPhase, timeout time.Duration, waitingFor string) ctrl.Result {
+	vinov1.SetVinoPhase(vino, phase)
+	reason := vinov1.ProgressingReason
+	message := fmt.Sprintf("Waiting for %s, current phase is %s", waitingFor, phase)
+	if time.Since(vino.Status.PhaseTransitionTime.Time) > timeout {
+		reason = vinov1.PhaseTimedOutReason
+		message = fmt.Sprintf("Timed out after %s waiting for %s, current phase is %s", timeout, waitingFor, phase)
+	}
+	for _, conditionType := range []string{vinov1.ConditionTypeReady, vinov1.ConditionTypeDaemonSetReady} {
+		apimeta.SetStatusCondition(&vino.Status.Conditions, metav1.Condition{
+			Status:             metav1.ConditionFalse,
+			Reason:             reason,
+			Message:            message,
+			Type:               conditionType,
+			ObservedGeneration: vino.GetGeneration(),
+		})
+	}
+	return ctrl.Result{RequeueAfter: DaemonSetRequeueInterval}
+}
+
+func getTimeout(timeout *metav1.Duration, defaultTimeout time.Duration) time.Duration {
+	if timeout == nil {
+		return defaultTimeout
+	}
+	return timeout.Duration
 }
 
 func (r *VinoReconciler) decorateDaemonSet(ctx context.Context, ds *appsv1.DaemonSet, vino *vinov1.Vino) {
@@ -229,8 +470,39 @@ func (r *VinoReconciler) decorateDaemonSet(ctx context.Context, ds *appsv1.Daemo
 	ds.Namespace = getRuntimeNamespace()
 	ds.Name = r.getDaemonSetName(vino)
 
-	setEnv(ctx, ds, vinov1.EnvVarBasicAuthUsername, vino.Spec.BMCCredentials.Username)
-	setEnv(ctx, ds, vinov1.EnvVarBasicAuthPassword, vino.Spec.BMCCredentials.Password)
+	sensitive := newSensitiveEnvVars(r.SensitiveEnvVars)
+	if vino.Spec.BMCCredentials.CredentialsSecretRef != nil {
+		secretName := managers.BMCCredentialsSecretName(vino)
+		setEnvVar(ctx, ds, secretEnvVar(vinov1.EnvVarBasicAuthUsername, secretName,
+			vinov1.VinoBMCCredentialsUsernameKey), sensitive)
+		setEnvVar(ctx, ds, secretEnvVar(vinov1.EnvVarBasicAuthPassword, secretName,
+			vinov1.VinoBMCCredentialsPasswordKey), sensitive)
+	} else {
+		// deprecated plain text credentials
+		setEnvVar(ctx, ds, corev1.EnvVar{
+			Name:  vinov1.EnvVarBasicAuthUsername,
+			Value: vino.Spec.BMCCredentials.Username,
+		}, sensitive)
+		setEnvVar(ctx, ds, corev1.EnvVar{
+			Name:  vinov1.EnvVarBasicAuthPassword,
+			Value: vino.Spec.BMCCredentials.Password,
+		}, sensitive)
+	}
+
+	setEnv(ctx, ds, EnvVarVinoOwner, client.ObjectKeyFromObject(vino).String())
+
+	if vino.Spec.BMCCredentials.Generate {
+		decorateSushy(ds, vino)
+	}
+	decorateBuilder(ds, vino)
+
+	// DaemonSet lives in the runtime namespace, so an owner reference to the vino CR can't be used,
+	// labels are used instead to map DaemonSet events back to the vino CR
+	if ds.Labels == nil {
+		ds.Labels = make(map[string]string)
+	}
+	ds.Labels[vinov1.VinoLabelDSNameSelector] = vino.Name
+	ds.Labels[vinov1.VinoLabelDSNamespaceSelector] = vino.Namespace
 
 	// this will help avoid colisions if we have two vino CRs in the same namespace
 	ds.Spec.Selector.MatchLabels[vinov1.VinoLabelDSNameSelector] = vino.Name
@@ -240,67 +512,184 @@ func (r *VinoReconciler) decorateDaemonSet(ctx context.Context, ds *appsv1.Daemo
 	ds.Spec.Template.ObjectMeta.Labels[vinov1.VinoLabelDSNamespaceSelector] = vino.Namespace
 }
 
+// decorateSushy mounts htpasswd secret with generated credentials to sushy container and
+// enables authentication. Probes can't authenticate, so they only check that sushy is listening.
+func decorateSushy(ds *appsv1.DaemonSet, vino *vinov1.Vino) {
+	optional := true
+	podSpec := &ds.Spec.Template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: sushyConfigVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: managers.HtpasswdSecretName(vino),
+				Optional:   &optional,
+			},
+		},
+	})
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		if container.Name != ContainerNameSushy {
+			continue
+		}
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      sushyConfigVolumeName,
+			MountPath: managers.SushyConfigDir,
+			ReadOnly:  true,
+		})
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  EnvVarSushyConfig,
+			Value: managers.SushyConfigDir + "/" + managers.SushyConfigKey,
+		})
+		for _, probe := range []*corev1.Probe{container.ReadinessProbe, container.LivenessProbe} {
+			if probe != nil && probe.HTTPGet != nil {
+				probe.TCPSocket = &corev1.TCPSocketAction{Host: probe.HTTPGet.Host, Port: probe.HTTPGet.Port}
+				probe.HTTPGet = nil
+			}
+		}
+	}
+}
+
+// decorateBuilder mounts VNC passwords secret to vino-builder container, VNC passwords of VMs
+// are read from it instead of VinoBuilderConfigs
+func decorateBuilder(ds *appsv1.DaemonSet, vino *vinov1.Vino) {
+	podSpec := &ds.Spec.Template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: vncPasswordsVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: managers.VNCPasswordsSecretName(vino)},
+		},
+	})
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		if container.Name == ContainerNameBuilder {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      vncPasswordsVolumeName,
+				MountPath: managers.VNCPasswordsDir,
+				ReadOnly:  true,
+			})
+		}
+	}
+}
+
+// sensitiveEnvVars is a set of names of environment variables, whose values must not be logged
+type sensitiveEnvVars map[string]struct{}
+
+// newSensitiveEnvVars returns a set of DefaultSensitiveEnvVars and the given names
+func newSensitiveEnvVars(names []string) sensitiveEnvVars {
+	s := sensitiveEnvVars{}
+	for _, name := range append(append([]string{}, DefaultSensitiveEnvVars...), names...) {
+		s[name] = struct{}{}
+	}
+	return s
+}
+
+// logValue returns value of the variable that is safe to log. Values of sensitive variables
+// and values that come from secrets are redacted.
+func (s sensitiveEnvVars) logValue(envVar corev1.EnvVar) string {
+	if _, sensitive := s[envVar.Name]; sensitive {
+		return RedactedValue
+	}
+	if envVar.ValueFrom != nil && envVar.ValueFrom.SecretKeyRef != nil {
+		return RedactedValue
+	}
+	return envVar.Value
+}
+
 // setEnv iterates over all container specs and sets the variable varName to
-// varValue. If varName already exists for a container, setEnv overrides it
+// varValue. If varName already exists for a container, setEnv overrides it.
+// Values of DefaultSensitiveEnvVars are never logged.
 func setEnv(ctx context.Context, ds *appsv1.DaemonSet, varName, varValue string) {
+	setEnvVar(ctx, ds, corev1.EnvVar{Name: varName, Value: varValue}, newSensitiveEnvVars(nil))
+}
+
+// setEnvVar iterates over all container specs and sets the variable. If the variable
+// already exists for a container, setEnvVar overrides it
+func setEnvVar(ctx context.Context, ds *appsv1.DaemonSet, envVar corev1.EnvVar, sensitive sensitiveEnvVars) {
 	for i := range ds.Spec.Template.Spec.Containers {
-		setContainerEnv(ctx, &ds.Spec.Template.Spec.Containers[i], varName, varValue)
+		setContainerEnv(ctx, &ds.Spec.Template.Spec.Containers[i], envVar, sensitive)
 	}
 }
 
-// setContainerEnv sets the variable varName to varValue for the container. If
-// varName already exists for the container, setContainerEnv overrides it
-func setContainerEnv(ctx context.Context, container *corev1.Container, varName, varValue string) {
-	for j, envVar := range container.Env {
-		if envVar.Name == varName {
+// secretEnvVar returns the variable, that takes its value from the key of the secret
+func secretEnvVar(varName, secretName, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: varName,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Key:                  key,
+			},
+		},
+	}
+}
+
+// setContainerEnv sets the variable for the container. If the variable already
+// exists for the container, setContainerEnv overrides it
+func setContainerEnv(ctx context.Context,
+	container *corev1.Container,
+	envVar corev1.EnvVar,
+	sensitive sensitiveEnvVars) {
+	for j, existing := range container.Env {
+		if existing.Name == envVar.Name {
 			logr.FromContext(ctx).Info("found pre-existing environment variable on daemonset template, overriding it",
 				"container name", container.Name,
-				"environment variable", envVar.Name,
-				"old value", envVar.Value,
-				"new value", varValue,
+				"environment variable", existing.Name,
+				"old value", sensitive.logValue(existing),
+				"new value", sensitive.logValue(envVar),
 			)
-			container.Env[j].Value = varValue
+			container.Env[j] = envVar
 			return
 		}
 	}
 
 	// If we've made it this far, the variable didn't exist.
-	container.Env = append(
-		container.Env, corev1.EnvVar{
-			Name:  varName,
-			Value: varValue,
-		},
-	)
-}
-
-func (r *VinoReconciler) waitDaemonSet(ctx context.Context, check dsWaitCondition, ds *appsv1.DaemonSet) error {
-	logger := logr.FromContext(ctx).WithValues(
-		"daemonset", ds.Namespace+"/"+ds.Name)
-	for {
-		select {
-		case <-ctx.Done():
-			logger.Info("context canceled")
-			return ctx.Err()
-		default:
-			getDS := &appsv1.DaemonSet{}
-			err := r.Get(ctx, types.NamespacedName{
-				Name:      ds.Name,
-				Namespace: ds.Namespace,
-			}, getDS)
-			if err != nil {
-				logger.Info("received error while waiting for ds to reach desired condition, sleeping",
-					"error", err.Error())
-			} else {
-				logger.Info("checking daemonset status", "status", getDS.Status)
-				if check(getDS) {
-					logger.Info("DaemonSet is in ready status")
-					return nil
-				}
-				logger.Info("DaemonSet is not in ready status, rechecking in 2 seconds")
-			}
-			time.Sleep(10 * time.Second)
+	container.Env = append(container.Env, envVar)
+}
+
+// ensureBMCCredentials copies BMC credentials secret referenced by vino CR to the runtime
+// namespace, where vino-builder pods can consume it. If credentials are generated, htpasswd
+// secret mounted to sushy is created there as well.
+func (r *VinoReconciler) ensureBMCCredentials(ctx context.Context, vino *vinov1.Vino) error {
+	if vino.Spec.BMCCredentials.Generate {
+		if err := managers.EnsureHtpasswdSecret(ctx, r.Client, getRuntimeNamespace(), vino); err != nil {
+			return err
 		}
 	}
+	if vino.Spec.BMCCredentials.CredentialsSecretRef == nil {
+		return nil
+	}
+	username, password, err := managers.GetBMCCredentials(ctx, r.Client, vino)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      managers.BMCCredentialsSecretName(vino),
+			Namespace: getRuntimeNamespace(),
+		},
+	}
+	err = r.Get(ctx, client.ObjectKeyFromObject(secret), secret)
+	if err != nil && !apierror.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	// secret is deleted together with other objects labeled for vino CR on teardown
+	if secret.Labels == nil {
+		secret.Labels = make(map[string]string)
+	}
+	secret.Labels[vinov1.VinoLabelDSNameSelector] = vino.Name
+	secret.Labels[vinov1.VinoLabelDSNamespaceSelector] = vino.Namespace
+	secret.Type = corev1.SecretTypeOpaque
+	secret.Data = map[string][]byte{
+		vinov1.VinoBMCCredentialsUsernameKey: []byte(username),
+		vinov1.VinoBMCCredentialsPasswordKey: []byte(password),
+	}
+	if exists {
+		return r.Update(ctx, secret)
+	}
+	return r.Create(ctx, secret)
 }
 
 func (r *VinoReconciler) daemonSet(ctx context.Context, vino *vinov1.Vino) (*appsv1.DaemonSet, error) {
@@ -343,35 +732,302 @@ func (r *VinoReconciler) daemonSet(ctx context.Context, vino *vinov1.Vino) (*app
 func (r *VinoReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&vinov1.Vino{}, builder.WithPredicates(
-			predicate.GenerationChangedPredicate{},
+			// annotations trigger rotation of generated BMC credentials
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}),
 		)).
+		Watches(
+			&source.Kind{Type: &appsv1.DaemonSet{}},
+			handler.EnqueueRequestsFromMapFunc(vinoRequestFromLabels),
+			builder.WithPredicates(daemonSetPredicate()),
+		).
+		// vino-builders report state of VMs to status of VinoBuilderConfigs
+		Owns(&vinov1.VinoBuilderConfig{}).
+		Watches(
+			&source.Kind{Type: &metal3.BareMetalHost{}},
+			handler.EnqueueRequestsFromMapFunc(vinoRequestFromLabels),
+			builder.WithPredicates(bmhPredicate()),
+		).
+		// VMs are requested from vino-builders on new nodes, and removed from nodes that are gone
+		Watches(
+			&source.Kind{Type: &corev1.Pod{}},
+			handler.EnqueueRequestsFromMapFunc(vinoRequestFromLabels),
+			builder.WithPredicates(podPredicate()),
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Node{}},
+			handler.EnqueueRequestsFromMapFunc(r.vinoRequestsFromNode),
+			builder.WithPredicates(nodePredicate()),
+		).
+		// VMs are resized when a flavor referenced by node sets changes
+		Watches(
+			&source.Kind{Type: &vinov1.VinoFlavor{}},
+			handler.EnqueueRequestsFromMapFunc(r.vinoRequestsFromFlavor),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
+		).
 		Complete(r)
 }
 
-func (r *VinoReconciler) finalize(ctx context.Context, vino *vinov1.Vino) error {
+// vinoRequestsFromFlavor returns reconcile requests for vino CRs, node sets of which reference
+// the flavor
+func (r *VinoReconciler) vinoRequestsFromFlavor(obj client.Object) []reconcile.Request {
+	vinoList := &vinov1.VinoList{}
+	if err := r.List(context.Background(), vinoList); err != nil {
+		ctrl.Log.WithName("vino").Error(err, "failed to list vino CRs referencing flavor", "flavor", obj.GetName())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, vino := range vinoList.Items {
+		for _, nodeSet := range vino.Spec.Nodes {
+			if nodeSet.Flavor == obj.GetName() {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&vino)})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// vinoRequestsFromNode returns reconcile requests for vino CRs, node selector of which matches
+// the k8s node, or that have VMs on the node
+func (r *VinoReconciler) vinoRequestsFromNode(obj client.Object) []reconcile.Request {
+	vinoList := &vinov1.VinoList{}
+	if err := r.List(context.Background(), vinoList); err != nil {
+		ctrl.Log.WithName("vino").Error(err, "failed to list vino CRs selecting node", "node", obj.GetName())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, vino := range vinoList.Items {
+		if vinoSelectsNode(&vino, obj) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&vino)})
+		}
+	}
+	return requests
+}
+
+// vinoSelectsNode returns true if node selector of vino CR matches the k8s node, or vino CR has
+// VMs on the node
+func vinoSelectsNode(vino *vinov1.Vino, node client.Object) bool {
+	for _, nodeStatus := range vino.Status.Nodes {
+		if nodeStatus.Name == node.GetName() {
+			return true
+		}
+	}
+	if vino.Spec.NodeSelector == nil {
+		return false
+	}
+	return labels.SelectorFromSet(vino.Spec.NodeSelector.MatchLabels).Matches(labels.Set(node.GetLabels()))
+}
+
+// vinoRequestFromLabels returns reconcile request for the vino CR, that object belongs to, based
+// on its labels. If object doesn't have vino labels, no requests are returned
+func vinoRequestFromLabels(obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	name, nameOk := labels[vinov1.VinoLabelDSNameSelector]
+	namespace, namespaceOk := labels[vinov1.VinoLabelDSNamespaceSelector]
+	if !nameOk || !namespaceOk {
+		return nil
+	}
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}},
+	}
+}
+
+// daemonSetPredicate filters DaemonSet events, that should trigger reconciliation of the vino CR:
+// DaemonSet is deleted, its spec is changed or its readiness has changed
+func daemonSetPredicate() predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool {
+			return false
+		},
+		DeleteFunc: func(event.DeleteEvent) bool {
+			return true
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldDS, oldOk := e.ObjectOld.(*appsv1.DaemonSet)
+			newDS, newOk := e.ObjectNew.(*appsv1.DaemonSet)
+			if !oldOk || !newOk {
+				return false
+			}
+			return oldDS.GetGeneration() != newDS.GetGeneration() ||
+				dsScheduled(oldDS) != dsScheduled(newDS) ||
+				dsReady(oldDS) != dsReady(newDS)
+		},
+		GenericFunc: func(event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// podPredicate filters vino-builder pod events, that should trigger reconciliation of the vino
+// CR: pod is created or deleted, it is scheduled to a node, is being deleted or its readiness
+// has changed
+func podPredicate() predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool {
+			return true
+		},
+		DeleteFunc: func(event.DeleteEvent) bool {
+			return true
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldPod, oldOk := e.ObjectOld.(*corev1.Pod)
+			newPod, newOk := e.ObjectNew.(*corev1.Pod)
+			if !oldOk || !newOk {
+				return false
+			}
+			return oldPod.Spec.NodeName != newPod.Spec.NodeName ||
+				(oldPod.DeletionTimestamp == nil) != (newPod.DeletionTimestamp == nil) ||
+				podReady(oldPod) != podReady(newPod)
+		},
+		GenericFunc: func(event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// nodePredicate filters k8s node events, that should trigger reconciliation of vino CRs: node is
+// created or deleted, or its labels have changed, so that it may join or leave node selectors, or
+// its host inventory has changed, so that capacity for VMs is checked again
+func nodePredicate() predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool {
+			return true
+		},
+		DeleteFunc: func(event.DeleteEvent) bool {
+			return true
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) ||
+				e.ObjectOld.GetAnnotations()[vinov1.VinoHostInventoryAnnotation] !=
+					e.ObjectNew.GetAnnotations()[vinov1.VinoHostInventoryAnnotation]
+		},
+		GenericFunc: func(event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// podReady returns true if pod has Ready condition set to true
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// bmhPredicate filters BMH events, that should trigger reconciliation of the vino CR:
+// BMH is deleted or its provisioning state has changed, so that it's reflected in vino status
+func bmhPredicate() predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool {
+			return false
+		},
+		DeleteFunc: func(event.DeleteEvent) bool {
+			return true
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldBMH, oldOk := e.ObjectOld.(*metal3.BareMetalHost)
+			newBMH, newOk := e.ObjectNew.(*metal3.BareMetalHost)
+			if !oldOk || !newOk {
+				return false
+			}
+			return oldBMH.Status.Provisioning.State != newBMH.Status.Provisioning.State
+		},
+		GenericFunc: func(event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// finalize removes objects created for vino CR. vino-builders remove domains of vino CR first,
+// its DaemonSet and VinoBuilderConfigs are deleted only after they are done or ready timeout passes
+func (r *VinoReconciler) finalize(ctx context.Context, vino *vinov1.Vino) (ctrl.Result, error) {
+	logger := logr.FromContext(ctx)
+	setTeardownCondition(vino, metav1.ConditionFalse, vinov1.TeardownInProgressReason,
+		"Removing objects created for vino CR")
+
 	bmhManager := &managers.BMHManager{
 		Namespace: getRuntimeNamespace(),
 		ViNO:      vino,
 		Client:    r.Client,
 		Ipam:      r.Ipam,
-		Logger:    logr.FromContext(ctx),
+		Logger:    logger,
 	}
-	if err := bmhManager.UnScheduleVMs(ctx); err != nil {
-		return err
+
+	pending, err := bmhManager.RemoveDomains(ctx)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to request removal of VMs: %w", err)
+	}
+	timeout := getTimeout(vino.Spec.DaemonSetOptions.ReadyTimeout, vinov1.VinoDefaultReadyTimeout)
+	if len(pending) != 0 {
+		message := fmt.Sprintf("Waiting for vino-builders to remove VMs on nodes %s",
+			strings.Join(pending, ", "))
+		if time.Since(vino.DeletionTimestamp.Time) < timeout {
+			setTeardownCondition(vino, metav1.ConditionFalse, vinov1.TeardownInProgressReason, message)
+			if err = r.patchStatus(ctx, vino); err != nil {
+				return ctrl.Result{}, fmt.Errorf("unable to patch status while removing VMs: %w", err)
+			}
+			logger.Info(message+", requeueing", "requeue after", DaemonSetRequeueInterval)
+			return ctrl.Result{RequeueAfter: DaemonSetRequeueInterval}, nil
+		}
+		logger.Info("vino-builders haven't removed VMs in time, tearing down anyway",
+			"nodes", pending, "timeout", timeout)
+	}
+	if err = r.patchStatus(ctx, vino); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to patch status before teardown: %w", err)
+	}
+
+	errs := []error{}
+	if err = bmhManager.TearDown(ctx); err != nil {
+		errs = append(errs, err)
 	}
 
-	// TODO aggregate errors instead
-	if err := r.Delete(ctx,
+	if err = r.Delete(ctx,
 		&appsv1.DaemonSet{
 			ObjectMeta: metav1.ObjectMeta{
 				Name: r.getDaemonSetName(vino), Namespace: getRuntimeNamespace(),
 			},
 		}); err != nil && !apierror.IsNotFound(err) {
-		return err
+		errs = append(errs, err)
+	}
+
+	if len(errs) != 0 {
+		err = fmt.Errorf("failed to tear down vino CR: %w", kerror.NewAggregate(errs))
+		setTeardownCondition(vino, metav1.ConditionFalse, vinov1.TeardownFailedReason, err.Error())
+		if patchStatusErr := r.patchStatus(ctx, vino); patchStatusErr != nil {
+			err = kerror.NewAggregate([]error{err, patchStatusErr})
+		}
+		return ctrl.Result{}, err
+	}
+
+	setTeardownCondition(vino, metav1.ConditionTrue, vinov1.ReconciliationSucceededReason,
+		"All objects created for vino CR are removed")
+	if err = r.patchStatus(ctx, vino); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to patch status after teardown: %w", err)
 	}
 
 	controllerutil.RemoveFinalizer(vino, vinov1.VinoFinalizer)
-	return r.Update(ctx, vino)
+	return ctrl.Result{}, r.Update(ctx, vino)
+}
+
+// setTeardownCondition reflects teardown progress in Ready and TeardownComplete conditions
+func setTeardownCondition(vino *vinov1.Vino, status metav1.ConditionStatus, reason, message string) {
+	apimeta.SetStatusCondition(&vino.Status.Conditions, metav1.Condition{
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		Type:               vinov1.ConditionTypeTeardownComplete,
+		ObservedGeneration: vino.GetGeneration(),
+	})
+	apimeta.SetStatusCondition(&vino.Status.Conditions, metav1.Condition{
+		Status:             metav1.ConditionFalse,
+		Reason:             vinov1.TeardownInProgressReason,
+		Message:            "Vino CR is being deleted",
+		Type:               vinov1.ConditionTypeReady,
+		ObservedGeneration: vino.GetGeneration(),
+	})
 }
 
 func getRuntimeNamespace() string {
@@ -385,5 +1041,3 @@ func dsScheduled(ds *appsv1.DaemonSet) bool {
 func dsReady(ds *appsv1.DaemonSet) bool {
 	return ds.Status.DesiredNumberScheduled != 0 && ds.Status.DesiredNumberScheduled == ds.Status.NumberReady
 }
-
-type dsWaitCondition func(ds *appsv1.DaemonSet) bool
@@ -1,15 +1,30 @@
 package controllers
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"os"
+	"time"
 
 	"github.com/go-logr/logr"
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	crzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	vinov1 "vino/pkg/api/v1"
+	"vino/pkg/managers"
 )
 
 func testDS() *appsv1.DaemonSet {
@@ -153,3 +168,602 @@ var _ = Describe("Test Setting Env variables", func() {
 		})
 	})
 })
+
+var _ = Describe("Test DaemonSet watches", func() {
+	Context("when DaemonSet event is mapped to vino CR", func() {
+		It("returns request for vino CR from DaemonSet labels", func() {
+			ds := testDS()
+			ds.Labels = map[string]string{
+				vinov1.VinoLabelDSNameSelector:      "vino",
+				vinov1.VinoLabelDSNamespaceSelector: "vino-ns",
+			}
+
+			requests := vinoRequestFromLabels(ds)
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].Name).To(Equal("vino"))
+			Expect(requests[0].Namespace).To(Equal("vino-ns"))
+		})
+		It("returns no requests if DaemonSet doesn't have vino labels", func() {
+			ds := testDS()
+			ds.Labels = map[string]string{
+				vinov1.VinoLabelDSNameSelector: "vino",
+			}
+
+			Expect(vinoRequestFromLabels(ds)).To(BeEmpty())
+		})
+	})
+	Context("when DaemonSet is updated", func() {
+		p := daemonSetPredicate()
+		It("skips status updates that don't change DaemonSet readiness", func() {
+			oldDS := testDS()
+			oldDS.Status.DesiredNumberScheduled = 2
+			newDS := oldDS.DeepCopy()
+			newDS.Status.ObservedGeneration = 2
+
+			Expect(p.Update(event.UpdateEvent{ObjectOld: oldDS, ObjectNew: newDS})).To(BeFalse())
+		})
+		It("triggers reconciliation when DaemonSet becomes unhealthy", func() {
+			oldDS := testDS()
+			oldDS.Status.DesiredNumberScheduled = 2
+			oldDS.Status.CurrentNumberScheduled = 2
+			oldDS.Status.NumberReady = 2
+			newDS := oldDS.DeepCopy()
+			newDS.Status.NumberReady = 1
+
+			Expect(p.Update(event.UpdateEvent{ObjectOld: oldDS, ObjectNew: newDS})).To(BeTrue())
+		})
+		It("triggers reconciliation when DaemonSet spec is edited", func() {
+			oldDS := testDS()
+			newDS := oldDS.DeepCopy()
+			newDS.Generation = 2
+
+			Expect(p.Update(event.UpdateEvent{ObjectOld: oldDS, ObjectNew: newDS})).To(BeTrue())
+		})
+		It("triggers reconciliation when DaemonSet is deleted", func() {
+			Expect(p.Delete(event.DeleteEvent{Object: testDS()})).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("Test BMH watches", func() {
+	Context("when BMH is updated", func() {
+		p := bmhPredicate()
+		It("triggers reconciliation when provisioning state changes", func() {
+			oldBMH := &metal3.BareMetalHost{}
+			oldBMH.Status.Provisioning.State = metal3.StateRegistering
+			newBMH := oldBMH.DeepCopy()
+			newBMH.Status.Provisioning.State = metal3.StateReady
+
+			Expect(p.Update(event.UpdateEvent{ObjectOld: oldBMH, ObjectNew: newBMH})).To(BeTrue())
+		})
+		It("skips updates that don't change provisioning state", func() {
+			oldBMH := &metal3.BareMetalHost{}
+			oldBMH.Status.Provisioning.State = metal3.StateReady
+			newBMH := oldBMH.DeepCopy()
+			newBMH.Status.PoweredOn = true
+
+			Expect(p.Update(event.UpdateEvent{ObjectOld: oldBMH, ObjectNew: newBMH})).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Test node watches", func() {
+	Context("when vino-builder pod is updated", func() {
+		p := podPredicate()
+		It("triggers reconciliation when pod is scheduled to a node", func() {
+			oldPod := &corev1.Pod{}
+			newPod := oldPod.DeepCopy()
+			newPod.Spec.NodeName = "node01"
+
+			Expect(p.Update(event.UpdateEvent{ObjectOld: oldPod, ObjectNew: newPod})).To(BeTrue())
+		})
+		It("triggers reconciliation when pod becomes ready", func() {
+			oldPod := &corev1.Pod{Spec: corev1.PodSpec{NodeName: "node01"}}
+			newPod := oldPod.DeepCopy()
+			newPod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+
+			Expect(p.Update(event.UpdateEvent{ObjectOld: oldPod, ObjectNew: newPod})).To(BeTrue())
+		})
+		It("skips updates that don't change pod node or readiness", func() {
+			oldPod := &corev1.Pod{Spec: corev1.PodSpec{NodeName: "node01"}}
+			newPod := oldPod.DeepCopy()
+			newPod.Status.PodIP = "10.0.0.1"
+
+			Expect(p.Update(event.UpdateEvent{ObjectOld: oldPod, ObjectNew: newPod})).To(BeFalse())
+		})
+	})
+	Context("when k8s node is updated", func() {
+		p := nodePredicate()
+		It("triggers reconciliation when node labels change", func() {
+			oldNode := &corev1.Node{}
+			newNode := oldNode.DeepCopy()
+			newNode.Labels = map[string]string{"vino": "true"}
+
+			Expect(p.Update(event.UpdateEvent{ObjectOld: oldNode, ObjectNew: newNode})).To(BeTrue())
+		})
+		It("skips node status updates", func() {
+			oldNode := &corev1.Node{}
+			oldNode.Labels = map[string]string{"vino": "true"}
+			newNode := oldNode.DeepCopy()
+			newNode.Status.Phase = corev1.NodeRunning
+
+			Expect(p.Update(event.UpdateEvent{ObjectOld: oldNode, ObjectNew: newNode})).To(BeFalse())
+		})
+	})
+	Context("when k8s node event is mapped to vino CRs", func() {
+		vino := &vinov1.Vino{
+			Spec:   vinov1.VinoSpec{NodeSelector: &vinov1.NodeSelector{MatchLabels: map[string]string{"vino": "true"}}},
+			Status: vinov1.VinoStatus{Nodes: []vinov1.NodeStatus{{Name: "node01"}}},
+		}
+		It("selects nodes matching node selector", func() {
+			node := &corev1.Node{}
+			node.Name = "node02"
+			node.Labels = map[string]string{"vino": "true", "role": "worker"}
+
+			Expect(vinoSelectsNode(vino, node)).To(BeTrue())
+		})
+		It("selects nodes vino CR has VMs on", func() {
+			node := &corev1.Node{}
+			node.Name = "node01"
+
+			Expect(vinoSelectsNode(vino, node)).To(BeTrue())
+		})
+		It("skips other nodes", func() {
+			node := &corev1.Node{}
+			node.Name = "node03"
+
+			Expect(vinoSelectsNode(vino, node)).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Test node set scale down", func() {
+	Context("when VMs above node set count are removed", func() {
+		It("reports VMs that are kept in ScaledDown condition", func() {
+			vino := &vinov1.Vino{}
+			setScaledDownCondition(vino, map[string]string{
+				"default-vino-node01-worker-3": "BMH is provisioned",
+				"default-vino-node01-worker-2": "BMH is protected from deletion",
+			})
+
+			condition := apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypeScaledDown)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(vinov1.ScaleDownBlockedReason))
+			Expect(condition.Message).To(Equal("VMs above node set count are kept: " +
+				"default-vino-node01-worker-2: BMH is protected from deletion; " +
+				"default-vino-node01-worker-3: BMH is provisioned"))
+		})
+		It("reports success when all VMs above count are removed", func() {
+			vino := &vinov1.Vino{}
+			setScaledDownCondition(vino, nil)
+
+			condition := apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypeScaledDown)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		})
+	})
+})
+
+var _ = Describe("Test node set placement", func() {
+	Context("when VMs of node sets are placed on k8s nodes", func() {
+		It("reports unsatisfiable node sets in PlacementSatisfied condition", func() {
+			vino := &vinov1.Vino{}
+			setPlacementCondition(vino, map[string]string{
+				"worker": "no k8s nodes match node selector of the node set",
+				"master": "2 of 3 VMs are placed on 2 eligible k8s nodes",
+			})
+
+			condition := apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypePlacementSatisfied)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(vinov1.PlacementUnsatisfiableReason))
+			Expect(condition.Message).To(Equal("Placement of node sets is unsatisfiable: " +
+				"master: 2 of 3 VMs are placed on 2 eligible k8s nodes; " +
+				"worker: no k8s nodes match node selector of the node set"))
+		})
+		It("reports success when all VMs are placed", func() {
+			vino := &vinov1.Vino{}
+			setPlacementCondition(vino, map[string]string{})
+
+			condition := apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypePlacementSatisfied)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		})
+	})
+})
+
+var _ = Describe("Test host capacity", func() {
+	Context("when VMs are checked against host capacity", func() {
+		It("reports nodes without capacity in HostCapacitySufficient condition", func() {
+			vino := &vinov1.Vino{}
+			err := fmt.Errorf("failed to request VMs: %w", &managers.CapacityError{
+				Node:   "node01",
+				Reason: "VMs need 12 1GiB huge pages, 8 are reserved",
+			})
+			setHostCapacityCondition(vino, err)
+
+			condition := apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypeHostCapacitySufficient)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(vinov1.InsufficientHostCapacityReason))
+			Expect(condition.Message).To(Equal(
+				"node node01 doesn't have capacity for VMs: VMs need 12 1GiB huge pages, 8 are reserved"))
+
+			// other errors don't tell whether capacity is sufficient
+			setHostCapacityCondition(vino, fmt.Errorf("failed"))
+			condition = apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypeHostCapacitySufficient)
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+
+			setHostCapacityCondition(vino, nil)
+			condition = apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypeHostCapacitySufficient)
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		})
+	})
+})
+
+var _ = Describe("Test reconciliation phases", func() {
+	Context("when vino CR waits in a phase", func() {
+		It("records the phase and requeues reconciliation", func() {
+			vino := &vinov1.Vino{}
+
+			result := waitInPhase(vino, vinov1.VinoPhaseDaemonSetApplied, time.Minute, "DaemonSet")
+			Expect(result.RequeueAfter).To(Equal(DaemonSetRequeueInterval))
+			Expect(vino.Status.Phase).To(Equal(vinov1.VinoPhaseDaemonSetApplied))
+			Expect(vino.Status.PhaseTransitionTime).NotTo(BeNil())
+		})
+		It("keeps phase transition time while phase doesn't change", func() {
+			transitionTime := metav1.NewTime(time.Now().Add(-time.Second))
+			vino := &vinov1.Vino{Status: vinov1.VinoStatus{
+				Phase:               vinov1.VinoPhaseVMsRequested,
+				PhaseTransitionTime: &transitionTime,
+			}}
+
+			waitInPhase(vino, vinov1.VinoPhaseVMsRequested, time.Minute, "vino-builders")
+			Expect(vino.Status.PhaseTransitionTime).To(Equal(&transitionTime))
+		})
+		It("reports timeout and keeps requeueing if vino CR stays in the phase longer than timeout", func() {
+			transitionTime := metav1.NewTime(time.Now().Add(-time.Hour))
+			vino := &vinov1.Vino{Status: vinov1.VinoStatus{
+				Phase:               vinov1.VinoPhaseDaemonSetApplied,
+				PhaseTransitionTime: &transitionTime,
+			}}
+
+			result := waitInPhase(vino, vinov1.VinoPhaseDaemonSetApplied, time.Minute, "DaemonSet")
+			Expect(result.RequeueAfter).To(Equal(DaemonSetRequeueInterval))
+			for _, conditionType := range []string{vinov1.ConditionTypeReady, vinov1.ConditionTypeDaemonSetReady} {
+				condition := apimeta.FindStatusCondition(vino.Status.Conditions, conditionType)
+				Expect(condition).NotTo(BeNil())
+				Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+				Expect(condition.Reason).To(Equal(vinov1.PhaseTimedOutReason))
+				Expect(condition.Message).To(Equal(
+					"Timed out after 1m0s waiting for DaemonSet, current phase is DaemonSetApplied"))
+			}
+
+			// the wait is over as soon as vino CR moves to the next phase
+			waitInPhase(vino, vinov1.VinoPhaseVMsRequested, time.Minute, "vino-builders")
+			condition := apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypeReady)
+			Expect(condition.Reason).To(Equal(vinov1.ProgressingReason))
+		})
+	})
+	Context("when timeout is configured", func() {
+		It("uses default timeout if it is not set on vino CR", func() {
+			Expect(getTimeout(nil, time.Minute)).To(Equal(time.Minute))
+		})
+		It("uses timeout from vino CR", func() {
+			Expect(getTimeout(&metav1.Duration{Duration: time.Second}, time.Minute)).To(Equal(time.Second))
+		})
+	})
+})
+
+var _ = Describe("Test teardown conditions", func() {
+	Context("when vino CR is being deleted", func() {
+		It("marks vino CR as not ready while teardown is in progress", func() {
+			vino := &vinov1.Vino{}
+			vinov1.VinoReady(vino)
+
+			setTeardownCondition(vino, metav1.ConditionFalse, vinov1.TeardownFailedReason, "failed")
+
+			ready := apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypeReady)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+			Expect(ready.Reason).To(Equal(vinov1.TeardownInProgressReason))
+			teardown := apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypeTeardownComplete)
+			Expect(teardown).NotTo(BeNil())
+			Expect(teardown.Status).To(Equal(metav1.ConditionFalse))
+			Expect(teardown.Reason).To(Equal(vinov1.TeardownFailedReason))
+			Expect(teardown.Message).To(Equal("failed"))
+		})
+	})
+})
+
+var _ = Describe("Test teardown", func() {
+	ctx := logr.NewContext(context.Background(), logr.Discard())
+	Context("when vino-builders still run on nodes", func() {
+		It("waits for vino-builders to remove VMs before deleting DaemonSet", func() {
+			Expect(os.Setenv("RUNTIME_NAMESPACE", "vino-system")).To(Succeed())
+			defer os.Unsetenv("RUNTIME_NAMESPACE")
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(vinov1.AddToScheme(scheme)).To(Succeed())
+
+			now := metav1.Now()
+			vino := &vinov1.Vino{ObjectMeta: metav1.ObjectMeta{
+				Name: "vino", Namespace: "default", DeletionTimestamp: &now, ResourceVersion: "1",
+				Finalizers: []string{vinov1.VinoFinalizer},
+			}}
+			vinoLabels := map[string]string{
+				vinov1.VinoLabelDSNameSelector:      "vino",
+				vinov1.VinoLabelDSNamespaceSelector: "default",
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "vino-builder", Namespace: "vino-system", Labels: vinoLabels, ResourceVersion: "1"},
+				Spec: corev1.PodSpec{NodeName: "node01"},
+			}
+			config := &vinov1.VinoBuilderConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            managers.BuilderConfigName(vino, "node01"),
+					Namespace:       "default",
+					Labels:          vinoLabels,
+					ResourceVersion: "1",
+				},
+				Spec: vinov1.VinoBuilderConfigSpec{NodeName: "node01", Builder: vinov1.Builder{
+					Domains: []vinov1.BuilderDomain{{Name: "default-vino-node01-worker-0"}},
+				}},
+			}
+			ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{
+				Name: "default-vino", Namespace: "vino-system", ResourceVersion: "1"}}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(vino, pod, config, ds).Build()
+
+			result, err := (&VinoReconciler{Client: c}).finalize(ctx, vino)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(DaemonSetRequeueInterval))
+
+			updated := &vinov1.VinoBuilderConfig{}
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(config), updated)).To(Succeed())
+			Expect(updated.Spec.Builder.Domains).To(BeEmpty())
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(ds), ds)).To(Succeed())
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(vino), vino)).To(Succeed())
+			teardown := apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypeTeardownComplete)
+			Expect(teardown).NotTo(BeNil())
+			Expect(teardown.Message).To(Equal("Waiting for vino-builders to remove VMs on nodes node01"))
+		})
+	})
+})
+
+var _ = Describe("Test BMC credentials", func() {
+	ctx := logr.NewContext(context.Background(), logr.Discard())
+	testVino := func() *vinov1.Vino {
+		return &vinov1.Vino{
+			ObjectMeta: metav1.ObjectMeta{Name: "vino", Namespace: "default"},
+			Spec: vinov1.VinoSpec{
+				NodeSelector: &vinov1.NodeSelector{},
+				BMCCredentials: vinov1.BMCCredentials{
+					CredentialsSecretRef: &vinov1.NamespacedName{Name: "bmc-credentials", Namespace: "default"},
+				},
+			},
+		}
+	}
+	Context("when vino CR references credentials secret", func() {
+		It("sets DaemonSet env from the secret copied to the runtime namespace", func() {
+			ds := testDS()
+			ds.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{}}
+			ds.Spec.Template.Labels = map[string]string{}
+			ds.Spec.Template.Spec.Containers = []corev1.Container{{
+				Env: []corev1.EnvVar{{Name: vinov1.EnvVarBasicAuthPassword, Value: "template-value"}},
+			}}
+
+			(&VinoReconciler{}).decorateDaemonSet(ctx, ds, testVino())
+
+			env := ds.Spec.Template.Spec.Containers[0].Env
+			Expect(env).To(HaveLen(3))
+			for _, envVar := range env {
+				if envVar.Name == EnvVarVinoOwner {
+					Expect(envVar.Value).To(Equal("default/vino"))
+					continue
+				}
+				Expect(envVar.Value).To(BeEmpty())
+				Expect(envVar.ValueFrom.SecretKeyRef.Name).To(Equal("default-vino-bmc-credentials"))
+			}
+		})
+		It("copies credentials secret to the runtime namespace", func() {
+			Expect(os.Setenv("RUNTIME_NAMESPACE", "vino-system")).To(Succeed())
+			defer os.Unsetenv("RUNTIME_NAMESPACE")
+			c := fake.NewClientBuilder().WithObjects(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "bmc-credentials", Namespace: "default"},
+				Data: map[string][]byte{
+					vinov1.VinoBMCCredentialsUsernameKey: []byte("admin"),
+					vinov1.VinoBMCCredentialsPasswordKey: []byte("secret"),
+				},
+			}).Build()
+			r := &VinoReconciler{Client: c}
+
+			Expect(r.ensureBMCCredentials(ctx, testVino())).To(Succeed())
+			// second run updates the existing copy
+			Expect(r.ensureBMCCredentials(ctx, testVino())).To(Succeed())
+
+			secret := &corev1.Secret{}
+			Expect(c.Get(ctx, client.ObjectKey{Name: "default-vino-bmc-credentials", Namespace: "vino-system"},
+				secret)).To(Succeed())
+			Expect(secret.Data[vinov1.VinoBMCCredentialsPasswordKey]).To(Equal([]byte("secret")))
+			Expect(secret.Labels).To(HaveKeyWithValue(vinov1.VinoLabelDSNameSelector, "vino"))
+		})
+		It("fails if credentials secret has no password", func() {
+			c := fake.NewClientBuilder().WithObjects(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "bmc-credentials", Namespace: "default"},
+				Data:       map[string][]byte{vinov1.VinoBMCCredentialsUsernameKey: []byte("admin")},
+			}).Build()
+
+			Expect((&VinoReconciler{Client: c}).ensureBMCCredentials(ctx, testVino())).To(HaveOccurred())
+		})
+	})
+	Context("when vino CR generates credentials", func() {
+		generatingVino := func() *vinov1.Vino {
+			vino := testVino()
+			vino.Spec.BMCCredentials = vinov1.BMCCredentials{Generate: true}
+			return vino
+		}
+		It("enables sushy authentication with htpasswd secret", func() {
+			ds := testDS()
+			ds.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{}}
+			ds.Spec.Template.Labels = map[string]string{}
+			ds.Spec.Template.Spec.Containers = []corev1.Container{
+				{Name: ContainerNameLibvirt},
+				{
+					Name: ContainerNameSushy,
+					ReadinessProbe: &corev1.Probe{Handler: corev1.Handler{
+						HTTPGet: &corev1.HTTPGetAction{Path: "/redfish/v1/Systems", Port: intstr.FromInt(8000)},
+					}},
+				},
+			}
+
+			(&VinoReconciler{}).decorateDaemonSet(ctx, ds, generatingVino())
+
+			secretNames := []string{}
+			for _, volume := range ds.Spec.Template.Spec.Volumes {
+				secretNames = append(secretNames, volume.Secret.SecretName)
+			}
+			Expect(secretNames).To(ContainElement("default-vino-bmc-htpasswd"))
+			Expect(ds.Spec.Template.Spec.Containers[0].VolumeMounts).To(BeEmpty())
+			sushy := ds.Spec.Template.Spec.Containers[1]
+			Expect(sushy.VolumeMounts).To(HaveLen(1))
+			Expect(sushy.Env).To(ContainElement(corev1.EnvVar{
+				Name:  EnvVarSushyConfig,
+				Value: managers.SushyConfigDir + "/" + managers.SushyConfigKey,
+			}))
+			Expect(sushy.ReadinessProbe.HTTPGet).To(BeNil())
+			Expect(sushy.ReadinessProbe.TCPSocket.Port).To(Equal(intstr.FromInt(8000)))
+		})
+		It("creates htpasswd secret without overwriting it", func() {
+			Expect(os.Setenv("RUNTIME_NAMESPACE", "vino-system")).To(Succeed())
+			defer os.Unsetenv("RUNTIME_NAMESPACE")
+			c := fake.NewClientBuilder().Build()
+			r := &VinoReconciler{Client: c}
+			key := client.ObjectKey{Name: "default-vino-bmc-htpasswd", Namespace: "vino-system"}
+
+			Expect(r.ensureBMCCredentials(ctx, generatingVino())).To(Succeed())
+			secret := &corev1.Secret{}
+			Expect(c.Get(ctx, key, secret)).To(Succeed())
+			Expect(secret.Data).To(HaveKey(managers.SushyConfigKey))
+
+			secret.Data[vinov1.VinoBMCHtpasswdKey] = []byte("user:hash\n")
+			Expect(c.Update(ctx, secret)).To(Succeed())
+			Expect(r.ensureBMCCredentials(ctx, generatingVino())).To(Succeed())
+			Expect(c.Get(ctx, key, secret)).To(Succeed())
+			Expect(secret.Data[vinov1.VinoBMCHtpasswdKey]).To(Equal([]byte("user:hash\n")))
+		})
+	})
+})
+
+var _ = Describe("Test VNC passwords", func() {
+	ctx := logr.NewContext(context.Background(), logr.Discard())
+	Context("when DaemonSet is decorated", func() {
+		It("mounts VNC passwords secret to vino-builder container only", func() {
+			ds := testDS()
+			ds.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{}}
+			ds.Spec.Template.Labels = map[string]string{}
+			ds.Spec.Template.Spec.Containers = []corev1.Container{
+				{Name: ContainerNameLibvirt},
+				{Name: ContainerNameBuilder},
+			}
+			vino := &vinov1.Vino{
+				ObjectMeta: metav1.ObjectMeta{Name: "vino", Namespace: "default"},
+				Spec:       vinov1.VinoSpec{NodeSelector: &vinov1.NodeSelector{}},
+			}
+
+			(&VinoReconciler{}).decorateDaemonSet(ctx, ds, vino)
+
+			volumes := ds.Spec.Template.Spec.Volumes
+			Expect(volumes).To(HaveLen(1))
+			Expect(volumes[0].Secret.SecretName).To(Equal("default-vino-vnc-passwords"))
+			Expect(ds.Spec.Template.Spec.Containers[0].VolumeMounts).To(BeEmpty())
+			Expect(ds.Spec.Template.Spec.Containers[1].VolumeMounts).To(Equal([]corev1.VolumeMount{{
+				Name:      volumes[0].Name,
+				MountPath: managers.VNCPasswordsDir,
+				ReadOnly:  true,
+			}}))
+		})
+	})
+})
+
+var _ = Describe("Test sensitive env vars", func() {
+	const password = "s3cr3t-passw0rd"
+	Context("when DaemonSet env vars are overridden", func() {
+		It("never logs values of sensitive variables", func() {
+			logs := &bytes.Buffer{}
+			ctx := logr.NewContext(context.Background(), crzap.New(crzap.WriteTo(logs)))
+			ds := testDS()
+			ds.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{}}
+			ds.Spec.Template.Labels = map[string]string{}
+			ds.Spec.Template.Spec.Containers = []corev1.Container{{
+				Name: "sushy",
+				Env: []corev1.EnvVar{
+					{Name: vinov1.EnvVarBasicAuthUsername, Value: "admin"},
+					{Name: vinov1.EnvVarBasicAuthPassword, Value: password},
+					{Name: "CUSTOM_TOKEN", Value: password},
+				},
+			}}
+			vino := &vinov1.Vino{Spec: vinov1.VinoSpec{
+				NodeSelector:   &vinov1.NodeSelector{},
+				BMCCredentials: vinov1.BMCCredentials{Username: "admin", Password: password},
+			}}
+			r := &VinoReconciler{SensitiveEnvVars: []string{"CUSTOM_TOKEN"}}
+
+			r.decorateDaemonSet(ctx, ds, vino)
+			setEnvVar(ctx, ds, corev1.EnvVar{Name: "CUSTOM_TOKEN", Value: password},
+				newSensitiveEnvVars(r.SensitiveEnvVars))
+			// credentials from a secret replace plain text credentials
+			vino.Spec.BMCCredentials = vinov1.BMCCredentials{
+				CredentialsSecretRef: &vinov1.NamespacedName{Name: "bmc-credentials"},
+			}
+			r.decorateDaemonSet(ctx, ds, vino)
+
+			Expect(logs.String()).To(ContainSubstring(RedactedValue))
+			Expect(logs.String()).To(ContainSubstring(vinov1.EnvVarBasicAuthPassword))
+			Expect(logs.String()).NotTo(ContainSubstring(password))
+		})
+		It("logs values of other variables", func() {
+			env := corev1.EnvVar{Name: vinov1.EnvVarVMInterfaceName, Value: "eth0"}
+			Expect(newSensitiveEnvVars(nil).logValue(env)).To(Equal("eth0"))
+		})
+		It("treats values from secrets as sensitive", func() {
+			env := secretEnvVar("TOKEN", "secret", "token")
+			Expect(newSensitiveEnvVars(nil).logValue(env)).To(Equal(RedactedValue))
+		})
+	})
+})
+
+var _ = Describe("Test VMs realization", func() {
+	Context("when vino-builders report state of VMs", func() {
+		vino := &vinov1.Vino{ObjectMeta: metav1.ObjectMeta{Name: "vino", Namespace: "default", Generation: 2}}
+		It("reports failed VMs in VMsRealized condition", func() {
+			setVMsRealizedCondition(vino, managers.VMRealization{
+				Pending: []string{"default-vino-node01-worker-2"},
+				Failed: map[string]string{
+					"default-vino-node01-worker-1": "volume is not created",
+					"default-vino-node01-worker-0": "playbook failed",
+				},
+			})
+			condition := apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypeVMsRealized)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(vinov1.VMRealizationFailedReason))
+			Expect(condition.Message).To(Equal("Failed to realize VMs: " +
+				"default-vino-node01-worker-0: playbook failed; default-vino-node01-worker-1: volume is not created"))
+			Expect(condition.ObservedGeneration).To(Equal(int64(2)))
+		})
+		It("reports pending VMs as progressing", func() {
+			setVMsRealizedCondition(vino, managers.VMRealization{Pending: []string{"default-vino-node01-worker-2"}})
+			condition := apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypeVMsRealized)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Reason).To(Equal(vinov1.ProgressingReason))
+		})
+		It("reports success when all VMs are realized", func() {
+			setVMsRealizedCondition(vino, managers.VMRealization{})
+			condition := apimeta.FindStatusCondition(vino.Status.Conditions, vinov1.ConditionTypeVMsRealized)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		})
+	})
+})
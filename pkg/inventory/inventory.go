@@ -0,0 +1,190 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+const (
+	// DefaultSysRoot is the mount point of sysfs
+	DefaultSysRoot = "/sys"
+	// nodesDir is the directory of NUMA nodes in sysfs
+	nodesDir = "devices/system/node"
+	// hugePagesDir is the directory of 1GiB huge pages of a NUMA node
+	hugePagesDir = "hugepages/hugepages-1048576kB"
+)
+
+// Collect returns inventory of the host from sysfs mounted at sysRoot, and of storage pools,
+// given by name with their directories
+func Collect(sysRoot string, storagePools map[string]string) (*vinov1.HostInventory, error) {
+	inventory := &vinov1.HostInventory{}
+	paths, err := filepath.Glob(filepath.Join(sysRoot, nodesDir, "node[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		node, err := numaNode(path)
+		if err != nil {
+			return nil, err
+		}
+		inventory.NUMANodes = append(inventory.NUMANodes, node)
+	}
+	sort.Slice(inventory.NUMANodes, func(i, j int) bool {
+		return inventory.NUMANodes[i].ID < inventory.NUMANodes[j].ID
+	})
+
+	names := make([]string, 0, len(storagePools))
+	for name := range storagePools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pool, err := storagePool(name, storagePools[name])
+		if err != nil {
+			return nil, err
+		}
+		inventory.StoragePools = append(inventory.StoragePools, pool)
+	}
+	return inventory, nil
+}
+
+// numaNode reads CPUs, memory and 1GiB huge pages of the NUMA node from its sysfs directory
+func numaNode(path string) (vinov1.NUMANodeInventory, error) {
+	node := vinov1.NUMANodeInventory{}
+	id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "node"))
+	if err != nil {
+		return node, fmt.Errorf("unexpected NUMA node directory %s: %w", path, err)
+	}
+	node.ID = id
+
+	cpus, err := ioutil.ReadFile(filepath.Join(path, "cpulist"))
+	if err != nil {
+		return node, err
+	}
+	node.CPUs = strings.TrimSpace(string(cpus))
+	if _, err = ParseCPUList(node.CPUs); err != nil {
+		return node, err
+	}
+
+	memInfo, err := readMemInfo(filepath.Join(path, "meminfo"))
+	if err != nil {
+		return node, err
+	}
+	node.MemoryMiB = memInfo["MemTotal"] >> 10
+	node.FreeMemoryMiB = memInfo["MemFree"] >> 10
+
+	// huge pages of 1GiB are not configured on every host
+	if node.HugePages, err = readInt(filepath.Join(path, hugePagesDir, "nr_hugepages")); err != nil &&
+		!os.IsNotExist(err) {
+		return node, err
+	}
+	if node.FreeHugePages, err = readInt(filepath.Join(path, hugePagesDir, "free_hugepages")); err != nil &&
+		!os.IsNotExist(err) {
+		return node, err
+	}
+	return node, nil
+}
+
+// readMemInfo returns values in KiB from meminfo of a NUMA node, where lines look like
+// "Node 0 MemTotal:       32768000 kB"
+func readMemInfo(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]int64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected line in %s: %q", path, scanner.Text())
+		}
+		values[strings.TrimSuffix(fields[2], ":")] = value
+	}
+	return values, scanner.Err()
+}
+
+// readInt reads a file with a single integer
+func readInt(path string) (int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// storagePool returns capacity and available space of the file system of the storage pool
+func storagePool(name, path string) (vinov1.StoragePoolInventory, error) {
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return vinov1.StoragePoolInventory{}, fmt.Errorf("unable to get space of storage pool %s: %w", name, err)
+	}
+	return vinov1.StoragePoolInventory{
+		Name:         name,
+		CapacityGiB:  int64(stat.Blocks) * stat.Bsize >> 30,
+		AvailableGiB: int64(stat.Bavail) * stat.Bsize >> 30,
+	}, nil
+}
+
+// ParseCPUList returns sorted unique cores of a list in cpuset format, for example 0-3,8
+func ParseCPUList(list string) ([]int, error) {
+	seen := map[int]struct{}{}
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("bad CPU range %q: %w", part, err)
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("bad CPU range %q: %w", part, err)
+			}
+		}
+		if start > end {
+			start, end = end, start
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			seen[cpu] = struct{}{}
+		}
+	}
+	cpus := make([]int, 0, len(seen))
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
@@ -0,0 +1,93 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+func TestCollect(t *testing.T) {
+	sysRoot, err := ioutil.TempDir("", "vino-sysfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(sysRoot)
+
+	writeFile := func(path, content string) {
+		path = filepath.Join(sysRoot, nodesDir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	}
+	writeFile("node0/cpulist", "0-3,8-11\n")
+	writeFile("node0/meminfo", "Node 0 MemTotal:       33554432 kB\nNode 0 MemFree:        16777216 kB\n"+
+		"Node 0 HugePages_Total:     8\n")
+	writeFile("node0/"+hugePagesDir+"/nr_hugepages", "8\n")
+	writeFile("node0/"+hugePagesDir+"/free_hugepages", "6\n")
+	// 1GiB huge pages are not configured on node1
+	writeFile("node1/cpulist", "4-7,12-15\n")
+	writeFile("node1/meminfo", "Node 1 MemTotal:       33554432 kB\nNode 1 MemFree:        33554432 kB\n")
+	// not a NUMA node
+	writeFile("possible", "0-1\n")
+
+	inventory, err := Collect(sysRoot, map[string]string{"vino-default": sysRoot})
+	require.NoError(t, err)
+	assert.Equal(t, []vinov1.NUMANodeInventory{
+		{ID: 0, CPUs: "0-3,8-11", MemoryMiB: 32768, FreeMemoryMiB: 16384, HugePages: 8, FreeHugePages: 6},
+		{ID: 1, CPUs: "4-7,12-15", MemoryMiB: 32768, FreeMemoryMiB: 32768},
+	}, inventory.NUMANodes)
+	require.Len(t, inventory.StoragePools, 1)
+	assert.Equal(t, "vino-default", inventory.StoragePools[0].Name)
+	assert.True(t, inventory.StoragePools[0].CapacityGiB >= inventory.StoragePools[0].AvailableGiB)
+
+	_, err = Collect(sysRoot, map[string]string{"vino-default": filepath.Join(sysRoot, "missing")})
+	assert.Error(t, err)
+
+	writeFile("node1/cpulist", "4-x\n")
+	_, err = Collect(sysRoot, nil)
+	assert.Error(t, err)
+}
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		list        string
+		expected    []int
+		expectedErr bool
+	}{
+		{list: "", expected: []int{}},
+		{list: "0-1", expected: []int{0, 1}},
+		{list: "0-1,54-56,3", expected: []int{0, 1, 3, 54, 55, 56}},
+		{list: "3-1, 2", expected: []int{1, 2, 3}},
+		{list: "a-1", expectedErr: true},
+		{list: "1-b", expectedErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.list, func(t *testing.T) {
+			cpus, err := ParseCPUList(tt.list)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, cpus)
+		})
+	}
+}
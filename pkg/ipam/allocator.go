@@ -0,0 +1,339 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+// maxMAC is the greatest 48 bit MAC address
+var maxMAC = uint128{lo: 1<<48 - 1}
+
+// interval is a range of consecutive addresses [first,last]
+type interval struct {
+	first, last uint128
+}
+
+// intervalSet is a set of addresses, stored as sorted intervals that neither overlap nor
+// touch each other. Looking up a free address takes logarithmic time, no matter how many
+// addresses are allocated.
+type intervalSet []interval
+
+// newIntervalSet builds a set from addresses in any order
+func newIntervalSet(addresses []uint128) intervalSet {
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].cmp(addresses[j]) < 0 })
+	s := intervalSet{}
+	for _, a := range addresses {
+		if n := len(s); n != 0 {
+			if s[n-1].last == a {
+				continue
+			}
+			if next, _ := s[n-1].last.add(uint128One); next == a {
+				s[n-1].last = a
+				continue
+			}
+		}
+		s = append(s, interval{first: a, last: a})
+	}
+	return s
+}
+
+// search returns index of the first interval that ends at or after the address
+func (s intervalSet) search(a uint128) int {
+	return sort.Search(len(s), func(i int) bool { return s[i].last.cmp(a) >= 0 })
+}
+
+// firstFree returns the lowest address in [start,stop] range, that is not in the set
+func (s intervalSet) firstFree(start, stop uint128) (uint128, bool) {
+	if start.cmp(stop) > 0 {
+		return uint128{}, false
+	}
+	i := s.search(start)
+	if i == len(s) || s[i].first.cmp(start) > 0 {
+		return start, true
+	}
+	// intervals don't touch, so the address following the interval is free
+	next, overflow := s[i].last.add(uint128One)
+	if overflow || next.cmp(stop) > 0 {
+		return uint128{}, false
+	}
+	return next, true
+}
+
+// insert adds the address to the set, merging it with adjacent intervals
+func (s *intervalSet) insert(a uint128) {
+	set := *s
+	i := set.search(a)
+	if i < len(set) && set[i].first.cmp(a) <= 0 {
+		return
+	}
+	// set[i-1] ends before the address and set[i] starts after it, so neither add overflows
+	joinPrev := false
+	if i > 0 {
+		prevNext, _ := set[i-1].last.add(uint128One)
+		joinPrev = prevNext == a
+	}
+	next, _ := a.add(uint128One)
+	joinNext := i < len(set) && set[i].first == next
+
+	switch {
+	case joinPrev && joinNext:
+		set[i-1].last = set[i].last
+		set = append(set[:i], set[i+1:]...)
+	case joinPrev:
+		set[i-1].last = a
+	case joinNext:
+		set[i].first = a
+	default:
+		set = append(set, interval{})
+		copy(set[i+1:], set[i:])
+		set[i] = interval{first: a, last: a}
+	}
+	*s = set
+}
+
+// poolIndex is an in-memory index of IPs and MACs allocated from an IPPool. It is built
+// from the IPPool and is valid only for the resource version of IPPool it was built from.
+type poolIndex struct {
+	resourceVersion string
+	ipv6            bool
+	ips             intervalSet
+	macs            intervalSet
+	macPrefix       uint128
+	owners          map[string]vinov1.AllocatedIP
+}
+
+func newPoolIndex(ippool *vinov1.IPPool) (*poolIndex, error) {
+	macPrefix, err := macStringToInt(ippool.Spec.MACPrefix)
+	if err != nil {
+		return nil, err
+	}
+	index := &poolIndex{
+		resourceVersion: ippool.ResourceVersion,
+		ipv6:            isIPv6(ippool.Spec.Subnet),
+		macPrefix:       uint128{lo: macPrefix},
+		owners:          make(map[string]vinov1.AllocatedIP, len(ippool.Spec.AllocatedIPs)),
+	}
+	ips := make([]uint128, 0, len(ippool.Spec.AllocatedIPs))
+	macs := make([]uint128, 0, len(ippool.Spec.AllocatedIPs))
+	for _, allocatedIP := range ippool.Spec.AllocatedIPs {
+		ip, err := ipStringToInt(allocatedIP.IP)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+		// MACs are optional, pools allocated by older versions may not have them
+		if allocatedIP.MAC != "" {
+			mac, err := macStringToInt(allocatedIP.MAC)
+			if err != nil {
+				return nil, err
+			}
+			macs = append(macs, uint128{lo: mac})
+		}
+		if _, exists := index.owners[allocatedIP.AllocatedTo]; !exists {
+			index.owners[allocatedIP.AllocatedTo] = allocatedIP
+		}
+	}
+	index.ips = newIntervalSet(ips)
+	index.macs = newIntervalSet(macs)
+	return index, nil
+}
+
+// findFreeIP returns the lowest IP of the range, that is not allocated
+func (p *poolIndex) findFreeIP(subnet string, subnetRange vinov1.Range) (string, error) {
+	start, err := ipStringToInt(subnetRange.Start)
+	if err != nil {
+		return "", err
+	}
+	stop, err := ipStringToInt(subnetRange.Stop)
+	if err != nil {
+		return "", err
+	}
+	ip, found := p.ips.firstFree(start, stop)
+	if !found {
+		return "", ErrSubnetRangeExhausted{subnet, subnetRange}
+	}
+	if p.ipv6 {
+		return intToIPv6String(ip), nil
+	}
+	return intToIPv4String(ip), nil
+}
+
+// findFreeMAC returns the lowest MAC address, starting from the pool MAC prefix,
+// that is not allocated. MAC addresses of released IPs are reused, the same way
+// IP addresses are.
+func (p *poolIndex) findFreeMAC() (string, error) {
+	mac, found := p.macs.firstFree(p.macPrefix, maxMAC)
+	if !found {
+		return "", ErrNotSupported{Message: "unable to find a free MAC address"}
+	}
+	return intToMACString(mac.lo), nil
+}
+
+// allocate returns IP and MAC allocated to the entity, allocating them from the range if
+// the entity has none yet. New allocation is added both to the index and to the pool spec,
+// the second return value is true in that case.
+func (p *poolIndex) allocate(spec *vinov1.IPPoolSpec, subnetRange vinov1.Range,
+	allocatedTo string) (vinov1.AllocatedIP, bool, error) {
+	// If an IP has already been allocated to this entity, return it
+	if allocatedIP, exists := p.owners[allocatedTo]; exists {
+		return allocatedIP, false, nil
+	}
+
+	ip, err := p.findFreeIP(spec.Subnet, subnetRange)
+	if err != nil {
+		return vinov1.AllocatedIP{}, false, err
+	}
+	mac, err := p.findFreeMAC()
+	if err != nil {
+		return vinov1.AllocatedIP{}, false, err
+	}
+	allocatedIP := vinov1.AllocatedIP{IP: ip, MAC: mac, AllocatedTo: allocatedTo}
+	if err = p.add(allocatedIP); err != nil {
+		return vinov1.AllocatedIP{}, false, err
+	}
+	spec.AllocatedIPs = append(spec.AllocatedIPs, allocatedIP)
+	return allocatedIP, true, nil
+}
+
+func (p *poolIndex) add(allocatedIP vinov1.AllocatedIP) error {
+	ip, err := ipStringToInt(allocatedIP.IP)
+	if err != nil {
+		return err
+	}
+	mac, err := macStringToInt(allocatedIP.MAC)
+	if err != nil {
+		return err
+	}
+	p.ips.insert(ip)
+	p.macs.insert(uint128{lo: mac})
+	p.owners[allocatedIP.AllocatedTo] = allocatedIP
+	return nil
+}
+
+// indexCache holds indexes of IPPools by subnet. An index is taken out of the cache while
+// an allocation uses it, and is put back only after the allocation is persisted, so that
+// an index never contains allocations that failed to be saved.
+type indexCache struct {
+	mu    sync.Mutex
+	pools map[string]*poolIndex
+}
+
+// checkout removes the index of the pool from cache and returns it. The index is rebuilt
+// if the cached one was built from a different version of the pool.
+func (c *indexCache) checkout(ippool *vinov1.IPPool) (*poolIndex, error) {
+	c.mu.Lock()
+	index, exists := c.pools[ippool.Spec.Subnet]
+	delete(c.pools, ippool.Spec.Subnet)
+	c.mu.Unlock()
+
+	if exists && index.resourceVersion == ippool.ResourceVersion && ippool.ResourceVersion != "" {
+		return index, nil
+	}
+	return newPoolIndex(ippool)
+}
+
+// checkin puts the index back to cache, unless the index has been rebuilt concurrently
+func (c *indexCache) checkin(subnet string, index *poolIndex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pools == nil {
+		c.pools = make(map[string]*poolIndex)
+	}
+	if _, exists := c.pools[subnet]; !exists {
+		c.pools[subnet] = index
+	}
+}
+
+// sync rebuilds the cached index if it was built from a different version of the pool
+func (c *indexCache) sync(ippool *vinov1.IPPool) error {
+	c.mu.Lock()
+	index, exists := c.pools[ippool.Spec.Subnet]
+	c.mu.Unlock()
+	if exists && index.resourceVersion == ippool.ResourceVersion {
+		return nil
+	}
+
+	index, err := newPoolIndex(ippool)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pools == nil {
+		c.pools = make(map[string]*poolIndex)
+	}
+	c.pools[ippool.Spec.Subnet] = index
+	return nil
+}
+
+func (c *indexCache) remove(subnet string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pools, subnet)
+}
+
+// SetupWithManager registers IPPool event handler with the manager cache, so that indexes
+// are rebuilt as soon as IPPools are changed by other IPAM clients
+func (i *Ipam) SetupWithManager(mgr ctrl.Manager) error {
+	informer, err := mgr.GetCache().GetInformer(context.Background(), &vinov1.IPPool{})
+	if err != nil {
+		return err
+	}
+	informer.AddEventHandler(i.IPPoolEventHandler())
+	return nil
+}
+
+// IPPoolEventHandler returns informer event handler, that keeps indexes of IPPools
+// in sync with IPPools in the IPAM namespace
+func (i *Ipam) IPPoolEventHandler() toolscache.ResourceEventHandler {
+	return toolscache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			ippool, ok := obj.(*vinov1.IPPool)
+			return ok && ippool.Namespace == i.Namespace
+		},
+		Handler: toolscache.ResourceEventHandlerFuncs{
+			AddFunc: i.syncIndex,
+			UpdateFunc: func(_, newObj interface{}) {
+				i.syncIndex(newObj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				i.indexes.remove(obj.(*vinov1.IPPool).Spec.Subnet)
+			},
+		},
+	}
+}
+
+func (i *Ipam) syncIndex(obj interface{}) {
+	ippool := obj.(*vinov1.IPPool)
+	if err := i.indexes.sync(ippool); err != nil {
+		// the index will be built again when an IP is allocated from the pool
+		i.indexes.remove(ippool.Spec.Subnet)
+		i.Log.Error(err, "failed to index IPPool", "subnet", ippool.Spec.Subnet)
+	}
+}
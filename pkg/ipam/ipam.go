@@ -17,29 +17,51 @@ package ipam
 import (
 	"context"
 	"fmt"
-	"math"
 	"net"
 	"regexp"
 	"strings"
+	"time"
 	"unsafe"
 
 	"github.com/go-logr/logr"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	vinov1 "vino/pkg/api/v1"
 )
 
+// maxAllocatedRanges is the maximum number of per host ranges generated for a DHCP range
+const maxAllocatedRanges = 4096
+
+// conflictBackoff is used to retry IPPool changes that conflict with concurrent changes
+var conflictBackoff = wait.Backoff{
+	Steps:    10,
+	Duration: 10 * time.Millisecond,
+	Factor:   1.5,
+	Jitter:   1,
+}
+
 // Ipam provides IPAM reservation, backed by IPPool CRs
 type Ipam struct {
 	Log       logr.Logger
 	Client    client.Client
 	Namespace string
+
+	// indexes of allocated addresses per IPPool, so that allocations don't scan pools
+	indexes indexCache
+}
+
+// IPRequest is a request to allocate an IP address from a range of a subnet
+type IPRequest struct {
+	Subnet      string
+	SubnetRange vinov1.Range
+	// AllocatedTo is a unique identifier of the entity that is requesting the IP
+	AllocatedTo string
 }
 
 // NewIpam initializes an empty IPAM configuration.
-// TODO: add ability to remove IP addresses and ranges
 func NewIpam(logger logr.Logger, client client.Client, namespace string) *Ipam {
 	return &Ipam{
 		Log:       logger,
@@ -59,7 +81,7 @@ func NewRange(start string, stop string) (vinov1.Range, error) {
 	if e != nil {
 		return vinov1.Range{}, e
 	}
-	if b < a {
+	if b.cmp(a) < 0 {
 		return vinov1.Range{}, ErrSubnetRangeInvalid{r}
 	}
 	return r, nil
@@ -72,36 +94,34 @@ func NewRange(start string, stop string) (vinov1.Range, error) {
 // against allocating the exact same subnet+range multiple times.
 // TODO error: invalid range for subnet
 func (i *Ipam) AddSubnetRange(ctx context.Context, subnet string, subnetRange vinov1.Range,
+	macPrefix string) error {
+	return i.retryOnConflict(func() error {
+		return i.addSubnetRange(ctx, subnet, subnetRange, macPrefix)
+	})
+}
+
+func (i *Ipam) addSubnetRange(ctx context.Context, subnet string, subnetRange vinov1.Range,
 	macPrefix string) error {
 	logger := i.Log.WithValues("subnet", subnet, "subnetRange", subnetRange, "macPrefix", macPrefix)
 	// Does the subnet already exist? (this is fine)
-	ippools, err := i.getIPPools(ctx)
+	ippool, err := i.getIPPool(ctx, subnet)
 	if err != nil {
 		return err
 	}
 	// Add the IPAM subnet if it doesn't exist already
-	ippool, exists := ippools[subnet]
-	if !exists {
+	if ippool == nil {
 		logger.Info("IPAM creating subnet")
-		_, err = macStringToInt(macPrefix) // mac format validation
+		ippool, err = newIPPool(subnet, macPrefix)
 		if err != nil {
 			return err
 		}
-		ippool = &vinov1.IPPoolSpec{
-			Subnet:       subnet,
-			Ranges:       []vinov1.Range{},
-			AllocatedIPs: []vinov1.AllocatedIP{},
-			MACPrefix:    macPrefix,
-			NextMAC:      macPrefix,
-		}
-		ippools[subnet] = ippool
-	} else if ippool.MACPrefix != macPrefix {
+	} else if ippool.Spec.MACPrefix != macPrefix {
 		return ErrNotSupported{Message: "Cannot change immutable field `macPrefix`"}
 	}
 
 	// Add the IPAM range to the subnet if it doesn't exist already
-	exists = false
-	for _, existingSubnetRange := range ippools[subnet].Ranges {
+	exists := false
+	for _, existingSubnetRange := range ippool.Spec.Ranges {
 		if existingSubnetRange == subnetRange {
 			exists = true
 			break
@@ -109,8 +129,8 @@ func (i *Ipam) AddSubnetRange(ctx context.Context, subnet string, subnetRange vi
 	}
 	if !exists {
 		logger.Info("IPAM creating subnet")
-		ippool.Ranges = append(ippool.Ranges, subnetRange)
-		err = i.applyIPPool(ctx, *ippool)
+		ippool.Spec.Ranges = append(ippool.Spec.Ranges, subnetRange)
+		err = i.applyIPPool(ctx, ippool)
 		if err != nil {
 			return err
 		}
@@ -125,145 +145,276 @@ func (i *Ipam) AddSubnetRange(ctx context.Context, subnet string, subnetRange vi
 //              the same one.  I.e. this function is idempotent for the same allocatedTo.
 func (i *Ipam) AllocateIP(ctx context.Context, subnet string, subnetRange vinov1.Range,
 	allocatedTo string) (allocatedIP string, allocatedMAC string, err error) {
-	ippools, err := i.getIPPools(ctx)
+	allocated, err := i.AllocateIPs(ctx, []IPRequest{
+		{Subnet: subnet, SubnetRange: subnetRange, AllocatedTo: allocatedTo},
+	})
 	if err != nil {
 		return "", "", err
 	}
-	ippool, exists := ippools[subnet]
-	if !exists {
-		return "", "", ErrSubnetNotAllocated{Subnet: subnet}
-	}
-	// Make sure the range has been allocated within the subnet
-	var match bool
-	for _, r := range ippool.Ranges {
-		if r == subnetRange {
-			match = true
-			break
+	return allocated[0].IP, allocated[0].MAC, nil
+}
+
+// AllocateIPs allocates IPs for a batch of requests, and returns them in the order of requests.
+// All requests for the same subnet are allocated with a single IPPool update. Allocation is
+// idempotent for the same allocatedTo, the same way it is for AllocateIP.
+func (i *Ipam) AllocateIPs(ctx context.Context, requests []IPRequest) ([]vinov1.AllocatedIP, error) {
+	subnets := []string{}
+	bySubnet := map[string][]int{}
+	for j, request := range requests {
+		if _, exists := bySubnet[request.Subnet]; !exists {
+			subnets = append(subnets, request.Subnet)
 		}
+		bySubnet[request.Subnet] = append(bySubnet[request.Subnet], j)
 	}
-	if !match {
-		return "", "", ErrSubnetRangeNotAllocated{Subnet: subnet, SubnetRange: subnetRange}
+
+	result := make([]vinov1.AllocatedIP, len(requests))
+	for _, subnet := range subnets {
+		subnetRequests := []IPRequest{}
+		for _, j := range bySubnet[subnet] {
+			subnetRequests = append(subnetRequests, requests[j])
+		}
+		var allocated []vinov1.AllocatedIP
+		err := i.retryOnConflict(func() (err error) {
+			allocated, err = i.allocateIPs(ctx, subnet, subnetRequests)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for k, j := range bySubnet[subnet] {
+			result[j] = allocated[k]
+		}
 	}
+	return result, nil
+}
 
-	// If an IP has already been allocated to this entity, return it
-	ip, mac := findAlreadyAllocatedIP(ippool, allocatedTo)
+// allocateIPs allocates IPs for requests from a single subnet, and saves the IPPool once
+func (i *Ipam) allocateIPs(ctx context.Context, subnet string,
+	requests []IPRequest) ([]vinov1.AllocatedIP, error) {
+	ippool, err := i.getIPPool(ctx, subnet)
+	if err != nil {
+		return nil, err
+	}
+	if ippool == nil {
+		return nil, ErrSubnetNotAllocated{Subnet: subnet}
+	}
+	// the index is dropped on any error, and is built again from the pool next time
+	index, err := i.indexes.checkout(ippool)
+	if err != nil {
+		return nil, err
+	}
 
-	// No IP already allocated, so allocate a new IP
-	if ip == "" {
-		// Find an IP
-		ip, err = findFreeIPInRange(ippool, subnetRange)
-		if err != nil {
-			return "", "", err
+	allocated := make([]vinov1.AllocatedIP, 0, len(requests))
+	changed := false
+	for _, request := range requests {
+		// Make sure the range has been allocated within the subnet
+		if !hasRange(ippool.Spec, request.SubnetRange) {
+			return nil, ErrSubnetRangeNotAllocated{Subnet: subnet, SubnetRange: request.SubnetRange}
 		}
 
-		// Find a MAC
-		mac = ippool.NextMAC
-		macInt, err := macStringToInt(ippool.NextMAC)
+		allocatedIP, isNew, err := index.allocate(&ippool.Spec, request.SubnetRange, request.AllocatedTo)
 		if err != nil {
-			return "", "", err
+			return nil, err
 		}
-		ippool.NextMAC = intToMACString(macInt + 1)
-
-		i.Log.Info("Allocating IP", "ip", ip, "mac", mac, "subnet", subnet, "subnetRange", subnetRange)
-		ippool.AllocatedIPs = append(ippool.AllocatedIPs,
-			vinov1.AllocatedIP{IP: ip, MAC: mac, AllocatedTo: allocatedTo})
+		// This is just a sanity check - should never happen
+		if allocatedIP.IP == "" || allocatedIP.MAC == "" {
+			return nil, ErrNotSupported{fmt.Sprintf(
+				"IP: '%s' or MAC: '%s' unable to be generated. This is a bug!", allocatedIP.IP, allocatedIP.MAC,
+			)}
+		}
+		if isNew {
+			i.Log.Info("Allocating IP", "ip", allocatedIP.IP, "mac", allocatedIP.MAC,
+				"subnet", subnet, "subnetRange", request.SubnetRange)
+			changed = true
+		}
+		allocated = append(allocated, allocatedIP)
+	}
 
+	if changed {
+		if ippool.Spec.NextMAC, err = index.findFreeMAC(); err != nil {
+			return nil, err
+		}
 		// Save the updated IPPool
-		err = i.applyIPPool(ctx, *ippool)
-		if err != nil {
-			return "", "", err
+		if err = i.applyIPPool(ctx, ippool); err != nil {
+			return nil, err
 		}
 	}
+	index.resourceVersion = ippool.ResourceVersion
+	i.indexes.checkin(subnet, index)
+	return allocated, nil
+}
 
-	// This is just a sanity check - should never happen
-	if ip == "" || mac == "" {
-		return "", "", ErrNotSupported{fmt.Sprintf(
-			"IP: '%s' or MAC: '%s' unable to be generated. This is a bug!", ip, mac,
-		)}
+// hasRange returns true if the range has been added to the pool
+func hasRange(spec vinov1.IPPoolSpec, subnetRange vinov1.Range) bool {
+	for _, r := range spec.Ranges {
+		if r == subnetRange {
+			return true
+		}
 	}
-	return ip, mac, nil
+	return false
+}
+
+// ReleaseIP releases IPs allocated to the entity specified by `allocatedTo` in all subnets.
+// It's not an error if no IPs are allocated to the entity.
+func (i *Ipam) ReleaseIP(ctx context.Context, allocatedTo string) error {
+	return i.releaseIPs(ctx, func(_, owner string) bool {
+		return owner == allocatedTo
+	})
+}
+
+// ReleaseByPrefix releases IPs in all subnets, that are allocated to entities whose identifier
+// starts with the given prefix, for example all IPs of BMHs belonging to a vino CR
+func (i *Ipam) ReleaseByPrefix(ctx context.Context, prefix string) error {
+	return i.ReleaseByPrefixes(ctx, []string{prefix})
 }
 
-// This returns an IP already allocated to the entity specified by `allocatedTo`
-// if it exists within the requested ippool/subnet, and a blank string
-// if no IP is already allocated.
-func findAlreadyAllocatedIP(ippool *vinov1.IPPoolSpec, allocatedTo string) (ip string, mac string) {
-	for _, allocatedIP := range ippool.AllocatedIPs {
-		if allocatedIP.AllocatedTo == allocatedTo {
-			return allocatedIP.IP, allocatedIP.MAC
+// ReleaseByPrefixes releases IPs allocated to entities whose identifier starts with any of
+// the given prefixes, every IPPool is updated at most once
+func (i *Ipam) ReleaseByPrefixes(ctx context.Context, prefixes []string) error {
+	return i.releaseIPs(ctx, func(_, owner string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(owner, prefix) {
+				return true
+			}
 		}
-	}
-	return "", ""
+		return false
+	})
 }
 
-// This converts IP ranges/addresses into iterable ints,
-// steps through them looking for one that that is not already
-// in use, converts it back to a string and returns it.
-// It does not itself add it to the list of assigned IPs.
-func findFreeIPInRange(ippool *vinov1.IPPoolSpec, subnetRange vinov1.Range) (string, error) {
-	allocatedIPSet, err := sliceToMap(ippool.AllocatedIPs)
-	if err != nil {
-		return "", err
+// ReleaseHost releases IPs and ranges allocated to the host in all subnets, except for the
+// subnets the host still uses, for example for VMs of another vino CR on the same k8s node
+func (i *Ipam) ReleaseHost(ctx context.Context, host string, keepSubnets map[string]struct{}) error {
+	matches := func(subnet, owner string) bool {
+		if _, keep := keepSubnets[subnet]; keep {
+			return false
+		}
+		return owner == host
 	}
-	intToString := intToIPv4String
-	if strings.Contains(ippool.Subnet, ":") {
-		intToString = intToIPv6String
+	if err := i.releaseIPs(ctx, matches); err != nil {
+		return err
 	}
+	return i.releaseRanges(ctx, matches)
+}
 
-	// Step through the range looking for free IPs
-	start, err := ipStringToInt(subnetRange.Start)
-	if err != nil {
-		return "", err
-	}
-	stop, err := ipStringToInt(subnetRange.Stop)
-	if err != nil {
-		return "", err
-	}
+// ReleaseRange frees ranges allocated to the host in all subnets, so they can be
+// allocated to other hosts
+func (i *Ipam) ReleaseRange(ctx context.Context, host string) error {
+	return i.releaseRanges(ctx, func(_, owner string) bool {
+		return owner == host
+	})
+}
 
-	for ip := start; ip <= stop; ip++ {
-		_, in := allocatedIPSet[ip]
-		if !in {
-			// Found an unallocated IP
-			return intToString(ip), nil
+// releaseRanges frees allocated ranges whose host matches the function in all IPPools
+func (i *Ipam) releaseRanges(ctx context.Context, matches func(subnet, host string) bool) error {
+	return i.retryOnConflict(func() error {
+		ippools, err := i.getIPPools(ctx)
+		if err != nil {
+			return err
 		}
-	}
-	return "", ErrSubnetRangeExhausted{ippool.Subnet, subnetRange}
+		for _, ippool := range ippools {
+			changed := false
+			for j, r := range ippool.Spec.AllocatedRanges {
+				if r.AllocatedTo != "" && matches(ippool.Spec.Subnet, r.AllocatedTo) {
+					i.Log.Info("Releasing range", "range", r.Range, "host", r.AllocatedTo, "subnet", ippool.Spec.Subnet)
+					ippool.Spec.AllocatedRanges[j].AllocatedTo = ""
+					changed = true
+				}
+			}
+			if !changed {
+				continue
+			}
+			if err = i.saveOrDeleteIPPool(ctx, ippool); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-// Create a map[uint64]struct{} representation of an AllocatedIP slice,
-// for efficient set lookups
-func sliceToMap(slice []vinov1.AllocatedIP) (map[uint64]struct{}, error) {
-	m := map[uint64]struct{}{}
-	for _, s := range slice {
-		i, err := ipStringToInt(s.IP)
+// releaseIPs removes allocated IPs whose owner matches the function from all IPPools.
+// Only IPPools that have changed are persisted.
+func (i *Ipam) releaseIPs(ctx context.Context, matches func(subnet, allocatedTo string) bool) error {
+	return i.retryOnConflict(func() error {
+		ippools, err := i.getIPPools(ctx)
 		if err != nil {
-			return m, err
+			return err
+		}
+		for _, ippool := range ippools {
+			kept := []vinov1.AllocatedIP{}
+			for _, allocatedIP := range ippool.Spec.AllocatedIPs {
+				if matches(ippool.Spec.Subnet, allocatedIP.AllocatedTo) {
+					i.Log.Info("Releasing IP", "ip", allocatedIP.IP, "mac", allocatedIP.MAC,
+						"allocatedTo", allocatedIP.AllocatedTo, "subnet", ippool.Spec.Subnet)
+					continue
+				}
+				kept = append(kept, allocatedIP)
+			}
+			if len(kept) == len(ippool.Spec.AllocatedIPs) {
+				continue
+			}
+			ippool.Spec.AllocatedIPs = kept
+			if err = i.saveOrDeleteIPPool(ctx, ippool); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// saveOrDeleteIPPool persists the pool after some of its allocations have been released.
+// If nothing is allocated from the pool anymore, the pool is deleted. It will be created
+// again, when a subnet range is added.
+func (i *Ipam) saveOrDeleteIPPool(ctx context.Context, ippool *vinov1.IPPool) error {
+	if !isIPPoolEmpty(ippool.Spec) {
+		return i.applyIPPool(ctx, ippool)
+	}
+
+	i.Log.Info("IPAM deleting empty IPPool", "subnet", ippool.Spec.Subnet)
+	// the precondition makes deletion fail with conflict, if the pool was changed concurrently
+	err := i.Client.Delete(ctx, ippool, client.Preconditions{ResourceVersion: &ippool.ResourceVersion})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// isIPPoolEmpty returns true if the pool has neither allocated IPs nor allocated ranges
+func isIPPoolEmpty(spec vinov1.IPPoolSpec) bool {
+	if len(spec.AllocatedIPs) != 0 {
+		return false
+	}
+	for _, r := range spec.AllocatedRanges {
+		if r.AllocatedTo != "" {
+			return false
 		}
-		m[i] = struct{}{}
 	}
-	return m, nil
+	return true
 }
 
-// Convert an IPV4 or IPV6 address string to an easily iterable uint64.
+// Convert an IPV4 or IPV6 address string to an easily iterable uint128.
 // For IPV4 addresses, this captures the full address (padding the MSB with 0's)
-// For IPV6 addresses, this captures the most significant 8 bytes,
-// and excludes the 8-byte interface identifier.
-func ipStringToInt(ipString string) (uint64, error) {
+// For IPV6 addresses, this captures all 16 bytes of the address.
+func ipStringToInt(ipString string) (uint128, error) {
 	ip := net.ParseIP(ipString)
 	if ip == nil {
-		return 0, ErrInvalidIPAddress{ipString}
+		return uint128{}, ErrInvalidIPAddress{ipString}
 	}
 
 	var bytes []byte
 	if ip.To4() != nil {
 		// IPv4
-		bytes = append(make([]byte, 4), ip.To4()...)
+		bytes = append(make([]byte, 12), ip.To4()...)
 	} else {
 		// IPv6
-		bytes = ip.To16()[:8]
+		bytes = ip.To16()
 	}
 
-	return byteArrayToInt(bytes), nil
+	return uint128FromBytes(bytes), nil
+}
+
+// isIPv6 returns true if the address, range bound or subnet is an IPv6 one
+func isIPv6(address string) bool {
+	return strings.Contains(address, ":")
 }
 
 // Convert a MAC address in xx:xx:xx:xx:xx:xx format to an easily iterable uint64.
@@ -284,17 +435,14 @@ func macStringToInt(macString string) (uint64, error) {
 	return byteArrayToInt(bytes), nil
 }
 
-func intToIPv4String(i uint64) string {
-	bytes := intToByteArray(i)
-	ip := net.IPv4(bytes[4], bytes[5], bytes[6], bytes[7])
+func intToIPv4String(i uint128) string {
+	bytes := i.bytes()
+	ip := net.IPv4(bytes[12], bytes[13], bytes[14], bytes[15])
 	return ip.String()
 }
 
-func intToIPv6String(i uint64) string {
-	// Pad with 8 more bytes of zeros on the right for the hosts's interface,
-	// which will not be determined by IPAM.
-	bytes := append(intToByteArray(i), make([]byte, 8)...)
-	var ip net.IP = bytes
+func intToIPv6String(i uint128) string {
+	var ip net.IP = i.bytes()
 	return ip.String()
 }
 
@@ -334,65 +482,95 @@ func subnetResourceName(subnet string) string {
 	return "ippool-" + regex.ReplaceAllString(subnet, "-")
 }
 
-// Persist a pool to the API server (Create or Update)
-func (i *Ipam) applyIPPool(ctx context.Context, spec vinov1.IPPoolSpec) error {
-	logger := i.Log.WithValues("subnet", spec.Subnet)
+// Persist a pool to the API server. Pool that has never been persisted is created, otherwise
+// it's updated with the resource version it was read at, so that the update fails with
+// a conflict if the pool was changed concurrently.
+func (i *Ipam) applyIPPool(ctx context.Context, ippool *vinov1.IPPool) error {
+	logger := i.Log.WithValues("subnet", ippool.Spec.Subnet)
 
-	ippool := &vinov1.IPPool{
-		ObjectMeta: metav1.ObjectMeta{
-			Namespace: i.Namespace,
-			Name:      subnetResourceName(spec.Subnet),
-		},
-		Spec: spec,
-	}
-	existingPool := &vinov1.IPPool{}
-	err := i.Client.Get(ctx, client.ObjectKeyFromObject(ippool), existingPool)
-	if err != nil {
-		// Is it an unexpected error?
-		if !apierrors.IsNotFound(err) {
-			return err
-		}
-		// The error is a warning that the resource doesn't exist yet, so we should create it
+	ippool.Namespace = i.Namespace
+	ippool.Name = subnetResourceName(ippool.Spec.Subnet)
+	if ippool.ResourceVersion == "" {
 		logger.Info("IPAM creating IPPool")
-		err = i.Client.Create(ctx, ippool)
-	} else {
-		logger.Info("IPAM IPPool already exists; updating it")
-		ippool.ObjectMeta.ResourceVersion = existingPool.ObjectMeta.ResourceVersion
-		err = i.Client.Update(ctx, ippool)
+		return i.Client.Create(ctx, ippool)
+	}
+	logger.Info("IPAM IPPool already exists; updating it")
+	return i.Client.Update(ctx, ippool)
+}
+
+// retryOnConflict runs the function again, if the IPPool it has read was changed
+// concurrently, or if another IPPool for the same subnet was created concurrently.
+// The function must read IPPools again every time it's run.
+func (i *Ipam) retryOnConflict(fn func() error) error {
+	return retry.OnError(conflictBackoff, func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}, fn)
+}
+
+// getIPPool returns IPPool of the subnet, or nil if the subnet has no IPPool yet
+func (i *Ipam) getIPPool(ctx context.Context, subnet string) (*vinov1.IPPool, error) {
+	ippool := &vinov1.IPPool{}
+	key := client.ObjectKey{Namespace: i.Namespace, Name: subnetResourceName(subnet)}
+	err := i.Client.Get(ctx, key, ippool)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return err
+	return ippool, nil
 }
 
-// Return a mapping of all allocated subnets to their IPPoolSpecs.
-func (i *Ipam) getIPPools(ctx context.Context) (map[string]*vinov1.IPPoolSpec, error) {
+// Return a mapping of all allocated subnets to their IPPools.
+func (i *Ipam) getIPPools(ctx context.Context) (map[string]*vinov1.IPPool, error) {
 	list := &vinov1.IPPoolList{}
 	err := i.Client.List(ctx, list, client.InNamespace(i.Namespace))
-	ippools := make(map[string]*vinov1.IPPoolSpec)
+	ippools := make(map[string]*vinov1.IPPool)
 	if err != nil {
-		return map[string]*vinov1.IPPoolSpec{}, err
+		return map[string]*vinov1.IPPool{}, err
 	}
 	for _, ippool := range list.Items {
-		ippools[ippool.Spec.Subnet] = ippool.Spec.DeepCopy()
+		ippools[ippool.Spec.Subnet] = ippool.DeepCopy()
 	}
 	return ippools, nil
 }
 
-func (i *Ipam) AllocateRange(ctx context.Context,
-	bitStep int,
-	host, macPrefix, start, stop, subnet string) (vinov1.Range, error) {
-	ipPool, err := i.getIPPoolWithRanges(ctx, bitStep, macPrefix, start, stop, subnet)
+// newIPPool returns an IPPool for the subnet, that hasn't been persisted yet
+func newIPPool(subnet, macPrefix string) (*vinov1.IPPool, error) {
+	_, err := macStringToInt(macPrefix) // mac format validation
 	if err != nil {
-		return vinov1.Range{}, err
+		return nil, err
 	}
+	return &vinov1.IPPool{
+		Spec: vinov1.IPPoolSpec{
+			Subnet:       subnet,
+			Ranges:       []vinov1.Range{},
+			AllocatedIPs: []vinov1.AllocatedIP{},
+			MACPrefix:    macPrefix,
+			NextMAC:      macPrefix,
+		},
+	}, nil
+}
 
-	result, err := chooseRange(host, ipPool)
+func (i *Ipam) AllocateRange(ctx context.Context,
+	bitStep int,
+	host, macPrefix, start, stop, subnet string) (result vinov1.Range, err error) {
+	err = i.retryOnConflict(func() error {
+		ipPool, err := i.getIPPoolWithRanges(ctx, bitStep, macPrefix, start, stop, subnet)
+		if err != nil {
+			return err
+		}
+
+		result, err = chooseRange(host, &ipPool.Spec)
+		if err != nil {
+			return err
+		}
+		return i.applyIPPool(ctx, ipPool)
+	})
 	if err != nil {
 		return vinov1.Range{}, err
 	}
-	return result, i.applyIPPool(ctx, *ipPool)
+	return result, nil
 }
 
 func chooseRange(host string, ipPool *vinov1.IPPoolSpec) (vinov1.Range, error) {
@@ -414,30 +592,21 @@ func chooseRange(host string, ipPool *vinov1.IPPoolSpec) (vinov1.Range, error) {
 }
 
 func (i *Ipam) getIPPoolWithRanges(ctx context.Context, bitStep int,
-	macPrefix, start, stop, subnet string) (*vinov1.IPPoolSpec, error) {
-	ippools, err := i.getIPPools(ctx)
+	macPrefix, start, stop, subnet string) (*vinov1.IPPool, error) {
+	ippool, err := i.getIPPool(ctx, subnet)
 	if err != nil {
-		return &vinov1.IPPoolSpec{}, err
+		return nil, err
 	}
 	logger := i.Log.WithValues("subnet", subnet)
 
-	ippool, exists := ippools[subnet]
-	if !exists {
+	if ippool == nil {
 		logger.Info("IPAM creating subnet")
-		_, err = macStringToInt(macPrefix) // mac format validation
+		ippool, err = newIPPool(subnet, macPrefix)
 		if err != nil {
-			return &vinov1.IPPoolSpec{}, err
-		}
-		ippool = &vinov1.IPPoolSpec{
-			Subnet:       subnet,
-			Ranges:       []vinov1.Range{},
-			AllocatedIPs: []vinov1.AllocatedIP{},
-			MACPrefix:    macPrefix,
-			NextMAC:      macPrefix,
+			return nil, err
 		}
-		ippools[subnet] = ippool
 	}
-	if len(ippool.AllocatedRanges) != 0 {
+	if len(ippool.Spec.AllocatedRanges) != 0 {
 		return ippool, nil
 	}
 
@@ -445,34 +614,52 @@ func (i *Ipam) getIPPoolWithRanges(ctx context.Context, bitStep int,
 	if err != nil {
 		return nil, err
 	}
-	ippool.AllocatedRanges = ranges
+	ippool.Spec.AllocatedRanges = ranges
 	return ippool, nil
 }
 
+// generateRanges splits [start,stop] range into ranges of 2^bitStep addresses. At most
+// maxAllocatedRanges ranges are generated, which is more than nodes in any cluster, because
+// IPv6 DHCP range could be split into too many ranges to store them in IPPool.
 func generateRanges(start, stop string, bitStep int) ([]vinov1.AllocatedRange, error) {
 	firstNetIPInt, err := ipStringToInt(start)
 	if err != nil {
 		return nil, err
 	}
-
-	// support only IPv4, use 32 netmask
 	subnetEnd, err := ipStringToInt(stop)
 	if err != nil {
 		return nil, err
 	}
-	ranges := []vinov1.AllocatedRange{}
-	shift := uint64(math.Pow(2, float64(bitStep)))
 
-	for start, end := firstNetIPInt, firstNetIPInt+shift; end-1 <= subnetEnd; {
-		fmt.Printf("start is %s, end is %s\n", intToIPv4String(start), intToIPv4String(subnetEnd))
+	intToString := intToIPv4String
+	addressBits := 32
+	if isIPv6(start) {
+		intToString = intToIPv6String
+		addressBits = 128
+	}
+	if bitStep < 0 || bitStep >= addressBits {
+		return nil, ErrNotSupported{Message: fmt.Sprintf(
+			"instance subnet bit step %d must be in [0,%d) range", bitStep, addressBits)}
+	}
+
+	ranges := []vinov1.AllocatedRange{}
+	shift := uint128Pow2(bitStep)
+	for rangeStart := firstNetIPInt; len(ranges) < maxAllocatedRanges; {
+		next, overflow := rangeStart.add(shift)
+		end := next.sub(uint128One)
+		if (overflow && next != (uint128{})) || end.cmp(subnetEnd) > 0 {
+			break
+		}
 		ranges = append(ranges, vinov1.AllocatedRange{
 			Range: vinov1.Range{
-				Start: intToIPv4String(start),
-				Stop:  intToIPv4String(end - 1),
+				Start: intToString(rangeStart),
+				Stop:  intToString(end),
 			},
 		})
-		start += shift
-		end += shift
+		if overflow {
+			break
+		}
+		rangeStart = next
 	}
 	return ranges, nil
 }
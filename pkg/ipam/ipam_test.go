@@ -16,7 +16,9 @@ package ipam
 
 import (
 	"context"
+	"fmt"
 	"math"
+	"sync"
 	"testing"
 	vinov1 "vino/pkg/api/v1"
 	test "vino/pkg/test"
@@ -26,8 +28,10 @@ import (
 	"github.com/stretchr/testify/require"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -86,8 +90,17 @@ func SetUpMockClient(ctx context.Context, ctrl *gomock.Controller) *test.MockCli
 		},
 	}
 
-	m.EXPECT().List(ctx, gomock.Any(), gomock.Any()).SetArg(1, preExistingIpam)
-	m.EXPECT().Get(ctx, gomock.Any(), gomock.Any()).AnyTimes()
+	m.EXPECT().List(ctx, gomock.Any(), gomock.Any()).SetArg(1, preExistingIpam).AnyTimes()
+	m.EXPECT().Get(ctx, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, key client.ObjectKey, obj client.Object) error {
+			for _, ippool := range preExistingIpam.Items {
+				if subnetResourceName(ippool.Spec.Subnet) == key.Name {
+					ippool.DeepCopyInto(obj.(*vinov1.IPPool))
+					return nil
+				}
+			}
+			return apierrors.NewNotFound(schema.GroupResource{Resource: "ippools"}, key.Name)
+		}).AnyTimes()
 	m.EXPECT().Create(ctx, gomock.Any(), gomock.Any()).AnyTimes()
 	m.EXPECT().Update(ctx, gomock.Any(), gomock.Any()).AnyTimes()
 	return m
@@ -313,6 +326,12 @@ func TestFindFreeIPInRange(t *testing.T) {
 			subnetRange: vinov1.Range{Start: "2600:1700:b030:1001::", Stop: "2600:1700:b030:1009::"},
 			out:         "2600:1700:b030:1001::",
 		},
+		{
+			name:        "ip available IPv6 in interface identifier",
+			subnet:      "2600:1700:b032::/64",
+			subnetRange: vinov1.Range{Start: "2600:1700:b032::1", Stop: "2600:1700:b032::9"},
+			out:         "2600:1700:b032::2",
+		},
 		{
 			name:        "ip unavailable IPv6",
 			subnet:      "2600:1700:b031::/64",
@@ -326,20 +345,25 @@ func TestFindFreeIPInRange(t *testing.T) {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			ippool := vinov1.IPPoolSpec{
-				Subnet: tt.subnet,
+				Subnet:    tt.subnet,
+				MACPrefix: "02:00:00:00:00:00",
 				// One available and one unavailable range each for ipv4/6
 				Ranges: []vinov1.Range{
 					{Start: "10.0.1.0", Stop: "10.0.1.10"},
 					{Start: "10.0.2.0", Stop: "10.0.2.0"},
 					{Start: "2600:1700:b030:1001::", Stop: "2600:1700:b030:1009::"},
 					{Start: "2600:1700:b031::", Stop: "2600:1700:b031::"},
+					{Start: "2600:1700:b032::1", Stop: "2600:1700:b032::9"},
 				},
 				AllocatedIPs: []vinov1.AllocatedIP{
 					{IP: "10.0.2.0", AllocatedTo: "old-vm-name"},
 					{IP: "2600:1700:b031::", AllocatedTo: "old-vm-name"},
+					{IP: "2600:1700:b032::1", AllocatedTo: "old-vm-name"},
 				},
 			}
-			actual, err := findFreeIPInRange(&ippool, tt.subnetRange)
+			index, err := newPoolIndex(&vinov1.IPPool{Spec: ippool})
+			require.NoError(t, err)
+			actual, err := index.findFreeIP(tt.subnet, tt.subnetRange)
 			if tt.expectedErr != "" {
 				require.Error(t, err)
 				assert.Contains(t, err.Error(), tt.expectedErr)
@@ -351,71 +375,95 @@ func TestFindFreeIPInRange(t *testing.T) {
 	}
 }
 
-func TestSliceToMap(t *testing.T) {
+func TestNewIntervalSet(t *testing.T) {
 	tests := []struct {
 		name string
-		in   []vinov1.AllocatedIP
-		out  map[uint64]struct{}
+		in   []uint128
+		out  intervalSet
 	}{
 		{
-			name: "empty slice",
-			in:   []vinov1.AllocatedIP{},
-			out:  map[uint64]struct{}{},
+			name: "empty set",
+			in:   []uint128{},
+			out:  intervalSet{},
 		},
 		{
-			name: "one-element slice",
-			in: []vinov1.AllocatedIP{
-				{IP: "0.0.0.1", AllocatedTo: "old-vm-name"},
-			},
-			out: map[uint64]struct{}{1: {}},
+			name: "one address",
+			in:   []uint128{{lo: 1}},
+			out:  intervalSet{{first: uint128{lo: 1}, last: uint128{lo: 1}}},
 		},
 		{
-			name: "two-element slice",
-			in: []vinov1.AllocatedIP{
-				{IP: "0.0.0.1", AllocatedTo: "old-vm-name"},
-				{IP: "0.0.0.2", AllocatedTo: "old-vm-name"},
+			name: "consecutive addresses in any order are merged",
+			in:   []uint128{{lo: 3}, {lo: 1}, {lo: 2}, {lo: 2}},
+			out:  intervalSet{{first: uint128{lo: 1}, last: uint128{lo: 3}}},
+		},
+		{
+			name: "IPv6 addresses that differ only in interface identifier",
+			in:   []uint128{{hi: 0x26001700b0300000, lo: 1}, {hi: 0x26001700b0300000, lo: 3}},
+			out: intervalSet{
+				{first: uint128{hi: 0x26001700b0300000, lo: 1}, last: uint128{hi: 0x26001700b0300000, lo: 1}},
+				{first: uint128{hi: 0x26001700b0300000, lo: 3}, last: uint128{hi: 0x26001700b0300000, lo: 3}},
 			},
-			out: map[uint64]struct{}{1: {}, 2: {}},
 		},
 	}
 
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
-			actual, err := sliceToMap(tt.in)
-			assert.Equal(t, tt.out, actual)
-			require.NoError(t, err)
+			assert.Equal(t, tt.out, newIntervalSet(tt.in))
 		})
 	}
 }
 
+func TestIntervalSetInsert(t *testing.T) {
+	s := intervalSet{}
+	for _, a := range []uint64{5, 1, 3, 2, 4, 10, 4} {
+		s.insert(uint128{lo: a})
+	}
+	assert.Equal(t, intervalSet{
+		{first: uint128{lo: 1}, last: uint128{lo: 5}},
+		{first: uint128{lo: 10}, last: uint128{lo: 10}},
+	}, s)
+
+	free, found := s.firstFree(uint128{lo: 2}, uint128{lo: 10})
+	assert.True(t, found)
+	assert.Equal(t, uint128{lo: 6}, free)
+	_, found = s.firstFree(uint128{lo: 1}, uint128{lo: 5})
+	assert.False(t, found)
+	free, found = s.firstFree(uint128{lo: 7}, uint128{lo: 7})
+	assert.True(t, found)
+	assert.Equal(t, uint128{lo: 7}, free)
+}
+
 func TestIPStringToInt(t *testing.T) {
 	tests := []struct {
 		name        string
 		in          string
-		out         uint64
+		out         uint128
 		expectedErr string
 	}{
 		{
 			name: "valid IPv4 address",
 			in:   "1.0.0.1",
-			out:  uint64(math.Pow(2, 24) + 1),
+			out:  uint128{lo: uint64(math.Pow(2, 24) + 1)},
 		},
 		{
 			name:        "invalid IPv4 address",
 			in:          "1.0.0.1.1",
-			out:         0,
 			expectedErr: " is invalid",
 		},
 		{
 			name: "valid IPv6 address",
 			in:   "0001:0000:0000:0001::",
-			out:  uint64(math.Pow(2, 48) + 1),
+			out:  uint128{hi: uint64(math.Pow(2, 48) + 1)},
+		},
+		{
+			name: "valid IPv6 address with interface identifier",
+			in:   "0001:0000:0000:0001::0001",
+			out:  uint128{hi: uint64(math.Pow(2, 48) + 1), lo: 1},
 		},
 		{
 			name:        "invalid IPv6 address",
 			in:          "1000:0000:0000:foobar::",
-			out:         0,
 			expectedErr: " is invalid",
 		},
 	}
@@ -593,35 +641,31 @@ func TestApplyIPPool(t *testing.T) {
 		},
 		Spec: spec,
 	}
-	emptyPool := &vinov1.IPPool{}
 
 	// Test Create scenario
 	m = test.NewMockClient(ctrl)
 	ipammer.Client = m
-	m.EXPECT().Get(ctx, client.ObjectKeyFromObject(&pool), emptyPool).Return(
-		apierrors.NewNotFound(schema.GroupResource{
-			Group: "airship.airshipit.org", Resource: "ippools"}, "ippool-192-168-0-0-24"))
 	m.EXPECT().Create(ctx, &pool)
-	err := ipammer.applyIPPool(ctx, spec)
+	err := ipammer.applyIPPool(ctx, &vinov1.IPPool{Spec: spec})
 	assert.NoError(t, err)
 
-	// Test Update scenario
+	// Test Update scenario, pool is updated with the resource version it was read at
 	existingPool := pool.DeepCopy()
-	existingPool.Generation = 1
+	existingPool.ResourceVersion = "42"
 	m = test.NewMockClient(ctrl)
 	ipammer.Client = m
-	m.EXPECT().Get(ctx, client.ObjectKeyFromObject(&pool), emptyPool).SetArg(2, *existingPool)
-	m.EXPECT().Update(ctx, &pool)
-	err = ipammer.applyIPPool(ctx, spec)
+	m.EXPECT().Update(ctx, existingPool)
+	err = ipammer.applyIPPool(ctx, existingPool.DeepCopy())
 	assert.NoError(t, err)
 
-	// Test non-already-exists error scenario
+	// Test conflict scenario
 	m = test.NewMockClient(ctrl)
 	ipammer.Client = m
-	m.EXPECT().Get(ctx, client.ObjectKeyFromObject(&pool), emptyPool).Return(
-		apierrors.NewBadRequest("bad things happened"))
-	err = ipammer.applyIPPool(ctx, spec)
-	assert.Error(t, err)
+	m.EXPECT().Update(ctx, existingPool).Return(
+		apierrors.NewConflict(schema.GroupResource{
+			Group: "airship.airshipit.org", Resource: "ippools"}, "ippool-192-168-0-0-24", nil))
+	err = ipammer.applyIPPool(ctx, existingPool.DeepCopy())
+	assert.True(t, apierrors.IsConflict(err))
 }
 
 func TestGetIPPools(t *testing.T) {
@@ -647,7 +691,7 @@ func TestGetIPPools(t *testing.T) {
 		Spec: spec,
 	}
 	fullList := vinov1.IPPoolList{Items: []vinov1.IPPool{pool}}
-	expectedResult := map[string]*vinov1.IPPoolSpec{"192.168.0.0/24": &spec}
+	expectedResult := map[string]*vinov1.IPPool{"192.168.0.0/24": &pool}
 
 	m := test.NewMockClient(ctrl)
 	ipammer := NewIpam(log.Log, m, "vino-system")
@@ -657,3 +701,421 @@ func TestGetIPPools(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, expectedResult, actualResult)
 }
+
+func setUpFakeClient(t *testing.T, pools ...vinov1.IPPoolSpec) client.Client {
+	scheme := runtime.NewScheme()
+	require.NoError(t, vinov1.AddToScheme(scheme))
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for _, spec := range pools {
+		builder.WithObjects(&vinov1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "vino-system",
+				Name:      subnetResourceName(spec.Subnet),
+				// fake client doesn't set resource version for initial objects, API server always does
+				ResourceVersion: "1",
+			},
+			Spec: spec,
+		})
+	}
+	return builder.Build()
+}
+
+func TestReleaseByPrefix(t *testing.T) {
+	ctx := context.Background()
+	c := setUpFakeClient(t, vinov1.IPPoolSpec{
+		Subnet: "10.0.0.0/16",
+		Ranges: []vinov1.Range{{Start: "10.0.1.0", Stop: "10.0.1.9"}},
+		AllocatedIPs: []vinov1.AllocatedIP{
+			{IP: "10.0.1.0", MAC: "02:00:00:00:00:00", AllocatedTo: "node01"},
+			{IP: "10.0.1.1", MAC: "02:00:00:00:00:01", AllocatedTo: "default-vino-node01-worker-0/pxe"},
+			{IP: "10.0.1.2", MAC: "02:00:00:00:00:02", AllocatedTo: "default-vino-node01-worker-1/pxe"},
+			{IP: "10.0.1.3", MAC: "02:00:00:00:00:03", AllocatedTo: "default-vino2-node01-worker-0/pxe"},
+		},
+		MACPrefix: "02:00:00:00:00:00",
+		NextMAC:   "02:00:00:00:00:04",
+	})
+	ipammer := NewIpam(log.Log, c, "vino-system")
+
+	require.NoError(t, ipammer.ReleaseByPrefix(ctx, "default-vino-"))
+	pools, err := ipammer.getIPPools(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []vinov1.AllocatedIP{
+		{IP: "10.0.1.0", MAC: "02:00:00:00:00:00", AllocatedTo: "node01"},
+		{IP: "10.0.1.3", MAC: "02:00:00:00:00:03", AllocatedTo: "default-vino2-node01-worker-0/pxe"},
+	}, pools["10.0.0.0/16"].Spec.AllocatedIPs)
+
+	require.NoError(t, ipammer.ReleaseIP(ctx, "node01"))
+	pools, err = ipammer.getIPPools(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []vinov1.AllocatedIP{
+		{IP: "10.0.1.3", MAC: "02:00:00:00:00:03", AllocatedTo: "default-vino2-node01-worker-0/pxe"},
+	}, pools["10.0.0.0/16"].Spec.AllocatedIPs)
+}
+
+func TestReleaseRange(t *testing.T) {
+	ctx := context.Background()
+	c := setUpFakeClient(t, vinov1.IPPoolSpec{
+		Subnet: "10.0.0.0/16",
+		Ranges: []vinov1.Range{{Start: "10.0.1.0", Stop: "10.0.1.9"}},
+		AllocatedRanges: []vinov1.AllocatedRange{
+			{AllocatedTo: "node01", Range: vinov1.Range{Start: "10.0.2.0", Stop: "10.0.2.15"}},
+			{AllocatedTo: "node02", Range: vinov1.Range{Start: "10.0.2.16", Stop: "10.0.2.31"}},
+		},
+		AllocatedIPs: []vinov1.AllocatedIP{},
+		MACPrefix:    "02:00:00:00:00:00",
+		NextMAC:      "02:00:00:00:00:00",
+	})
+	ipammer := NewIpam(log.Log, c, "vino-system")
+
+	require.NoError(t, ipammer.ReleaseRange(ctx, "node01"))
+	pools, err := ipammer.getIPPools(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []vinov1.AllocatedRange{
+		{AllocatedTo: "", Range: vinov1.Range{Start: "10.0.2.0", Stop: "10.0.2.15"}},
+		{AllocatedTo: "node02", Range: vinov1.Range{Start: "10.0.2.16", Stop: "10.0.2.31"}},
+	}, pools["10.0.0.0/16"].Spec.AllocatedRanges)
+
+	// released range is given to the next host that asks for one
+	r, err := chooseRange("node03", &pools["10.0.0.0/16"].Spec)
+	require.NoError(t, err)
+	assert.Equal(t, vinov1.Range{Start: "10.0.2.0", Stop: "10.0.2.15"}, r)
+}
+
+func TestReleaseHost(t *testing.T) {
+	ctx := context.Background()
+	spec := func(subnet, prefix string) vinov1.IPPoolSpec {
+		return vinov1.IPPoolSpec{
+			Subnet: subnet,
+			Ranges: []vinov1.Range{{Start: prefix + ".1.0", Stop: prefix + ".1.9"}},
+			AllocatedRanges: []vinov1.AllocatedRange{
+				{AllocatedTo: "node01", Range: vinov1.Range{Start: prefix + ".2.0", Stop: prefix + ".2.15"}},
+				{AllocatedTo: "node02", Range: vinov1.Range{Start: prefix + ".2.16", Stop: prefix + ".2.31"}},
+			},
+			AllocatedIPs: []vinov1.AllocatedIP{
+				{IP: prefix + ".1.0", MAC: "02:00:00:00:00:00", AllocatedTo: "node01"},
+				{IP: prefix + ".1.1", MAC: "02:00:00:00:00:01", AllocatedTo: "node02"},
+			},
+			MACPrefix: "02:00:00:00:00:00",
+			NextMAC:   "02:00:00:00:00:02",
+		}
+	}
+	c := setUpFakeClient(t, spec("10.0.0.0/16", "10.0"), spec("10.1.0.0/16", "10.1"))
+	ipammer := NewIpam(log.Log, c, "vino-system")
+
+	// node01 still uses 10.1.0.0/16 for VMs of another vino CR
+	require.NoError(t, ipammer.ReleaseHost(ctx, "node01", map[string]struct{}{"10.1.0.0/16": {}}))
+	pools, err := ipammer.getIPPools(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []vinov1.AllocatedIP{
+		{IP: "10.0.1.1", MAC: "02:00:00:00:00:01", AllocatedTo: "node02"},
+	}, pools["10.0.0.0/16"].Spec.AllocatedIPs)
+	assert.Equal(t, []vinov1.AllocatedRange{
+		{AllocatedTo: "", Range: vinov1.Range{Start: "10.0.2.0", Stop: "10.0.2.15"}},
+		{AllocatedTo: "node02", Range: vinov1.Range{Start: "10.0.2.16", Stop: "10.0.2.31"}},
+	}, pools["10.0.0.0/16"].Spec.AllocatedRanges)
+	assert.Equal(t, spec("10.1.0.0/16", "10.1"), pools["10.1.0.0/16"].Spec)
+}
+
+func TestReleaseDeletesEmptyPool(t *testing.T) {
+	ctx := context.Background()
+	c := setUpFakeClient(t, vinov1.IPPoolSpec{
+		Subnet: "10.0.0.0/16",
+		Ranges: []vinov1.Range{{Start: "10.0.1.0", Stop: "10.0.1.9"}},
+		AllocatedRanges: []vinov1.AllocatedRange{
+			{AllocatedTo: "node01", Range: vinov1.Range{Start: "10.0.2.0", Stop: "10.0.2.15"}},
+		},
+		AllocatedIPs: []vinov1.AllocatedIP{
+			{IP: "10.0.1.0", MAC: "02:00:00:00:00:00", AllocatedTo: "node01"},
+		},
+		MACPrefix: "02:00:00:00:00:00",
+		NextMAC:   "02:00:00:00:00:01",
+	})
+	ipammer := NewIpam(log.Log, c, "vino-system")
+
+	// pool still has an allocated range
+	require.NoError(t, ipammer.ReleaseIP(ctx, "node01"))
+	pools, err := ipammer.getIPPools(ctx)
+	require.NoError(t, err)
+	assert.Len(t, pools, 1)
+
+	require.NoError(t, ipammer.ReleaseRange(ctx, "node01"))
+	pools, err = ipammer.getIPPools(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, pools)
+}
+
+func TestFindFreeMAC(t *testing.T) {
+	tests := []struct {
+		name, expectedMAC string
+		allocatedMACs     []string
+	}{
+		{
+			name:        "empty pool",
+			expectedMAC: "02:00:00:00:00:00",
+		},
+		{
+			name:          "next sequential MAC",
+			allocatedMACs: []string{"02:00:00:00:00:00", "02:00:00:00:00:01"},
+			expectedMAC:   "02:00:00:00:00:02",
+		},
+		{
+			name:          "released MAC is reused",
+			allocatedMACs: []string{"02:00:00:00:00:00", "02:00:00:00:00:02"},
+			expectedMAC:   "02:00:00:00:00:01",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ippool := &vinov1.IPPool{Spec: vinov1.IPPoolSpec{MACPrefix: "02:00:00:00:00:00"}}
+			for j, mac := range tt.allocatedMACs {
+				ippool.Spec.AllocatedIPs = append(ippool.Spec.AllocatedIPs,
+					vinov1.AllocatedIP{IP: fmt.Sprintf("10.0.0.%d", j), MAC: mac})
+			}
+			index, err := newPoolIndex(ippool)
+			require.NoError(t, err)
+			mac, err := index.findFreeMAC()
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedMAC, mac)
+		})
+	}
+}
+
+func TestConcurrentAllocateIP(t *testing.T) {
+	const (
+		workers        = 10
+		ipsPerWorker   = 5
+		subnet         = "10.0.0.0/16"
+		macPrefix      = "02:00:00:00:00:00"
+		ipamNamespace  = "vino-system"
+		allocatedToFmt = "vm-%d-%d"
+	)
+	ctx := context.Background()
+	subnetRange := vinov1.Range{Start: "10.0.1.0", Stop: "10.0.1.255"}
+	c := setUpFakeClient(t)
+	require.NoError(t, NewIpam(log.Log, c, ipamNamespace).AddSubnetRange(ctx, subnet, subnetRange, macPrefix))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*ipsPerWorker)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// every worker has its own Ipam, like controllers running in different processes
+			ipammer := NewIpam(log.Log, c, ipamNamespace)
+			for n := 0; n < ipsPerWorker; n++ {
+				_, _, err := ipammer.AllocateIP(ctx, subnet, subnetRange, fmt.Sprintf(allocatedToFmt, w, n))
+				errs <- err
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	pools, err := NewIpam(log.Log, c, ipamNamespace).getIPPools(ctx)
+	require.NoError(t, err)
+	allocatedIPs := pools[subnet].Spec.AllocatedIPs
+	assert.Len(t, allocatedIPs, workers*ipsPerWorker)
+
+	ips := map[string]string{}
+	macs := map[string]string{}
+	for _, allocatedIP := range allocatedIPs {
+		owner, duplicate := ips[allocatedIP.IP]
+		assert.False(t, duplicate, "IP %s is allocated to %s and %s", allocatedIP.IP, owner, allocatedIP.AllocatedTo)
+		owner, duplicate = macs[allocatedIP.MAC]
+		assert.False(t, duplicate, "MAC %s is allocated to %s and %s", allocatedIP.MAC, owner, allocatedIP.AllocatedTo)
+		ips[allocatedIP.IP] = allocatedIP.AllocatedTo
+		macs[allocatedIP.MAC] = allocatedIP.AllocatedTo
+	}
+}
+
+func TestGenerateRanges(t *testing.T) {
+	tests := []struct {
+		name, start, stop, expectedErr string
+		bitStep                        int
+		expectedLen                    int
+		expectedFirst, expectedLast    vinov1.Range
+	}{
+		{
+			name:          "IPv4",
+			start:         "192.168.4.0",
+			stop:          "192.168.4.63",
+			bitStep:       4,
+			expectedLen:   4,
+			expectedFirst: vinov1.Range{Start: "192.168.4.0", Stop: "192.168.4.15"},
+			expectedLast:  vinov1.Range{Start: "192.168.4.48", Stop: "192.168.4.63"},
+		},
+		{
+			name:        "IPv4 range doesn't fit",
+			start:       "192.168.4.0",
+			stop:        "192.168.4.14",
+			bitStep:     4,
+			expectedLen: 0,
+		},
+		{
+			name:          "IPv6 per host /64",
+			start:         "2600:1700:b030::",
+			stop:          "2600:1700:b030:3:ffff:ffff:ffff:ffff",
+			bitStep:       64,
+			expectedLen:   4,
+			expectedFirst: vinov1.Range{Start: "2600:1700:b030::", Stop: "2600:1700:b030:0:ffff:ffff:ffff:ffff"},
+			expectedLast:  vinov1.Range{Start: "2600:1700:b030:3::", Stop: "2600:1700:b030:3:ffff:ffff:ffff:ffff"},
+		},
+		{
+			name:        "IPv6 range at the end of address space",
+			start:       "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ff00",
+			stop:        "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff",
+			bitStep:     7,
+			expectedLen: 2,
+			expectedFirst: vinov1.Range{
+				Start: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ff00",
+				Stop:  "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ff7f",
+			},
+			expectedLast: vinov1.Range{
+				Start: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ff80",
+				Stop:  "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff",
+			},
+		},
+		{
+			name:        "IPv6 number of ranges is limited",
+			start:       "2600:1700:b030::",
+			stop:        "2600:1700:b030:ffff:ffff:ffff:ffff:ffff",
+			bitStep:     64,
+			expectedLen: maxAllocatedRanges,
+		},
+		{
+			name:        "IPv4 bit step is too large",
+			start:       "192.168.4.0",
+			stop:        "192.168.4.63",
+			bitStep:     32,
+			expectedErr: "must be in [0,32) range",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ranges, err := generateRanges(tt.start, tt.stop, tt.bitStep)
+			if tt.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, ranges, tt.expectedLen)
+			if tt.expectedFirst != (vinov1.Range{}) {
+				assert.Equal(t, tt.expectedFirst, ranges[0].Range)
+				assert.Equal(t, tt.expectedLast, ranges[len(ranges)-1].Range)
+			}
+		})
+	}
+}
+
+// countingClient counts IPPool updates
+type countingClient struct {
+	client.Client
+	updates int
+}
+
+func (c *countingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	c.updates++
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func TestAllocateIPs(t *testing.T) {
+	ctx := context.Background()
+	v4Range := vinov1.Range{Start: "10.0.1.0", Stop: "10.0.1.255"}
+	v6Range := vinov1.Range{Start: "fd00::10", Stop: "fd00::ff"}
+	c := &countingClient{Client: setUpFakeClient(t,
+		vinov1.IPPoolSpec{
+			Subnet:       "10.0.0.0/16",
+			Ranges:       []vinov1.Range{v4Range},
+			AllocatedIPs: []vinov1.AllocatedIP{{IP: "10.0.1.0", MAC: "02:00:00:00:00:00", AllocatedTo: "node01"}},
+			MACPrefix:    "02:00:00:00:00:00",
+		},
+		vinov1.IPPoolSpec{Subnet: "fd00::/64", Ranges: []vinov1.Range{v6Range}, MACPrefix: "02:00:00:00:00:00"},
+	)}
+	ipammer := NewIpam(log.Log, c, "vino-system")
+
+	requests := []IPRequest{
+		{Subnet: "10.0.0.0/16", SubnetRange: v4Range, AllocatedTo: "vm-0/mgmt"},
+		{Subnet: "fd00::/64", SubnetRange: v6Range, AllocatedTo: "vm-0/mgmt"},
+		{Subnet: "10.0.0.0/16", SubnetRange: v4Range, AllocatedTo: "vm-1/mgmt"},
+		{Subnet: "10.0.0.0/16", SubnetRange: v4Range, AllocatedTo: "node01"},
+	}
+	allocated, err := ipammer.AllocateIPs(ctx, requests)
+	require.NoError(t, err)
+	assert.Equal(t, []vinov1.AllocatedIP{
+		{IP: "10.0.1.1", MAC: "02:00:00:00:00:01", AllocatedTo: "vm-0/mgmt"},
+		{IP: "fd00::10", MAC: "02:00:00:00:00:00", AllocatedTo: "vm-0/mgmt"},
+		{IP: "10.0.1.2", MAC: "02:00:00:00:00:02", AllocatedTo: "vm-1/mgmt"},
+		{IP: "10.0.1.0", MAC: "02:00:00:00:00:00", AllocatedTo: "node01"},
+	}, allocated)
+	// a single update per subnet
+	assert.Equal(t, 2, c.updates)
+
+	// allocation is idempotent and doesn't update pools
+	again, err := ipammer.AllocateIPs(ctx, requests)
+	require.NoError(t, err)
+	assert.Equal(t, allocated, again)
+	assert.Equal(t, 2, c.updates)
+
+	pools, err := ipammer.getIPPools(ctx)
+	require.NoError(t, err)
+	assert.Len(t, pools["10.0.0.0/16"].Spec.AllocatedIPs, 3)
+	assert.Equal(t, "02:00:00:00:00:03", pools["10.0.0.0/16"].Spec.NextMAC)
+
+	_, err = ipammer.AllocateIPs(ctx, []IPRequest{
+		{Subnet: "10.0.0.0/16", SubnetRange: v4Range, AllocatedTo: "vm-2/mgmt"},
+		{Subnet: "10.0.0.0/16", SubnetRange: vinov1.Range{Start: "10.0.2.0", Stop: "10.0.2.9"}, AllocatedTo: "vm-3/mgmt"},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not allocated")
+	// failed batch allocates nothing, neither in the pool nor in the index
+	allocated, err = ipammer.AllocateIPs(ctx, []IPRequest{
+		{Subnet: "10.0.0.0/16", SubnetRange: v4Range, AllocatedTo: "vm-4/mgmt"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.1.3", allocated[0].IP)
+}
+
+func TestIPPoolEventHandler(t *testing.T) {
+	ipammer := NewIpam(log.Log, nil, "vino-system")
+	handler := ipammer.IPPoolEventHandler()
+	ippool := &vinov1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "vino-system", ResourceVersion: "1"},
+		Spec: vinov1.IPPoolSpec{
+			Subnet:       "10.0.0.0/16",
+			MACPrefix:    "02:00:00:00:00:00",
+			AllocatedIPs: []vinov1.AllocatedIP{{IP: "10.0.1.0", MAC: "02:00:00:00:00:00", AllocatedTo: "node01"}},
+		},
+	}
+
+	handler.OnAdd(ippool)
+	index, err := ipammer.indexes.checkout(ippool)
+	require.NoError(t, err)
+	assert.Contains(t, index.owners, "node01")
+	ipammer.indexes.checkin(ippool.Spec.Subnet, index)
+
+	// pool changed by another IPAM client
+	updated := ippool.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Spec.AllocatedIPs = append(updated.Spec.AllocatedIPs,
+		vinov1.AllocatedIP{IP: "10.0.1.1", MAC: "02:00:00:00:00:01", AllocatedTo: "node02"})
+	handler.OnUpdate(ippool, updated)
+	assert.Contains(t, ipammer.indexes.pools[ippool.Spec.Subnet].owners, "node02")
+
+	// pools from other namespaces are ignored
+	other := updated.DeepCopy()
+	other.Namespace = "default"
+	other.ResourceVersion = "3"
+	handler.OnAdd(other)
+	assert.Equal(t, "2", ipammer.indexes.pools[ippool.Spec.Subnet].resourceVersion)
+
+	handler.OnDelete(updated)
+	assert.NotContains(t, ipammer.indexes.pools, ippool.Spec.Subnet)
+}
@@ -0,0 +1,81 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package ipam
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// uint128 is an unsigned 128 bit integer, that is used for IP address arithmetic.
+// IPv6 addresses use all 128 bits, IPv4 addresses occupy the lowest 32 bits.
+type uint128 struct {
+	hi, lo uint64
+}
+
+var uint128One = uint128{lo: 1}
+
+// uint128FromBytes converts 16 bytes, with most significant byte first, into uint128
+func uint128FromBytes(b []byte) uint128 {
+	return uint128{
+		hi: binary.BigEndian.Uint64(b[:8]),
+		lo: binary.BigEndian.Uint64(b[8:16]),
+	}
+}
+
+// bytes converts uint128 into 16 bytes, with most significant byte first
+func (u uint128) bytes() []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b[:8], u.hi)
+	binary.BigEndian.PutUint64(b[8:], u.lo)
+	return b
+}
+
+// cmp returns -1, 0 or 1 if u is less than, equal to or greater than v
+func (u uint128) cmp(v uint128) int {
+	switch {
+	case u.hi < v.hi:
+		return -1
+	case u.hi > v.hi:
+		return 1
+	case u.lo < v.lo:
+		return -1
+	case u.lo > v.lo:
+		return 1
+	}
+	return 0
+}
+
+// add returns u+v and true if the sum has overflowed 128 bits
+func (u uint128) add(v uint128) (uint128, bool) {
+	lo, carry := bits.Add64(u.lo, v.lo, 0)
+	hi, carry := bits.Add64(u.hi, v.hi, carry)
+	return uint128{hi: hi, lo: lo}, carry != 0
+}
+
+// sub returns u-v, wrapping around if v is greater than u
+func (u uint128) sub(v uint128) uint128 {
+	lo, borrow := bits.Sub64(u.lo, v.lo, 0)
+	hi, _ := bits.Sub64(u.hi, v.hi, borrow)
+	return uint128{hi: hi, lo: lo}
+}
+
+// uint128Pow2 returns 2^n, n must be less than 128
+func uint128Pow2(n int) uint128 {
+	if n < 64 {
+		return uint128{lo: 1 << uint(n)}
+	}
+	return uint128{hi: 1 << uint(n-64)}
+}
@@ -0,0 +1,372 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirt
+
+import (
+	"crypto/md5" //nolint:gosec
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+const (
+	// DefaultStoragePool is the storage pool domain volumes are created in
+	DefaultStoragePool = "vino-default"
+	// DefaultStoragePoolPath is the directory of the default storage pool
+	DefaultStoragePoolPath = "/var/lib/libvirt/vino-pool"
+	// DefaultVNCPasswordDir is the directory VNC password secret is mounted to in vino-builder
+	DefaultVNCPasswordDir = "/etc/vino-vnc"
+	// DefaultMachine is the machine type of domains, if the flavor doesn't define it
+	DefaultMachine = "pc"
+	// ManagementNetworkTemplate is the only libvirt network template vino-builder supports
+	ManagementNetworkTemplate = "management"
+
+	defaultBridgeName   = "vm-infra-bridge"
+	defaultPXEBootPort  = 80
+	cpuShares           = 8192
+	hugePageSizeGiB     = 1
+	networkTypeBridge   = "bridge"
+	networkTypeNetwork  = "network"
+	creationTimeLayout  = "2006-01-02"
+	consoleLogPathFmt   = "/var/lib/libvirt/%s-console.log"
+	bootPFileURLFmt     = "http://%s/dualboot.ipxe"
+	domainRootDiskDev   = "vde"
+	domainEmulatorPath  = "/usr/bin/qemu-system-x86_64"
+	domainSecurityLabel = "+42424:+104"
+)
+
+// Flavor is a VM flavor from vino flavors config map
+type Flavor struct {
+	VCPUs int64 `json:"vcpus"`
+	// Memory is in GiB
+	Memory int64 `json:"memory"`
+	// RootSize is size of the root volume in GB
+	RootSize  int64 `json:"rootSize"`
+	HugePages bool  `json:"hugepages,omitempty"`
+	// Machine is QEMU machine type, DefaultMachine is used if it is empty
+	Machine string `json:"machine,omitempty"`
+}
+
+// FlavorFromSpec returns flavor of VinoFlavor
+func FlavorFromSpec(spec vinov1.VinoFlavorSpec) Flavor {
+	return Flavor{VCPUs: spec.VCPUs, Memory: spec.Memory, RootSize: spec.RootSize, HugePages: spec.HugePages}
+}
+
+// Options are node specific parameters of rendering
+type Options struct {
+	// StoragePool of domain volumes, DefaultStoragePool is used if it is empty
+	StoragePool string
+	// StoragePoolPath is the directory of the storage pool, DefaultStoragePoolPath is used if it is empty
+	StoragePoolPath string
+	// VNCPasswordDir contains VNC passwords of domains, named by their keys. DefaultVNCPasswordDir
+	// is used if it is empty
+	VNCPasswordDir string
+	// PXEBootImageHost is used if the builder doesn't define it, it is usually the node address
+	PXEBootImageHost string
+	// CreationTime is recorded in domain metadata
+	CreationTime time.Time
+}
+
+func (o Options) storagePool() string {
+	if o.StoragePool == "" {
+		return DefaultStoragePool
+	}
+	return o.StoragePool
+}
+
+// Rendered is libvirt XML of a builder, keyed by names of libvirt objects
+type Rendered struct {
+	StoragePools map[string][]byte
+	Networks     map[string][]byte
+	// Volumes are root volumes of domains, they are named after the domains
+	Volumes map[string][]byte
+	Domains map[string][]byte
+}
+
+// Render renders XML of the storage pool, networks, domains and their volumes of the builder.
+// Domains are sized by their VinoFlavor, or by the flavor of their role. Cores are host cores
+// allocated to domains, domains without cores aren't pinned.
+func Render(builder vinov1.Builder,
+	flavors map[string]Flavor,
+	cores map[string][]int,
+	opts Options) (*Rendered, error) {
+	rendered := &Rendered{
+		StoragePools: map[string][]byte{},
+		Networks:     map[string][]byte{},
+		Volumes:      map[string][]byte{},
+		Domains:      map[string][]byte{},
+	}
+	pool, err := marshal(StoragePoolXML(opts))
+	if err != nil {
+		return nil, err
+	}
+	rendered.StoragePools[opts.storagePool()] = pool
+
+	for _, network := range builder.Networks {
+		spec, err := NetworkXML(builder, network, opts)
+		if err != nil {
+			return nil, err
+		}
+		if rendered.Networks[network.Name], err = marshal(spec); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, domain := range builder.Domains {
+		flavor, exists := flavors[domain.Role]
+		if domain.Flavor != nil {
+			flavor, exists = FlavorFromSpec(*domain.Flavor), true
+		}
+		if !exists {
+			return nil, fmt.Errorf("domain %s: flavor %s is not defined", domain.Name, domain.Role)
+		}
+		if rendered.Volumes[domain.Name], err = marshal(VolumeXML(domain, flavor)); err != nil {
+			return nil, err
+		}
+		domainCores := cores[domain.Name]
+		if domain.Flavor != nil && domain.Flavor.CPUPinning == vinov1.CPUPinningNone {
+			domainCores = nil
+		}
+		spec, err := DomainXML(domain, flavor, domainCores, opts)
+		if err != nil {
+			return nil, err
+		}
+		if rendered.Domains[domain.Name], err = marshal(spec); err != nil {
+			return nil, err
+		}
+	}
+	return rendered, nil
+}
+
+// StoragePoolXML returns the directory storage pool of domain volumes
+func StoragePoolXML(opts Options) StoragePool {
+	path := opts.StoragePoolPath
+	if path == "" {
+		path = DefaultStoragePoolPath
+	}
+	return StoragePool{
+		Type: "dir",
+		Name: opts.storagePool(),
+		Target: PoolTarget{
+			Path:        path,
+			Permissions: PoolPermissions{Mode: "0711"},
+		},
+	}
+}
+
+// NetworkXML returns routed libvirt network with DHCP server, that serves PXE boot file of
+// the builder
+func NetworkXML(builder vinov1.Builder, network vinov1.BuilderNetwork, opts Options) (Network, error) {
+	if network.LibvirtTemplate != "" && network.LibvirtTemplate != ManagementNetworkTemplate {
+		return Network{}, fmt.Errorf("network %s: unsupported libvirt template %s",
+			network.Name, network.LibvirtTemplate)
+	}
+	_, subnet, err := net.ParseCIDR(network.SubNet)
+	if err != nil {
+		return Network{}, fmt.Errorf("network %s: %w", network.Name, err)
+	}
+	bridgeName := network.BridgeName
+	if bridgeName == "" {
+		bridgeName = defaultBridgeName
+	}
+	pxeHost := builder.PXEBootImageHost
+	if pxeHost == "" {
+		pxeHost = opts.PXEBootImageHost
+	}
+	pxePort := builder.PXEBootImageHostPort
+	if pxePort == 0 {
+		pxePort = defaultPXEBootPort
+	}
+
+	spec := Network{
+		Name:    network.Name,
+		Forward: Forward{Mode: "route"},
+		Bridge:  Bridge{Name: bridgeName, STP: "off"},
+		IPs: []NetworkIP{{
+			Address: network.BridgeIP,
+			Netmask: net.IP(subnet.Mask).String(),
+			DHCP: &DHCP{
+				Range: DHCPRange{Start: network.Range.Start, End: network.Range.Stop},
+				BootP: BootP{File: fmt.Sprintf(bootPFileURLFmt, net.JoinHostPort(pxeHost, strconv.Itoa(pxePort)))},
+			},
+		}},
+	}
+	if network.IPv6 != nil {
+		_, subnetIPv6, err := net.ParseCIDR(network.IPv6.SubNet)
+		if err != nil {
+			return Network{}, fmt.Errorf("network %s: %w", network.Name, err)
+		}
+		prefix, _ := subnetIPv6.Mask.Size()
+		spec.IPs = append(spec.IPs, NetworkIP{Family: "ipv6", Address: network.BridgeIPv6, Prefix: prefix})
+	}
+	return spec, nil
+}
+
+// VolumeXML returns root volume of the domain
+func VolumeXML(domain vinov1.BuilderDomain, flavor Flavor) Volume {
+	return Volume{
+		Name:     domain.Name,
+		Capacity: Memory{Unit: "G", Value: flavor.RootSize},
+		Target:   VolumeTarget{Format: Format{Type: "qcow2"}},
+	}
+}
+
+// DomainXML returns KVM domain of the flavor, virtual CPUs of the domain are pinned to the cores
+func DomainXML(domain vinov1.BuilderDomain, flavor Flavor, cores []int, opts Options) (Domain, error) {
+	machine := flavor.Machine
+	if machine == "" {
+		machine = DefaultMachine
+	}
+	consoleLog := fmt.Sprintf(consoleLogPathFmt, domain.Name)
+	spec := Domain{
+		Type: "kvm",
+		Name: domain.Name,
+		UUID: domainUUID(domain.Name),
+		Metadata: DomainMetadata{
+			Flavor:       domain.Role,
+			Owner:        domain.Owner,
+			CreationTime: opts.CreationTime.Format(creationTimeLayout),
+		},
+		Memory:   Memory{Unit: "GiB", Value: flavor.Memory},
+		VCPU:     VCPU{Placement: "static", Value: flavor.VCPUs},
+		Resource: Resource{Partition: "/machine"},
+		OS: OS{
+			Type: OSType{Arch: "x86_64", Machine: machine, Value: "hvm"},
+			Boot: Boot{Dev: "hd"},
+		},
+		Features: Features{ACPI: &struct{}{}, APIC: &struct{}{}},
+		CPU:      CPU{Mode: "host-passthrough"},
+		Clock: Clock{Offset: "utc", Timers: []Timer{
+			{Name: "pit", TickPolicy: "delay"},
+			{Name: "rtc", TickPolicy: "catchup"},
+			{Name: "hpet", Present: "no"},
+		}},
+		OnPoweroff: "destroy",
+		OnReboot:   "restart",
+		OnCrash:    "destroy",
+		Devices: Devices{
+			Emulator: domainEmulatorPath,
+			Disks: []Disk{{
+				Type:   "volume",
+				Device: "disk",
+				Driver: DiskDriver{Name: "qemu", Type: "qcow2", Cache: "none", Discard: "unmap"},
+				Source: DiskSource{Pool: opts.storagePool(), Volume: domain.Name},
+				Target: DiskTarget{Dev: domainRootDiskDev, Bus: "virtio"},
+			}},
+			Controllers: []Controller{
+				{Type: "usb", Model: "piix3-uhci", Alias: Alias{Name: "usb"}},
+				{Type: "pci", Model: "pci-root", Alias: Alias{Name: "pci.0"}},
+				{Type: "ide", Alias: Alias{Name: "ide"}},
+			},
+			Serials: []Serial{
+				{Type: "file", Source: &PathSource{Path: consoleLog}},
+				{Type: "pty"},
+			},
+			Console: Console{
+				Type:   "file",
+				Source: PathSource{Path: consoleLog},
+				Target: ConsoleTarget{Type: "serial"},
+			},
+			MemBalloon: MemBalloon{Model: "virtio", Stats: Stats{Period: 10}, Alias: Alias{Name: "balloon0"}},
+		},
+		SecLabel: SecLabel{
+			Type:       "dynamic",
+			Model:      "dac",
+			Relabel:    "yes",
+			Label:      domainSecurityLabel,
+			ImageLabel: domainSecurityLabel,
+		},
+	}
+	if flavor.HugePages {
+		spec.MemoryBacking = &MemoryBacking{
+			HugePages: HugePages{Pages: []Page{{Size: hugePageSizeGiB, Unit: "GiB"}}},
+		}
+	}
+	if len(cores) > 0 {
+		spec.CPUTune = cpuTune(cores)
+	}
+
+	for _, iface := range domain.Interfaces {
+		domainIface := Interface{
+			Type:  iface.Type,
+			MAC:   MAC{Address: iface.MACAddress},
+			Model: Model{Type: "virtio"},
+		}
+		switch iface.Type {
+		case networkTypeNetwork:
+			domainIface.Source.Network = iface.NetworkName
+		case networkTypeBridge:
+			domainIface.Source.Bridge = iface.NetworkName
+		default:
+			return Domain{}, fmt.Errorf("domain %s: interface %s has unsupported type %s",
+				domain.Name, iface.Name, iface.Type)
+		}
+		spec.Devices.Interfaces = append(spec.Devices.Interfaces, domainIface)
+	}
+
+	if domain.EnableVNC {
+		passwordDir := opts.VNCPasswordDir
+		if passwordDir == "" {
+			passwordDir = DefaultVNCPasswordDir
+		}
+		password, err := ioutil.ReadFile(filepath.Join(passwordDir, domain.VNCPasswordKey))
+		if err != nil {
+			return Domain{}, fmt.Errorf("domain %s: failed to read VNC password: %w", domain.Name, err)
+		}
+		spec.Devices.Graphics = &Graphics{
+			Type:     "vnc",
+			AutoPort: "no",
+			Port:     domain.VNCPort,
+			Passwd:   strings.TrimSpace(string(password)),
+			Listen:   "0.0.0.0",
+			Listens:  []GraphicsListen{{Type: "address", Address: "0.0.0.0"}},
+		}
+	}
+	return spec, nil
+}
+
+// cpuTune pins every virtual CPU to its own core, and emulator threads to all the cores
+func cpuTune(cores []int) *CPUTune {
+	tune := &CPUTune{Shares: cpuShares}
+	cpuSet := make([]string, 0, len(cores))
+	for i, core := range cores {
+		tune.VCPUPins = append(tune.VCPUPins, VCPUPin{VCPU: i, CPUSet: strconv.Itoa(core)})
+		cpuSet = append(cpuSet, strconv.Itoa(core))
+	}
+	tune.EmulatorPin.CPUSet = strings.Join(cpuSet, ",")
+	return tune
+}
+
+// domainUUID is derived from the domain name, so that redefined domain keeps its UUID
+func domainUUID(name string) string {
+	sum := md5.Sum([]byte(name)) //nolint:gosec
+	return hex.EncodeToString(sum[:])
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	out, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
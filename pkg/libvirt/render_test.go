@@ -0,0 +1,171 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirt
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+// update rewrites golden files with rendered XML: go test ./pkg/libvirt -update
+var update = flag.Bool("update", false, "update golden files")
+
+func testBuilder() vinov1.Builder {
+	iface := func(name, ifaceType, network, mac string) vinov1.BuilderNetworkInterface {
+		return vinov1.BuilderNetworkInterface{
+			MACAddress:       mac,
+			NetworkInterface: vinov1.NetworkInterface{Name: name, Type: ifaceType, NetworkName: network},
+		}
+	}
+	return vinov1.Builder{
+		PXEBootImageHostPort: 8099,
+		Networks: []vinov1.BuilderNetwork{{
+			BridgeIP:   "172.3.3.1",
+			BridgeIPv6: "fd00:3::1",
+			Range:      vinov1.Range{Start: "172.3.3.10", Stop: "172.3.3.20"},
+			Network: vinov1.Network{
+				Name:            "pxe",
+				SubNet:          "172.3.3.0/24",
+				LibvirtTemplate: ManagementNetworkTemplate,
+				BridgeName:      "pxe-bridge",
+				IPv6:            &vinov1.IPv6Network{SubNet: "fd00:3::/64"},
+			},
+		}},
+		Domains: []vinov1.BuilderDomain{
+			{
+				Name:           "default-vino-node01-master-0",
+				Role:           "master",
+				Owner:          "default/vino",
+				EnableVNC:      true,
+				VNCPort:        5900,
+				VNCPasswordKey: "default-vino-node01-master-0",
+				Interfaces: []vinov1.BuilderNetworkInterface{
+					iface("eth0", "network", "pxe", "52:54:00:00:00:01"),
+					iface("eth1", "bridge", "vm-infra-bridge", "52:54:00:00:00:02"),
+				},
+			},
+			{
+				Name:  "default-vino-node01-storage-0",
+				Role:  "storage",
+				Owner: "default/vino",
+				Flavor: &vinov1.VinoFlavorSpec{
+					VCPUs:      4,
+					Memory:     8,
+					RootSize:   100,
+					CPUPinning: vinov1.CPUPinningNone,
+				},
+				Interfaces: []vinov1.BuilderNetworkInterface{iface("eth0", "network", "pxe", "52:54:00:00:00:04")},
+			},
+			{
+				Name:       "default-vino-node01-worker-0",
+				Role:       "worker",
+				Owner:      "default/vino",
+				Interfaces: []vinov1.BuilderNetworkInterface{iface("eth0", "network", "pxe", "52:54:00:00:00:03")},
+			},
+		},
+	}
+}
+
+func TestRender(t *testing.T) {
+	flavors := map[string]Flavor{
+		"master": {VCPUs: 2, Memory: 4, RootSize: 30, HugePages: true},
+		"worker": {VCPUs: 1, Memory: 2, RootSize: 10, Machine: "pc-i440fx-xenial"},
+	}
+	cores := map[string][]int{
+		"default-vino-node01-master-0": {4, 5},
+		// domains without dedicated cores aren't pinned
+		"default-vino-node01-storage-0": {6, 7, 8, 9},
+	}
+	opts := Options{
+		VNCPasswordDir:   filepath.Join("testdata", "vnc"),
+		PXEBootImageHost: "10.0.0.1",
+		CreationTime:     time.Date(2021, time.March, 1, 10, 0, 0, 0, time.UTC),
+	}
+	rendered, err := Render(testBuilder(), flavors, cores, opts)
+	require.NoError(t, err)
+
+	golden := map[string][]byte{}
+	for kind, objects := range map[string]map[string][]byte{
+		"pool":    rendered.StoragePools,
+		"network": rendered.Networks,
+		"volume":  rendered.Volumes,
+		"domain":  rendered.Domains,
+	} {
+		for name, xml := range objects {
+			golden[filepath.Join("testdata", kind+"-"+name+".xml")] = xml
+		}
+	}
+	assert.Len(t, golden, 8)
+	for path, xml := range golden {
+		if *update {
+			require.NoError(t, ioutil.WriteFile(path, xml, 0600))
+			continue
+		}
+		expected, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), string(xml), path)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	flavors := map[string]Flavor{"master": {VCPUs: 1, Memory: 1, RootSize: 1}}
+	opts := Options{VNCPasswordDir: filepath.Join("testdata", "vnc")}
+
+	tests := []struct {
+		name   string
+		modify func(*vinov1.Builder)
+		err    string
+	}{
+		{
+			name:   "unknown flavor",
+			modify: func(b *vinov1.Builder) { b.Domains[0].Role = "worker" },
+			err:    "flavor worker is not defined",
+		},
+		{
+			name:   "unsupported network template",
+			modify: func(b *vinov1.Builder) { b.Networks[0].LibvirtTemplate = "isolated" },
+			err:    "unsupported libvirt template isolated",
+		},
+		{
+			name:   "unsupported interface type",
+			modify: func(b *vinov1.Builder) { b.Domains[0].Interfaces[0].Type = "direct" },
+			err:    "interface eth0 has unsupported type direct",
+		},
+		{
+			name:   "missing VNC password",
+			modify: func(b *vinov1.Builder) { b.Domains[0].VNCPasswordKey = "missing" },
+			err:    "failed to read VNC password",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			builder := testBuilder()
+			builder.Domains = builder.Domains[:1]
+			tt.modify(&builder)
+			_, err := Render(builder, flavors, nil, opts)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
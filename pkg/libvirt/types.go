@@ -0,0 +1,366 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirt
+
+import "encoding/xml"
+
+// Domain is libvirt domain XML, only elements that vino-builder sets are defined
+type Domain struct {
+	XMLName       xml.Name       `xml:"domain"`
+	Type          string         `xml:"type,attr"`
+	Name          string         `xml:"name"`
+	UUID          string         `xml:"uuid"`
+	Metadata      DomainMetadata `xml:"metadata"`
+	Memory        Memory         `xml:"memory"`
+	MemoryBacking *MemoryBacking `xml:"memoryBacking"`
+	VCPU          VCPU           `xml:"vcpu"`
+	CPUTune       *CPUTune       `xml:"cputune"`
+	Resource      Resource       `xml:"resource"`
+	OS            OS             `xml:"os"`
+	Features      Features       `xml:"features"`
+	CPU           CPU            `xml:"cpu"`
+	Clock         Clock          `xml:"clock"`
+	OnPoweroff    string         `xml:"on_poweroff"`
+	OnReboot      string         `xml:"on_reboot"`
+	OnCrash       string         `xml:"on_crash"`
+	Devices       Devices        `xml:"devices"`
+	SecLabel      SecLabel       `xml:"seclabel"`
+}
+
+// DomainMetadata is vino metadata of a domain
+type DomainMetadata struct {
+	Flavor       string `xml:"vino:flavor"`
+	Owner        string `xml:"vino:owner"`
+	CreationTime string `xml:"vino:creationTime"`
+}
+
+// Memory is an amount of memory with its unit
+type Memory struct {
+	Unit  string `xml:"unit,attr"`
+	Value int64  `xml:",chardata"`
+}
+
+// MemoryBacking makes domain memory backed by huge pages
+type MemoryBacking struct {
+	HugePages HugePages `xml:"hugepages"`
+}
+
+// HugePages lists sizes of huge pages
+type HugePages struct {
+	Pages []Page `xml:"page"`
+}
+
+// Page is a huge page size
+type Page struct {
+	Size int64  `xml:"size,attr"`
+	Unit string `xml:"unit,attr"`
+}
+
+// VCPU is a number of domain virtual CPUs
+type VCPU struct {
+	Placement string `xml:"placement,attr"`
+	Value     int64  `xml:",chardata"`
+}
+
+// CPUTune pins virtual CPUs and emulator threads to host cores
+type CPUTune struct {
+	Shares      int64       `xml:"shares"`
+	VCPUPins    []VCPUPin   `xml:"vcpupin"`
+	EmulatorPin EmulatorPin `xml:"emulatorpin"`
+}
+
+// VCPUPin pins a virtual CPU to host cores
+type VCPUPin struct {
+	VCPU   int    `xml:"vcpu,attr"`
+	CPUSet string `xml:"cpuset,attr"`
+}
+
+// EmulatorPin pins emulator threads to host cores
+type EmulatorPin struct {
+	CPUSet string `xml:"cpuset,attr"`
+}
+
+// Resource is the resource partition of a domain
+type Resource struct {
+	Partition string `xml:"partition"`
+}
+
+// OS defines how a domain boots
+type OS struct {
+	Type OSType `xml:"type"`
+	Boot Boot   `xml:"boot"`
+}
+
+// OSType is a domain architecture and machine type
+type OSType struct {
+	Arch    string `xml:"arch,attr"`
+	Machine string `xml:"machine,attr"`
+	Value   string `xml:",chardata"`
+}
+
+// Boot is a boot device
+type Boot struct {
+	Dev string `xml:"dev,attr"`
+}
+
+// Features are hypervisor features enabled for a domain
+type Features struct {
+	ACPI *struct{} `xml:"acpi"`
+	APIC *struct{} `xml:"apic"`
+}
+
+// CPU is a CPU model of a domain
+type CPU struct {
+	Mode string `xml:"mode,attr"`
+}
+
+// Clock is a domain clock
+type Clock struct {
+	Offset string  `xml:"offset,attr"`
+	Timers []Timer `xml:"timer"`
+}
+
+// Timer is a domain clock timer
+type Timer struct {
+	Name       string `xml:"name,attr"`
+	TickPolicy string `xml:"tickpolicy,attr,omitempty"`
+	Present    string `xml:"present,attr,omitempty"`
+}
+
+// Devices are domain devices
+type Devices struct {
+	Emulator    string       `xml:"emulator"`
+	Disks       []Disk       `xml:"disk"`
+	Controllers []Controller `xml:"controller"`
+	Interfaces  []Interface  `xml:"interface"`
+	Serials     []Serial     `xml:"serial"`
+	Console     Console      `xml:"console"`
+	Graphics    *Graphics    `xml:"graphics"`
+	MemBalloon  MemBalloon   `xml:"memballoon"`
+}
+
+// Disk is a domain disk backed by a storage volume
+type Disk struct {
+	Type   string     `xml:"type,attr"`
+	Device string     `xml:"device,attr"`
+	Driver DiskDriver `xml:"driver"`
+	Source DiskSource `xml:"source"`
+	Target DiskTarget `xml:"target"`
+}
+
+// DiskDriver is a disk driver
+type DiskDriver struct {
+	Name    string `xml:"name,attr"`
+	Type    string `xml:"type,attr"`
+	Cache   string `xml:"cache,attr"`
+	Discard string `xml:"discard,attr"`
+}
+
+// DiskSource is a storage volume of a disk
+type DiskSource struct {
+	Pool   string `xml:"pool,attr"`
+	Volume string `xml:"volume,attr"`
+}
+
+// DiskTarget is a device a disk is attached as
+type DiskTarget struct {
+	Dev string `xml:"dev,attr"`
+	Bus string `xml:"bus,attr"`
+}
+
+// Controller is a domain bus controller
+type Controller struct {
+	Type  string `xml:"type,attr"`
+	Index int    `xml:"index,attr"`
+	Model string `xml:"model,attr,omitempty"`
+	Alias Alias  `xml:"alias"`
+}
+
+// Alias is a device alias
+type Alias struct {
+	Name string `xml:"name,attr"`
+}
+
+// Interface is a domain network interface
+type Interface struct {
+	Type   string          `xml:"type,attr"`
+	MAC    MAC             `xml:"mac"`
+	Source InterfaceSource `xml:"source"`
+	Model  Model           `xml:"model"`
+}
+
+// MAC is a MAC address of an interface
+type MAC struct {
+	Address string `xml:"address,attr"`
+}
+
+// InterfaceSource is a libvirt network or a bridge an interface is plugged into, depending on
+// the interface type
+type InterfaceSource struct {
+	Network string `xml:"network,attr,omitempty"`
+	Bridge  string `xml:"bridge,attr,omitempty"`
+}
+
+// Model is a device model
+type Model struct {
+	Type string `xml:"type,attr"`
+}
+
+// Serial is a domain serial port
+type Serial struct {
+	Type   string      `xml:"type,attr"`
+	Source *PathSource `xml:"source"`
+}
+
+// PathSource is a file a device writes to
+type PathSource struct {
+	Path string `xml:"path,attr"`
+}
+
+// Console is a domain console
+type Console struct {
+	Type   string        `xml:"type,attr"`
+	Source PathSource    `xml:"source"`
+	Target ConsoleTarget `xml:"target"`
+}
+
+// ConsoleTarget is a device a console is attached to
+type ConsoleTarget struct {
+	Type string `xml:"type,attr"`
+}
+
+// Graphics is a VNC server of a domain
+type Graphics struct {
+	Type     string           `xml:"type,attr"`
+	AutoPort string           `xml:"autoport,attr"`
+	Port     int              `xml:"port,attr"`
+	Passwd   string           `xml:"passwd,attr"`
+	Listen   string           `xml:"listen,attr"`
+	Listens  []GraphicsListen `xml:"listen"`
+}
+
+// GraphicsListen is an address VNC server listens on
+type GraphicsListen struct {
+	Type    string `xml:"type,attr"`
+	Address string `xml:"address,attr"`
+}
+
+// MemBalloon is a memory balloon device
+type MemBalloon struct {
+	Model string `xml:"model,attr"`
+	Stats Stats  `xml:"stats"`
+	Alias Alias  `xml:"alias"`
+}
+
+// Stats defines period of memory balloon statistics
+type Stats struct {
+	Period int `xml:"period,attr"`
+}
+
+// SecLabel is a security label of a domain
+type SecLabel struct {
+	Type       string `xml:"type,attr"`
+	Model      string `xml:"model,attr"`
+	Relabel    string `xml:"relabel,attr"`
+	Label      string `xml:"label"`
+	ImageLabel string `xml:"imagelabel"`
+}
+
+// Network is libvirt network XML
+type Network struct {
+	XMLName xml.Name    `xml:"network"`
+	Name    string      `xml:"name"`
+	Forward Forward     `xml:"forward"`
+	Bridge  Bridge      `xml:"bridge"`
+	IPs     []NetworkIP `xml:"ip"`
+}
+
+// Forward is a forwarding mode of a network
+type Forward struct {
+	Mode string `xml:"mode,attr"`
+}
+
+// Bridge is a bridge of a network
+type Bridge struct {
+	Name  string `xml:"name,attr"`
+	STP   string `xml:"stp,attr"`
+	Delay int    `xml:"delay,attr"`
+}
+
+// NetworkIP is an address of network bridge, DHCP is served only for IPv4 addresses
+type NetworkIP struct {
+	Family  string `xml:"family,attr,omitempty"`
+	Address string `xml:"address,attr,omitempty"`
+	Netmask string `xml:"netmask,attr,omitempty"`
+	Prefix  int    `xml:"prefix,attr,omitempty"`
+	DHCP    *DHCP  `xml:"dhcp"`
+}
+
+// DHCP is DHCP server of a network
+type DHCP struct {
+	Range DHCPRange `xml:"range"`
+	BootP BootP     `xml:"bootp"`
+}
+
+// DHCPRange is a range of addresses served by DHCP
+type DHCPRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// BootP is a PXE boot file served by DHCP
+type BootP struct {
+	File string `xml:"file,attr"`
+}
+
+// Volume is libvirt storage volume XML
+type Volume struct {
+	XMLName    xml.Name     `xml:"volume"`
+	Name       string       `xml:"name"`
+	Allocation int64        `xml:"allocation"`
+	Capacity   Memory       `xml:"capacity"`
+	Target     VolumeTarget `xml:"target"`
+}
+
+// VolumeTarget is a format of a volume
+type VolumeTarget struct {
+	Format Format `xml:"format"`
+}
+
+// Format is a volume format
+type Format struct {
+	Type string `xml:"type,attr"`
+}
+
+// StoragePool is libvirt directory storage pool XML
+type StoragePool struct {
+	XMLName xml.Name   `xml:"pool"`
+	Type    string     `xml:"type,attr"`
+	Name    string     `xml:"name"`
+	Target  PoolTarget `xml:"target"`
+}
+
+// PoolTarget is a directory of a storage pool
+type PoolTarget struct {
+	Path        string          `xml:"path"`
+	Permissions PoolPermissions `xml:"permissions"`
+}
+
+// PoolPermissions are permissions of a storage pool directory
+type PoolPermissions struct {
+	Mode  string `xml:"mode"`
+	Owner int    `xml:"owner"`
+	Group int    `xml:"group"`
+}
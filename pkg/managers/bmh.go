@@ -19,6 +19,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strconv"
 	"text/template"
 
 	"github.com/Masterminds/sprig"
@@ -28,8 +29,8 @@ import (
 	apierror "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	kerror "k8s.io/apimachinery/pkg/util/errors"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/yaml"
 
 	vinov1 "vino/pkg/api/v1"
 	"vino/pkg/ipam"
@@ -38,7 +39,7 @@ import (
 const (
 	// DefaultMACPrefix is a private RFC 1918 MAC range used if
 	// no MACPrefix is specified for a network in the ViNO CR
-	DefaultMACPrefix = "02:00:00:00:00:00"
+	DefaultMACPrefix = vinov1.VinoDefaultMACPrefix
 )
 
 type networkTemplateValues struct {
@@ -61,69 +62,229 @@ type BMHManager struct {
 	bmhList           []*metal3.BareMetalHost
 	networkSecrets    []*corev1.Secret
 	credentialSecrets []*corev1.Secret
+	nodeStatuses      []vinov1.NodeStatus
+	bmcUsername       string
+	bmcPassword       string
+	// generated credentials, that are applied before VMs are requested
+	generatedSecrets []*corev1.Secret
+	rotationStep     rotationStep
+	// VNC passwords by BMH name, that are applied before VMs are requested
+	vncPasswords         map[string][]byte
+	existingVNCPasswords map[string][]byte
+	nodeSetVNCPasswords  map[string][]byte
+	// builder configs are applied after all secrets they depend on
+	builderRequests []builderRequest
+	// VM flavors by role, used to account resources of k8s nodes shared by vino CRs
+	flavors map[string]flavor
+	// sizing of VMs by node set name, resolved from VinoFlavors referenced by node sets
+	nodeSetFlavors map[string]*vinov1.VinoFlavorSpec
+	// state of domains reported by vino-builders, BMHs are created only for realized domains
+	domainStatuses map[string]vinov1.DomainStatus
+	buildFailures  map[string]string
+	realization    VMRealization
+	// reasons VMs above count of their node sets are kept, by BMH name
+	scaleDownBlocked map[string]string
+	// numbers of VMs of node sets in Total mode by node set and k8s node name
+	nodeSetCounts map[string]map[string]int
+	placements    []vinov1.NodeSetPlacementStatus
+	// reasons VMs of node sets are not placed as requested, by node set name
+	unsatisfiable map[string]string
+}
+
+// builderRequest is a request for VMs to vino-builder on the k8s node
+type builderRequest struct {
+	nodeName  string
+	builder   vinov1.Builder
+	resources corev1.ResourceList
 }
 
 func (r *BMHManager) ScheduleVMs(ctx context.Context) error {
 	return r.requestVMs(ctx)
 }
 
+// CreateBMHs creates BMHs for VMs, that vino-builders have reported as realized. BMHs of other
+// VMs are created by later reconciliations, VMRealization returns their state
 func (r *BMHManager) CreateBMHs(ctx context.Context) error {
+	if err := r.loadDomainStatuses(ctx); err != nil {
+		return err
+	}
+	bmhNodes := map[string]string{}
+	for _, nodeStatus := range r.nodeStatuses {
+		for _, vm := range nodeStatus.VMs {
+			bmhNodes[vm.BMHName] = nodeStatus.Name
+		}
+	}
+
+	// secrets are updated, so that changes of network templates and BMC credentials reach BMHs
 	for _, secret := range r.networkSecrets {
-		objKey := client.ObjectKeyFromObject(secret)
-		r.Logger.Info("Applying network secret", "secret", objKey)
-		if err := applyRuntimeObject(ctx, objKey, secret, r.Client); err != nil {
+		r.Logger.Info("Applying network secret", "secret", client.ObjectKeyFromObject(secret))
+		if err := applySecret(ctx, r.Client, secret); err != nil {
 			return err
 		}
 	}
 
 	for _, secret := range r.credentialSecrets {
-		objKey := client.ObjectKeyFromObject(secret)
-		r.Logger.Info("Applying network secret", "secret", objKey)
-		if err := applyRuntimeObject(ctx, objKey, secret, r.Client); err != nil {
+		r.Logger.Info("Applying BMH credentials secret", "secret", client.ObjectKeyFromObject(secret))
+		if err := applySecret(ctx, r.Client, secret); err != nil {
 			return err
 		}
 	}
 
 	for _, bmh := range r.bmhList {
 		objKey := client.ObjectKeyFromObject(bmh)
+		if !r.domainRealized(bmh.Name, bmhNodes[bmh.Name]) {
+			r.Logger.Info("VM is not realized by vino-builder yet, skipping BaremetalHost", "BMH", objKey)
+			continue
+		}
 		r.Logger.Info("Applying BaremetalHost", "BMH", objKey)
-		if err := applyRuntimeObject(ctx, objKey, bmh, r.Client); err != nil {
+		if err := r.applyBMH(ctx, bmh); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// NodeStatuses returns status of k8s nodes that VMs are scheduled to. Provisioning state of VMs
+// is taken from BMHs, if they already exist in the cluster
+func (r *BMHManager) NodeStatuses(ctx context.Context) ([]vinov1.NodeStatus, error) {
+	for _, nodeStatus := range r.nodeStatuses {
+		for i, vm := range nodeStatus.VMs {
+			bmh := &metal3.BareMetalHost{}
+			err := r.Get(ctx, types.NamespacedName{Name: vm.BMHName, Namespace: r.Namespace}, bmh)
+			switch {
+			case apierror.IsNotFound(err):
+				continue
+			case err != nil:
+				return nil, err
+			}
+			nodeStatus.VMs[i].ProvisioningState = string(bmh.Status.Provisioning.State)
+		}
+	}
+	return r.nodeStatuses, nil
+}
+
+// UnScheduleVMs deletes all vino-builder configs of vino CR
 func (r *BMHManager) UnScheduleVMs(ctx context.Context) error {
-	podList, err := r.getPods(ctx)
+	return r.deleteBuilderConfigs(ctx, nil)
+}
+
+// TearDown removes everything vino CR has created for VMs: vino-builder configs, BMHs with their
+// secrets and IPAM allocations. Allocations of k8s nodes, that are still used by VMs of other
+// vino CRs, are kept. It doesn't stop on the first error, all errors are aggregated
+func (r *BMHManager) TearDown(ctx context.Context) error {
+	nodeNames, err := r.nodeNames(ctx)
 	if err != nil {
 		return err
 	}
-	for _, pod := range podList.Items {
-		k8sNode, err := r.getNode(ctx, pod)
-		if err != nil {
-			return err
+
+	errs := []error{}
+	if err = r.UnScheduleVMs(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	bmhNames, deleteErrs := r.deleteBMHs(ctx)
+	errs = append(errs, deleteErrs...)
+
+	// BMH IPs are allocated to <bmh name>/<network name>. A name prefix of vino CR could also
+	// match BMHs of another vino CR, so IPs are released by exact BMH names
+	for _, nodeStatus := range r.ViNO.Status.Nodes {
+		for _, vm := range nodeStatus.VMs {
+			bmhNames = append(bmhNames, vm.BMHName)
 		}
-		annotations := k8sNode.GetAnnotations()
-		if k8sNode.GetAnnotations() == nil {
+	}
+	prefixes := make([]string, 0, len(bmhNames))
+	for _, bmhName := range bmhNames {
+		prefixes = append(prefixes, bmhName+"/")
+	}
+	if err = r.Ipam.ReleaseByPrefixes(ctx, prefixes); err != nil {
+		errs = append(errs, err)
+	}
+	for _, nodeName := range nodeNames {
+		// bridge IPs and DHCP ranges are allocated to k8s nodes, and are shared by vino CRs
+		// that have networks with the same subnet on the node
+		others, err := r.otherBuilderConfigs(ctx, nodeName)
+		if err != nil {
+			errs = append(errs, err)
 			continue
 		}
+		if err = r.Ipam.ReleaseHost(ctx, nodeName, usedSubnets(others)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return kerror.NewAggregate(errs)
+}
 
-		delete(annotations, vinov1.VinoNodeNetworkValuesAnnotation)
-		k8sNode.SetAnnotations(annotations)
-		// TODO consider accumulating errors instead
-		if err = r.Update(ctx, k8sNode); err != nil {
-			return err
+// deleteBMHs deletes BMHs and secrets labeled with vino CR labels, and returns names of the BMHs
+func (r *BMHManager) deleteBMHs(ctx context.Context) ([]string, []error) {
+	labelOpt := client.MatchingLabels(r.vinoLabels())
+	nsOpt := client.InNamespace(r.Namespace)
+
+	bmhList := &metal3.BareMetalHostList{}
+	if err := r.List(ctx, bmhList, labelOpt, nsOpt); err != nil {
+		return nil, []error{err}
+	}
+	secretList := &corev1.SecretList{}
+	if err := r.List(ctx, secretList, labelOpt, nsOpt); err != nil {
+		return nil, []error{err}
+	}
+
+	objects := []client.Object{}
+	bmhNames := []string{}
+	for i := range bmhList.Items {
+		objects = append(objects, &bmhList.Items[i])
+		bmhNames = append(bmhNames, bmhList.Items[i].Name)
+	}
+	for i := range secretList.Items {
+		objects = append(objects, &secretList.Items[i])
+	}
+
+	errs := []error{}
+	for _, obj := range objects {
+		r.Logger.Info("Deleting object created for vino CR",
+			"kind", fmt.Sprintf("%T", obj), "object", client.ObjectKeyFromObject(obj))
+		if err := r.Delete(ctx, obj); err != nil && !apierror.IsNotFound(err) {
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	return bmhNames, errs
 }
 
-func (r *BMHManager) getPods(ctx context.Context) (*corev1.PodList, error) {
-	labelOpt := client.MatchingLabels{
-		vinov1.VinoLabelDSNameSelector:      r.ViNO.Name,
-		vinov1.VinoLabelDSNamespaceSelector: r.ViNO.Namespace,
+// nodeNames returns names of k8s nodes that vino-builders run on or have been running on
+func (r *BMHManager) nodeNames(ctx context.Context) ([]string, error) {
+	podList, err := r.getPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]struct{}{}
+	names := []string{}
+	for _, nodeStatus := range r.ViNO.Status.Nodes {
+		seen[nodeStatus.Name] = struct{}{}
+		names = append(names, nodeStatus.Name)
+	}
+	for _, pod := range podList.Items {
+		if _, exists := seen[pod.Spec.NodeName]; exists || pod.Spec.NodeName == "" {
+			continue
+		}
+		seen[pod.Spec.NodeName] = struct{}{}
+		names = append(names, pod.Spec.NodeName)
+	}
+	return names, nil
+}
+
+// vinoLabels returns labels that identify objects created for vino CR
+func (r *BMHManager) vinoLabels() map[string]string {
+	return vinoLabels(r.ViNO)
+}
+
+// vinoLabels returns labels, that map objects in the runtime namespace to vino CR
+func vinoLabels(vino *vinov1.Vino) map[string]string {
+	return map[string]string{
+		vinov1.VinoLabelDSNameSelector:      vino.Name,
+		vinov1.VinoLabelDSNamespaceSelector: vino.Namespace,
 	}
+}
+
+func (r *BMHManager) getPods(ctx context.Context) (*corev1.PodList, error) {
+	labelOpt := client.MatchingLabels(r.vinoLabels())
 
 	nsOpt := client.InNamespace(r.Namespace)
 
@@ -131,18 +292,57 @@ func (r *BMHManager) getPods(ctx context.Context) (*corev1.PodList, error) {
 	return podList, r.List(ctx, podList, labelOpt, nsOpt)
 }
 
-// requestVMs iterates over each vino-builder pod, and annotates a k8s node for the pod
-// with a request for VMs. Each vino-builder pod waits for the annotation.
-// when annotation with VM request is added to a k8s node, vino manager WaitVMs should be used before creating BMHs
+// requestVMs iterates over each vino-builder pod, and creates VinoBuilderConfig with a request
+// for VMs for the k8s node of the pod. Each vino-builder pod waits for its config.
+// when VMs are requested from all vino-builders, vino manager WaitVMs should be used before creating BMHs
 func (r *BMHManager) requestVMs(ctx context.Context) error {
 	podList, err := r.getPods(ctx)
 	if err != nil {
 		return err
 	}
 
-	r.Logger.Info("Vino daemonset pod count", "count", len(podList.Items))
-	physicalNodeCount := len(podList.Items)
+	r.bmcUsername, r.bmcPassword, err = GetBMCCredentials(ctx, r.Client, r.ViNO)
+	if err != nil {
+		return err
+	}
+
+	if r.ViNO.Spec.BMCCredentials.Generate {
+		r.rotationStep = nextRotationStep(r.ViNO)
+	}
+	if err = r.loadVNCPasswords(ctx); err != nil {
+		return err
+	}
+	if err = r.loadFlavors(ctx); err != nil {
+		return err
+	}
+	if err = r.loadNodeSetFlavors(ctx); err != nil {
+		return err
+	}
+	r.scaleDownBlocked = map[string]string{}
+
+	// pods, that are not scheduled yet or are being deleted, don't run vino-builders
+	pods := []corev1.Pod{}
+	scheduled := map[string]struct{}{}
 	for _, pod := range podList.Items {
+		if pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		pods = append(pods, pod)
+		scheduled[pod.Spec.NodeName] = struct{}{}
+	}
+
+	// nodes, whose vino-builders are not running, but whose VMs are kept, keep their placements
+	requestedNodes, err := r.handleRemovedNodes(ctx, scheduled)
+	if err != nil {
+		return err
+	}
+	if err = r.placeNodeSets(ctx, pods, requestedNodes); err != nil {
+		return err
+	}
+
+	r.Logger.Info("Vino daemonset pod count", "count", len(pods))
+	physicalNodeCount := len(pods)
+	for _, pod := range pods {
 		r.Logger.Info("Creating baremetal hosts for pod",
 			"pod name",
 			types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name},
@@ -156,6 +356,42 @@ func (r *BMHManager) requestVMs(ctx context.Context) error {
 			return err
 		}
 	}
+	if r.ViNO.Spec.BMCCredentials.Generate {
+		if err = r.applyGeneratedCredentials(ctx); err != nil {
+			return err
+		}
+	}
+	if err = r.applyVNCPasswords(ctx); err != nil {
+		return err
+	}
+
+	for _, request := range r.builderRequests {
+		if err = r.applyBuilderConfig(ctx, request); err != nil {
+			return err
+		}
+		requestedNodes[request.nodeName] = struct{}{}
+	}
+	// vino-builders are no longer running on nodes, that don't match node selector, configs of
+	// removed nodes are kept until their grace period passes
+	return r.deleteBuilderConfigs(ctx, requestedNodes)
+}
+
+// applyGeneratedCredentials updates htpasswd secret mounted to sushy first and BMH credentials
+// secrets after it, so that BMHs never use credentials sushy doesn't accept yet. Rotation step
+// is recorded in vino status only after all secrets are updated.
+func (r *BMHManager) applyGeneratedCredentials(ctx context.Context) error {
+	htpasswd := newHtpasswdSecret(r.ViNO, r.Namespace, r.generatedSecrets)
+	r.Logger.Info("Applying htpasswd secret", "secret", client.ObjectKeyFromObject(htpasswd))
+	if err := applySecret(ctx, r.Client, htpasswd); err != nil {
+		return err
+	}
+	for _, secret := range r.generatedSecrets {
+		r.Logger.Info("Applying generated credentials secret", "secret", client.ObjectKeyFromObject(secret))
+		if err := applySecret(ctx, r.Client, secret); err != nil {
+			return err
+		}
+	}
+	advanceRotation(r.ViNO, r.rotationStep)
 	return nil
 }
 
@@ -179,7 +415,19 @@ func (r *BMHManager) createIpamNetwork(ctx context.Context, network vinov1.Netwo
 			"default prefix", DefaultMACPrefix, "network name", network.Name)
 		macPrefix = DefaultMACPrefix
 	}
-	return r.Ipam.AddSubnetRange(ctx, network.SubNet, subnetRange, macPrefix)
+	if err = r.Ipam.AddSubnetRange(ctx, network.SubNet, subnetRange, macPrefix); err != nil {
+		return err
+	}
+	if network.IPv6 == nil {
+		return nil
+	}
+
+	// MACs are taken from the primary subnet, IPv6 pool only provides addresses
+	ipv6Range, err := ipam.NewRange(network.IPv6.StaticAllocationStart, network.IPv6.StaticAllocationStop)
+	if err != nil {
+		return err
+	}
+	return r.Ipam.AddSubnetRange(ctx, network.IPv6.SubNet, ipv6Range, macPrefix)
 }
 
 func (r *BMHManager) setBMHs(ctx context.Context, pod corev1.Pod, nodeCount int) error {
@@ -195,31 +443,94 @@ func (r *BMHManager) setBMHs(ctx context.Context, pod corev1.Pod, nodeCount int)
 		return err
 	}
 
+	nodeIP, err := nodeInternalIP(k8sNode)
+	if err != nil {
+		return err
+	}
+
+	// VMs of other vino CRs on the node share its VNC ports and resources
+	others, err := r.otherBuilderConfigs(ctx, k8sNode.Name)
+	if err != nil {
+		return err
+	}
+	vncPorts := usedVNCPorts(others)
+	// VMs keep VNC ports they already have, so that their consoles don't move when VMs of other
+	// vino CRs come and go, only new VMs get free ports
+	currentPorts, err := r.currentVNCPorts(ctx, k8sNode.Name)
+	if err != nil {
+		return err
+	}
+	reserveVNCPorts(vncPorts, currentPorts)
+	owner := client.ObjectKeyFromObject(r.ViNO).String()
+
+	nodeStatus := vinov1.NodeStatus{
+		Name:         k8sNode.Name,
+		BuilderReady: podReady(pod),
+	}
+	for _, network := range nodeNetworks {
+		nodeStatus.Networks = append(nodeStatus.Networks, vinov1.NodeNetworkStatus{
+			Name:       network.Name,
+			Range:      network.Range,
+			BridgeIP:   network.BridgeIP,
+			BridgeMAC:  network.BridgeMAC,
+			BridgeIPv6: network.BridgeIPv6,
+		})
+	}
+
 	for _, node := range r.ViNO.Spec.Nodes {
+		node.Count = r.nodeSetCount(node, k8sNode)
 		r.Logger.Info("Saving BMHs for vino node", "node name", node.Name, "count", node.Count)
 		prefix := r.getBMHNodePrefix(pod)
+		bmhNames := make([]string, 0, node.Count)
 		for i := 0; i < node.Count; i++ {
-			roleSuffix := fmt.Sprintf("%s-%d", node.Name, i)
-			bmhName := fmt.Sprintf("%s-%s", prefix, roleSuffix)
+			bmhNames = append(bmhNames, fmt.Sprintf("%s-%s-%d", prefix, node.Name, i))
+		}
+		kept, err := r.scaleDownNodeSet(ctx, k8sNode.Name, prefix, node)
+		if err != nil {
+			return err
+		}
+		bmhNames = append(bmhNames, kept...)
+		allocatedIPs, err := r.allocateNodeSetIPs(ctx, bmhNames, node, nodeNetworks)
+		if err != nil {
+			return err
+		}
 
-			domainValues, nodeErr := r.domainSpecificNetValues(ctx, bmhName, node, nodeNetworks)
+		for _, bmhName := range bmhNames {
+			domainValues, nodeErr := r.domainSpecificNetValues(bmhName, node, nodeNetworks, allocatedIPs)
 			if nodeErr != nil {
 				return nodeErr
 			}
-			domainValues.Name = roleSuffix
+			// domains of all vino CRs on the node are defined in the same libvirt, BMH names are unique
+			domainValues.Name = bmhName
+			domainValues.Owner = owner
 			domainValues.Role = node.Name
+			domainValues.Flavor = r.nodeSetFlavors[node.Name]
 			domainValues.EnableVNC = node.EnableVNC
-			domainValues.VNCPassword = r.ViNO.Spec.BMCCredentials.Password
+			vm := vmStatus(bmhName, domainValues.BuilderDomain)
+			if node.EnableVNC {
+				// builder configs are not secret, vino-builder reads VNC password from a mounted secret
+				if nodeErr = r.setVNCPassword(ctx, node, bmhName); nodeErr != nil {
+					return nodeErr
+				}
+				domainValues.VNCPasswordKey = bmhName
+				port, ok := currentPorts[bmhName]
+				if !ok {
+					port = nextVNCPort(vncPorts)
+				}
+				domainValues.VNCPort = port
+				vm.VNCEndpoint = net.JoinHostPort(nodeIP, strconv.Itoa(domainValues.VNCPort))
+			}
 
 			// Append a specific domain to the list
 			domains = append(domains, domainValues.BuilderDomain)
+			nodeStatus.VMs = append(nodeStatus.VMs, vm)
 
 			netData, netDataNs, nodeErr := r.setBMHNetworkSecret(ctx, node, domainValues)
 			if nodeErr != nil {
 				return nodeErr
 			}
 
-			bmcAddr, labels, nodeErr := r.getBMCAddressAndLabels(k8sNode, roleSuffix)
+			bmcAddr, labels, nodeErr := r.getBMCAddressAndLabels(k8sNode, domainValues.Name)
 			if nodeErr != nil {
 				return nodeErr
 			}
@@ -228,12 +539,20 @@ func (r *BMHManager) setBMHs(ctx context.Context, pod corev1.Pod, nodeCount int)
 				labels[label] = value
 			}
 
+			// BMHs live in the runtime namespace, labels are used to map their events to vino CR
+			for label, value := range r.vinoLabels() {
+				labels[label] = value
+			}
+
 			rootDeviceName := node.RootDeviceName
 			if rootDeviceName == "" {
 				rootDeviceName = vinov1.VinoDefaultRootDeviceName
 			}
 
-			credentialSecretName := r.setBMHCredentials(bmhName)
+			credentialSecretName, nodeErr := r.setBMHCredentials(ctx, bmhName)
+			if nodeErr != nil {
+				return nodeErr
+			}
 			bmh := &metal3.BareMetalHost{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      bmhName,
@@ -260,7 +579,19 @@ func (r *BMHManager) setBMHs(ctx context.Context, pod corev1.Pod, nodeCount int)
 		}
 	}
 
-	r.Logger.Info("annotating node", "node", k8sNode.Name)
+	r.nodeStatuses = append(r.nodeStatuses, nodeStatus)
+
+	resources, err := r.domainResources(domains)
+	if err != nil {
+		return err
+	}
+	if err = checkNodeResources(k8sNode, resources, others); err != nil {
+		return err
+	}
+	if err = r.checkHostCapacity(k8sNode, domains, others); err != nil {
+		return err
+	}
+
 	vinoBuilder := vinov1.Builder{
 		PXEBootImageHost:     r.ViNO.Spec.PXEBootImageHost,
 		PXEBootImageHostPort: r.ViNO.Spec.PXEBootImageHostPort,
@@ -269,7 +600,12 @@ func (r *BMHManager) setBMHs(ctx context.Context, pod corev1.Pod, nodeCount int)
 		Domains:              domains,
 		NodeCount:            nodeCount,
 	}
-	return r.annotateNode(ctx, k8sNode, vinoBuilder)
+	r.builderRequests = append(r.builderRequests, builderRequest{
+		nodeName:  k8sNode.Name,
+		builder:   vinoBuilder,
+		resources: resources,
+	})
+	return nil
 }
 
 // nodeNetworks returns a copy of node network with a unique per node values
@@ -289,6 +625,12 @@ func (r *BMHManager) nodeNetworks(ctx context.Context,
 		}
 		builderNetwork.BridgeIP = bridgeIP
 		builderNetwork.BridgeMAC = mac
+		if network.IPv6 != nil {
+			builderNetwork.BridgeIPv6, err = r.allocateIPv6(ctx, *network.IPv6, k8sNode.Name)
+			if err != nil {
+				return []vinov1.BuilderNetwork{}, err
+			}
+		}
 
 		for routeIndex, route := range builderNetwork.Network.Routes {
 			if route.Gateway == "$vinobridge" {
@@ -315,52 +657,103 @@ func (r *BMHManager) nodeNetworks(ctx context.Context,
 	return builderNetworks, nil
 }
 
+// allocateNodeSetIPs allocates IPs for network interfaces of all BMHs of the node set
+// at once, so that every IPPool is updated only once per node set
+func (r *BMHManager) allocateNodeSetIPs(ctx context.Context,
+	bmhNames []string,
+	node vinov1.NodeSet,
+	networks []vinov1.BuilderNetwork) (map[ipam.IPRequest]vinov1.AllocatedIP, error) {
+	requests := []ipam.IPRequest{}
+	for _, bmhName := range bmhNames {
+		for _, iface := range node.NetworkInterfaces {
+			ifaceRequests, err := interfaceIPRequests(bmhName, iface, networks)
+			if err != nil {
+				return nil, err
+			}
+			requests = append(requests, ifaceRequests...)
+		}
+	}
+
+	allocated, err := r.Ipam.AllocateIPs(ctx, requests)
+	if err != nil {
+		return nil, err
+	}
+	allocatedIPs := make(map[ipam.IPRequest]vinov1.AllocatedIP, len(requests))
+	for i, request := range requests {
+		allocatedIPs[request] = allocated[i]
+	}
+	return allocatedIPs, nil
+}
+
+// interfaceIPRequests returns a request for IPv4 address of the BMH interface, followed by
+// a request for IPv6 address if the interface network is dual-stack
+func interfaceIPRequests(bmhName string,
+	iface vinov1.NetworkInterface,
+	networks []vinov1.BuilderNetwork) ([]ipam.IPRequest, error) {
+	allocatedTo := fmt.Sprintf("%s/%s", bmhName, iface.NetworkName)
+	for _, network := range networks {
+		if network.Name != iface.NetworkName {
+			continue
+		}
+		subnetRange, err := ipam.NewRange(network.StaticAllocationStart, network.StaticAllocationStop)
+		if err != nil {
+			return nil, err
+		}
+		requests := []ipam.IPRequest{{Subnet: network.SubNet, SubnetRange: subnetRange, AllocatedTo: allocatedTo}}
+		if network.IPv6 == nil {
+			return requests, nil
+		}
+		ipv6Range, err := ipam.NewRange(network.IPv6.StaticAllocationStart, network.IPv6.StaticAllocationStop)
+		if err != nil {
+			return nil, err
+		}
+		return append(requests, ipam.IPRequest{
+			Subnet:      network.IPv6.SubNet,
+			SubnetRange: ipv6Range,
+			AllocatedTo: allocatedTo,
+		}), nil
+	}
+	return nil, fmt.Errorf("Interface %s doesn't have a matching network defined", iface.NetworkName)
+}
+
 func (r *BMHManager) domainSpecificNetValues(
-	ctx context.Context,
 	bmhName string,
 	node vinov1.NodeSet,
-	networks []vinov1.BuilderNetwork) (networkTemplateValues, error) {
-	// Allocate an IP for each of this BMH's network interfaces
+	networks []vinov1.BuilderNetwork,
+	allocatedIPs map[ipam.IPRequest]vinov1.AllocatedIP) (networkTemplateValues, error) {
+	// Look up IPs allocated for each of this BMH's network interfaces
 	bootMAC := ""
 	domainInterfaces := []vinov1.BuilderNetworkInterface{}
 	for _, iface := range node.NetworkInterfaces {
-		networkName := iface.NetworkName
-		subnet := ""
-		var err error
-		subnetRange := vinov1.Range{}
-		for _, network := range networks {
-			if network.Name == networkName {
-				subnet = network.SubNet
-				subnetRange, err = ipam.NewRange(network.StaticAllocationStart, network.StaticAllocationStop)
-				if err != nil {
-					return networkTemplateValues{}, err
-				}
-				break
-			}
-		}
-		if subnet == "" {
-			return networkTemplateValues{}, fmt.Errorf("Interface %s doesn't have a matching network defined", networkName)
-		}
-		ipAllocatedTo := fmt.Sprintf("%s/%s", bmhName, iface.NetworkName)
-		ipAddress, macAddress, err := r.Ipam.AllocateIP(ctx, subnet, subnetRange, ipAllocatedTo)
+		requests, err := interfaceIPRequests(bmhName, iface, networks)
 		if err != nil {
 			return networkTemplateValues{}, err
 		}
-		netmask, err := convertNetmask(subnet)
+		allocatedIP := allocatedIPs[requests[0]]
+		netmask, err := convertNetmask(requests[0].Subnet)
 		if err != nil {
 			return networkTemplateValues{}, err
 		}
-		domainInterfaces = append(domainInterfaces, vinov1.BuilderNetworkInterface{
-			IPAddress:        ipAddress,
-			MACAddress:       macAddress,
+		domainInterface := vinov1.BuilderNetworkInterface{
+			IPAddress:        allocatedIP.IP,
+			MACAddress:       allocatedIP.MAC,
 			NetworkInterface: iface,
 			NetMask:          netmask,
-		})
+		}
+		// MAC of the interface is the one allocated with IPv4 address
+		if len(requests) > 1 {
+			domainInterface.IPv6Address = allocatedIPs[requests[1]].IP
+			domainInterface.IPv6NetMask, err = convertNetmask(requests[1].Subnet)
+			if err != nil {
+				return networkTemplateValues{}, err
+			}
+		}
+		domainInterfaces = append(domainInterfaces, domainInterface)
 
 		r.Logger.Info("Got MAC and IP for the network and node",
-			"MAC", macAddress, "IP", ipAddress, "bmh name", bmhName)
+			"MAC", allocatedIP.MAC, "IP", allocatedIP.IP, "bmh name", bmhName)
 		if iface.Name == node.BootInterfaceName {
-			bootMAC = macAddress
+			bootMAC = allocatedIP.MAC
 		}
 	}
 
@@ -376,21 +769,31 @@ func (r *BMHManager) domainSpecificNetValues(
 	}, nil
 }
 
-func (r *BMHManager) annotateNode(ctx context.Context, k8sNode *corev1.Node, vinoBuilder vinov1.Builder) error {
-	b, err := yaml.Marshal(vinoBuilder)
-	if err != nil {
-		return err
+func vmStatus(bmhName string, domain vinov1.BuilderDomain) vinov1.VMStatus {
+	status := vinov1.VMStatus{
+		BMHName:        bmhName,
+		Role:           domain.Role,
+		BootMACAddress: domain.BootMACAddress,
+	}
+	for _, iface := range domain.Interfaces {
+		status.Interfaces = append(status.Interfaces, vinov1.VMInterfaceStatus{
+			Name:        iface.Name,
+			NetworkName: iface.NetworkName,
+			IPAddress:   iface.IPAddress,
+			MACAddress:  iface.MACAddress,
+			IPv6Address: iface.IPv6Address,
+		})
 	}
+	return status
+}
 
-	annotations := k8sNode.GetAnnotations()
-	if k8sNode.GetAnnotations() == nil {
-		annotations = make(map[string]string)
+func podReady(pod corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
 	}
-
-	annotations[vinov1.VinoNodeNetworkValuesAnnotation] = string(b)
-	k8sNode.SetAnnotations(annotations)
-
-	return r.Update(ctx, k8sNode)
+	return false
 }
 
 func (r *BMHManager) getBridgeIPandMAC(ctx context.Context,
@@ -403,6 +806,19 @@ func (r *BMHManager) getBridgeIPandMAC(ctx context.Context,
 	return r.Ipam.AllocateIP(ctx, network.SubNet, subnetRange, k8sNode.Name)
 }
 
+// allocateIPv6 allocates a bridge address from IPv6 part of a dual-stack network. Bridge MAC
+// address is allocated together with IPv4 address, so MAC from IPv6 pool is not used
+func (r *BMHManager) allocateIPv6(ctx context.Context,
+	network vinov1.IPv6Network,
+	allocatedTo string) (string, error) {
+	subnetRange, err := ipam.NewRange(network.StaticAllocationStart, network.StaticAllocationStop)
+	if err != nil {
+		return "", err
+	}
+	ip, _, err := r.Ipam.AllocateIP(ctx, network.SubNet, subnetRange, allocatedTo)
+	return ip, err
+}
+
 func (r *BMHManager) getNode(ctx context.Context, pod corev1.Pod) (*corev1.Node, error) {
 	node := &corev1.Node{
 		ObjectMeta: metav1.ObjectMeta{
@@ -430,30 +846,67 @@ func (r *BMHManager) getBMCAddressAndLabels(
 		labels[key] = value
 	}
 
+	nodeIP, err := nodeInternalIP(node)
+	if err != nil {
+		return "", labels, err
+	}
+	return fmt.Sprintf("redfish+http://%s:%d/redfish/v1/Systems/%s", nodeIP, 8000, vmName), labels, nil
+}
+
+// nodeInternalIP returns internal IP address of the k8s node, VMs are reachable through
+func nodeInternalIP(node *corev1.Node) (string, error) {
 	for _, addr := range node.Status.Addresses {
 		if addr.Type == corev1.NodeInternalIP {
-			return fmt.Sprintf("redfish+http://%s:%d/redfish/v1/Systems/%s", addr.Address, 8000, vmName), labels, nil
+			return addr.Address, nil
 		}
 	}
-	return "", labels, fmt.Errorf("Node %s doesn't have internal ip address defined", node.Name)
+	return "", fmt.Errorf("Node %s doesn't have internal ip address defined", node.Name)
 }
 
 // setBMHCredentials returns secret name with credentials and error
-func (r *BMHManager) setBMHCredentials(bmhName string) string {
+func (r *BMHManager) setBMHCredentials(ctx context.Context, bmhName string) (string, error) {
 	credName := fmt.Sprintf("%s-%s", bmhName, "credentials")
+	if r.ViNO.Spec.BMCCredentials.Generate {
+		return credName, r.setGeneratedBMHCredentials(ctx, bmhName, credName)
+	}
 	bmhCredentialSecret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      credName,
 			Namespace: r.Namespace,
+			Labels:    r.vinoLabels(),
 		},
-		StringData: map[string]string{
-			"username": r.ViNO.Spec.BMCCredentials.Username,
-			"password": r.ViNO.Spec.BMCCredentials.Password,
+		Data: map[string][]byte{
+			vinov1.VinoBMCCredentialsUsernameKey: []byte(r.bmcUsername),
+			vinov1.VinoBMCCredentialsPasswordKey: []byte(r.bmcPassword),
 		},
 		Type: corev1.SecretTypeOpaque,
 	}
 	r.credentialSecrets = append(r.credentialSecrets, bmhCredentialSecret)
-	return credName
+	return credName, nil
+}
+
+// setGeneratedBMHCredentials keeps credentials already generated for the VM, generating them
+// only if the VM has none, and applies current rotation step to them
+func (r *BMHManager) setGeneratedBMHCredentials(ctx context.Context, bmhName, credName string) error {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: credName, Namespace: r.Namespace}, secret)
+	if err != nil && !apierror.IsNotFound(err) {
+		return err
+	}
+	data, err := rotateBMHCredentials(bmhName, secret.Data, r.rotationStep)
+	if err != nil {
+		return err
+	}
+	r.generatedSecrets = append(r.generatedSecrets, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      credName,
+			Namespace: r.Namespace,
+			Labels:    r.vinoLabels(),
+		},
+		Data: data,
+		Type: corev1.SecretTypeOpaque,
+	})
+	return nil
 }
 
 func (r *BMHManager) setBMHNetworkSecret(
@@ -498,27 +951,42 @@ func (r *BMHManager) setBMHNetworkSecret(
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: r.Namespace,
+			Labels:    r.vinoLabels(),
 		},
-		StringData: map[string]string{
-			"networkData": buf.String(),
+		Data: map[string][]byte{
+			"networkData": buf.Bytes(),
 		},
 		Type: corev1.SecretTypeOpaque,
 	})
 	return name, r.Namespace, nil
 }
 
-func applyRuntimeObject(ctx context.Context, key client.ObjectKey, obj client.Object, c client.Client) error {
-	getObj := obj
-	err := c.Get(ctx, key, getObj)
+// applyBMH creates the BMH or patches fields vino manages on the existing one. Fields set by
+// others, like consumer reference, image and online state, are kept
+func (r *BMHManager) applyBMH(ctx context.Context, bmh *metal3.BareMetalHost) error {
+	existing := &metal3.BareMetalHost{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(bmh), existing)
 	switch {
 	case apierror.IsNotFound(err):
-		err = c.Create(ctx, obj)
-	case err == nil:
-		err = c.Patch(ctx, obj, client.MergeFrom(getObj))
+		return r.Create(ctx, bmh)
+	case err != nil:
+		return err
+	}
+	patch := client.MergeFrom(existing.DeepCopy())
+	if existing.Labels == nil {
+		existing.Labels = map[string]string{}
 	}
-	return err
+	for label, value := range bmh.Labels {
+		existing.Labels[label] = value
+	}
+	existing.Spec.NetworkData = bmh.Spec.NetworkData
+	existing.Spec.BMC = bmh.Spec.BMC
+	existing.Spec.BootMACAddress = bmh.Spec.BootMACAddress
+	existing.Spec.RootDeviceHints = bmh.Spec.RootDeviceHints
+	return r.Patch(ctx, existing, patch)
 }
 
+// convertNetmask returns dotted decimal netmask for IPv4 subnet and prefix length for IPv6 subnet
 func convertNetmask(subnet string) (string, error) {
 	_, network, err := net.ParseCIDR(subnet)
 	if err != nil {
@@ -526,9 +994,12 @@ func convertNetmask(subnet string) (string, error) {
 	}
 
 	m := network.Mask
-	if len(m) != 4 {
-		return "", fmt.Errorf("Couldn't parse netmask for subnet %s", subnet)
-	}
-
-	return fmt.Sprintf("%d.%d.%d.%d", m[0], m[1], m[2], m[3]), nil
+	switch len(m) {
+	case net.IPv4len:
+		return fmt.Sprintf("%d.%d.%d.%d", m[0], m[1], m[2], m[3]), nil
+	case net.IPv6len:
+		ones, _ := m.Size()
+		return strconv.Itoa(ones), nil
+	}
+	return "", fmt.Errorf("Couldn't parse netmask for subnet %s", subnet)
 }
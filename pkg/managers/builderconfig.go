@@ -0,0 +1,261 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+// BuilderConfigName returns name of VinoBuilderConfig with VMs of vino CR on the k8s node. Both
+// names may contain dashes, so the name ends with a hash of the pair, that tells configs of
+// vino a-b on node c and of vino a on node b-c apart. Names of long k8s nodes are shortened
+func BuilderConfigName(vino *vinov1.Vino, nodeName string) string {
+	h := fnv.New32a()
+	// vino names can't contain slashes
+	h.Write([]byte(vino.Name + "/" + nodeName))
+	hash := fmt.Sprintf("%08x", h.Sum32())
+	name := vinov1.ShortenName(vino.Name+"-"+nodeName, validation.DNS1123SubdomainMaxLength-len(hash)-1)
+	return name + "-" + hash
+}
+
+// ownedByOther returns true if the existing VinoBuilderConfig belongs to another vino CR
+func (r *BMHManager) ownedByOther(config *vinov1.VinoBuilderConfig) bool {
+	if config.ResourceVersion == "" {
+		return false
+	}
+	if owner := metav1.GetControllerOf(config); owner != nil && owner.UID != r.ViNO.UID {
+		return true
+	}
+	return config.Labels[vinov1.VinoLabelDSNameSelector] != r.ViNO.Name ||
+		config.Labels[vinov1.VinoLabelDSNamespaceSelector] != r.ViNO.Namespace
+}
+
+// applyBuilderConfig creates or updates VinoBuilderConfig, that requests VMs from vino-builder on the node.
+// The config lives in the namespace of vino CR and is owned by it.
+func (r *BMHManager) applyBuilderConfig(ctx context.Context, request builderRequest) error {
+	config := &vinov1.VinoBuilderConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      BuilderConfigName(r.ViNO, request.nodeName),
+			Namespace: r.ViNO.Namespace,
+		},
+	}
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, config, func() error {
+		// configs of other vino CRs are never adopted
+		if r.ownedByOther(config) {
+			return fmt.Errorf("VinoBuilderConfig %v belongs to another vino CR",
+				client.ObjectKeyFromObject(config))
+		}
+		if config.Labels == nil {
+			config.Labels = make(map[string]string)
+		}
+		for label, value := range r.vinoLabels() {
+			config.Labels[label] = value
+		}
+		config.Labels[vinov1.VinoLabelNodeName] = vinov1.NodeNameLabelValue(request.nodeName)
+		config.OwnerReferences = []metav1.OwnerReference{
+			*metav1.NewControllerRef(r.ViNO, vinov1.GroupVersion.WithKind("Vino")),
+		}
+		config.Spec = vinov1.VinoBuilderConfigSpec{
+			NodeName:  request.nodeName,
+			Builder:   request.builder,
+			Resources: request.resources,
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	r.Logger.Info("Applied vino-builder config", "config", client.ObjectKeyFromObject(config), "result", result)
+	return nil
+}
+
+// deleteBuilderConfigs deletes VinoBuilderConfigs of vino CR, except for configs of the given nodes.
+// Configs of the nodes, that are not named by BuilderConfigName, are deleted too
+func (r *BMHManager) deleteBuilderConfigs(ctx context.Context, keepNodes map[string]struct{}) error {
+	configList := &vinov1.VinoBuilderConfigList{}
+	if err := r.List(ctx, configList,
+		client.MatchingLabels(r.vinoLabels()), client.InNamespace(r.ViNO.Namespace)); err != nil {
+		return err
+	}
+	for i := range configList.Items {
+		config := &configList.Items[i]
+		if _, keep := keepNodes[config.Spec.NodeName]; keep &&
+			config.Name == BuilderConfigName(r.ViNO, config.Spec.NodeName) {
+			continue
+		}
+		r.Logger.Info("Deleting vino-builder config", "config", client.ObjectKeyFromObject(config))
+		if err := r.Delete(ctx, config); err != nil && !apierror.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveDomains requests vino-builders to remove domains of vino CR by removing them from its
+// VinoBuilderConfigs, and returns names of k8s nodes, whose vino-builders haven't realized configs
+// without domains yet. Configs of nodes, that vino-builders don't run on, are not waited for
+func (r *BMHManager) RemoveDomains(ctx context.Context) ([]string, error) {
+	podList, err := r.getPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	running := map[string]struct{}{}
+	for _, pod := range podList.Items {
+		if pod.Spec.NodeName != "" && pod.DeletionTimestamp == nil {
+			running[pod.Spec.NodeName] = struct{}{}
+		}
+	}
+
+	configList := &vinov1.VinoBuilderConfigList{}
+	if err = r.List(ctx, configList,
+		client.MatchingLabels(r.vinoLabels()), client.InNamespace(r.ViNO.Namespace)); err != nil {
+		return nil, err
+	}
+	pending := []string{}
+	for i := range configList.Items {
+		config := &configList.Items[i]
+		if _, ok := running[config.Spec.NodeName]; !ok {
+			continue
+		}
+		if len(config.Spec.Builder.Domains) != 0 {
+			r.Logger.Info("Requesting vino-builder to remove domains", "config", client.ObjectKeyFromObject(config))
+			config.Spec.Builder.Domains = nil
+			config.Spec.Resources = nil
+			if err = r.Update(ctx, config); err != nil {
+				return nil, err
+			}
+			pending = append(pending, config.Spec.NodeName)
+			continue
+		}
+		if !builderConfigBuilt(config) {
+			pending = append(pending, config.Spec.NodeName)
+		}
+	}
+	sort.Strings(pending)
+	return pending, nil
+}
+
+// builderConfigBuilt returns true if vino-builder has realized the current generation of the config
+func builderConfigBuilt(config *vinov1.VinoBuilderConfig) bool {
+	built := apimeta.FindStatusCondition(config.Status.Conditions, vinov1.ConditionTypeBuilt)
+	return config.Status.ObservedGeneration == config.Generation &&
+		built != nil && built.Status == metav1.ConditionTrue && built.ObservedGeneration == config.Generation
+}
+
+// otherBuilderConfigs returns VinoBuilderConfigs of other vino CRs, that request VMs on the node
+func (r *BMHManager) otherBuilderConfigs(ctx context.Context, nodeName string) ([]vinov1.VinoBuilderConfig, error) {
+	configList := &vinov1.VinoBuilderConfigList{}
+	if err := r.List(ctx, configList,
+		client.MatchingLabels{vinov1.VinoLabelNodeName: vinov1.NodeNameLabelValue(nodeName)}); err != nil {
+		return nil, err
+	}
+	others := []vinov1.VinoBuilderConfig{}
+	for _, config := range configList.Items {
+		if config.Labels[vinov1.VinoLabelDSNameSelector] == r.ViNO.Name &&
+			config.Labels[vinov1.VinoLabelDSNamespaceSelector] == r.ViNO.Namespace {
+			continue
+		}
+		others = append(others, config)
+	}
+	return others, nil
+}
+
+// usedVNCPorts returns VNC ports of domains requested by the configs
+func usedVNCPorts(configs []vinov1.VinoBuilderConfig) map[int]struct{} {
+	ports := map[int]struct{}{}
+	for _, config := range configs {
+		for _, domain := range config.Spec.Builder.Domains {
+			if domain.VNCPort != 0 {
+				ports[domain.VNCPort] = struct{}{}
+			}
+		}
+	}
+	return ports
+}
+
+// currentVNCPorts returns VNC ports of domains, that VinoBuilderConfigs of vino CR already request
+// on the node, by domain name
+func (r *BMHManager) currentVNCPorts(ctx context.Context, nodeName string) (map[string]int, error) {
+	labels := r.vinoLabels()
+	labels[vinov1.VinoLabelNodeName] = vinov1.NodeNameLabelValue(nodeName)
+	configList := &vinov1.VinoBuilderConfigList{}
+	if err := r.List(ctx, configList, client.MatchingLabels(labels), client.InNamespace(r.ViNO.Namespace)); err != nil {
+		return nil, err
+	}
+	ports := map[string]int{}
+	for _, config := range configList.Items {
+		for _, domain := range config.Spec.Builder.Domains {
+			if domain.VNCPort != 0 {
+				ports[domain.Name] = domain.VNCPort
+			}
+		}
+	}
+	return ports, nil
+}
+
+// reserveVNCPorts marks current ports of domains as used. Ports, that are already used by other
+// vino CRs, are removed from current ones, so that their domains get new ports
+func reserveVNCPorts(used map[int]struct{}, current map[string]int) {
+	names := make([]string, 0, len(current))
+	for name := range current {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, taken := used[current[name]]; taken {
+			delete(current, name)
+			continue
+		}
+		used[current[name]] = struct{}{}
+	}
+}
+
+// nextVNCPort returns the lowest VNC port, that is not used yet, and marks it as used
+func nextVNCPort(used map[int]struct{}) int {
+	port := vinov1.VinoVNCBasePort
+	for {
+		if _, taken := used[port]; !taken {
+			used[port] = struct{}{}
+			return port
+		}
+		port++
+	}
+}
+
+// usedSubnets returns subnets of networks requested by the configs
+func usedSubnets(configs []vinov1.VinoBuilderConfig) map[string]struct{} {
+	subnets := map[string]struct{}{}
+	for _, config := range configs {
+		for _, network := range config.Spec.Builder.Networks {
+			subnets[network.SubNet] = struct{}{}
+			if network.IPv6 != nil {
+				subnets[network.IPv6.SubNet] = struct{}{}
+			}
+		}
+	}
+	return subnets
+}
@@ -0,0 +1,227 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+func TestBuilderConfigs(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, vinov1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	newManager := func(name string) *BMHManager {
+		vino := &vinov1.Vino{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)}}
+		return &BMHManager{Namespace: "vino-system", Client: c, ViNO: vino, Logger: logr.Discard()}
+	}
+	r := newManager("vino")
+	other := newManager("other")
+
+	builder := vinov1.Builder{NodeCount: 2, Domains: []vinov1.BuilderDomain{{Name: "worker-0"}}}
+	require.NoError(t, r.applyBuilderConfig(ctx, builderRequest{nodeName: "node01", builder: builder}))
+	require.NoError(t, r.applyBuilderConfig(ctx, builderRequest{nodeName: "node02", builder: builder}))
+	require.NoError(t, other.applyBuilderConfig(ctx, builderRequest{nodeName: "node01", builder: builder}))
+
+	// existing config is updated
+	builder.Domains = append(builder.Domains, vinov1.BuilderDomain{Name: "worker-1"})
+	require.NoError(t, r.applyBuilderConfig(ctx, builderRequest{nodeName: "node01", builder: builder}))
+
+	config := &vinov1.VinoBuilderConfig{}
+	key := client.ObjectKey{Name: BuilderConfigName(r.ViNO, "node01"), Namespace: "default"}
+	require.NoError(t, c.Get(ctx, key, config))
+	assert.Equal(t, "node01", config.Spec.NodeName)
+	assert.Len(t, config.Spec.Builder.Domains, 2)
+	assert.Equal(t, "node01", config.Labels[vinov1.VinoLabelNodeName])
+	assert.Equal(t, "vino", config.Labels[vinov1.VinoLabelDSNameSelector])
+	require.Len(t, config.OwnerReferences, 1)
+	assert.Equal(t, r.ViNO.UID, config.OwnerReferences[0].UID)
+
+	// configs of other vino CRs on the node share its VNC ports
+	others, err := r.otherBuilderConfigs(ctx, "node01")
+	require.NoError(t, err)
+	require.Len(t, others, 1)
+	assert.Equal(t, BuilderConfigName(other.ViNO, "node01"), others[0].Name)
+	others[0].Spec.Builder.Domains = []vinov1.BuilderDomain{{VNCPort: 5900}, {VNCPort: 5902}}
+	ports := usedVNCPorts(others)
+	assert.Equal(t, 5901, nextVNCPort(ports))
+	assert.Equal(t, 5903, nextVNCPort(ports))
+
+	// domains keep their VNC ports, when VMs of other vino CRs come and go
+	config.Spec.Builder.Domains = []vinov1.BuilderDomain{{Name: "worker-0", VNCPort: 5901}, {Name: "worker-1"}}
+	require.NoError(t, c.Update(ctx, config))
+	current, err := r.currentVNCPorts(ctx, "node01")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"worker-0": 5901}, current)
+	ports = map[int]struct{}{}
+	reserveVNCPorts(ports, current)
+	assert.Equal(t, 5900, nextVNCPort(ports))
+	assert.Equal(t, 5902, nextVNCPort(ports))
+	assert.Equal(t, map[string]int{"worker-0": 5901}, current)
+
+	// ports taken by other vino CRs are given up
+	ports = map[int]struct{}{5901: {}}
+	reserveVNCPorts(ports, current)
+	assert.Empty(t, current)
+
+	// configs of other vino CRs are not adopted, even if their names are taken
+	taken := config.DeepCopy()
+	taken.ObjectMeta = metav1.ObjectMeta{
+		Name:            BuilderConfigName(r.ViNO, "node03"),
+		Namespace:       "default",
+		Labels:          other.vinoLabels(),
+		OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(other.ViNO, vinov1.GroupVersion.WithKind("Vino"))},
+	}
+	require.NoError(t, c.Create(ctx, taken))
+	assert.Error(t, r.applyBuilderConfig(ctx, builderRequest{nodeName: "node03", builder: builder}))
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(taken), config))
+	assert.Equal(t, other.ViNO.UID, config.OwnerReferences[0].UID)
+	require.NoError(t, c.Delete(ctx, taken))
+
+	// configs named by earlier versions are replaced
+	legacy := config.DeepCopy()
+	legacy.ObjectMeta = metav1.ObjectMeta{Name: "vino-node01", Namespace: "default", Labels: r.vinoLabels()}
+	legacy.Spec.NodeName = "node01"
+	require.NoError(t, c.Create(ctx, legacy))
+
+	// configs of nodes vino-builders no longer run on are deleted
+	require.NoError(t, r.deleteBuilderConfigs(ctx, map[string]struct{}{"node01": {}}))
+	configList := &vinov1.VinoBuilderConfigList{}
+	require.NoError(t, c.List(ctx, configList))
+	names := []string{}
+	for _, item := range configList.Items {
+		names = append(names, item.Name)
+	}
+	assert.ElementsMatch(t, []string{BuilderConfigName(r.ViNO, "node01"), BuilderConfigName(other.ViNO, "node01")}, names)
+
+	// configs of other vino CRs are never deleted
+	require.NoError(t, r.UnScheduleVMs(ctx))
+	require.NoError(t, c.List(ctx, configList))
+	require.Len(t, configList.Items, 1)
+	assert.Equal(t, BuilderConfigName(other.ViNO, "node01"), configList.Items[0].Name)
+}
+
+func TestRemoveDomains(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, vinov1.AddToScheme(scheme))
+
+	vino := &vinov1.Vino{ObjectMeta: metav1.ObjectMeta{Name: "vino", Namespace: "default"}}
+	r := &BMHManager{Namespace: "vino-system", ViNO: vino, Logger: logr.Discard()}
+	pod := func(nodeName string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name: "vino-builder-" + nodeName, Namespace: "vino-system", Labels: r.vinoLabels(), ResourceVersion: "1",
+		}, Spec: corev1.PodSpec{NodeName: nodeName}}
+	}
+	config := func(nodeName string,
+		generation, observed int64,
+		domains ...vinov1.BuilderDomain) *vinov1.VinoBuilderConfig {
+		return &vinov1.VinoBuilderConfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: BuilderConfigName(vino, nodeName), Namespace: "default", Labels: r.vinoLabels(),
+				Generation: generation, ResourceVersion: "1",
+			},
+			Spec: vinov1.VinoBuilderConfigSpec{NodeName: nodeName, Builder: vinov1.Builder{Domains: domains}},
+			Status: vinov1.VinoBuilderConfigStatus{ObservedGeneration: observed, Conditions: []metav1.Condition{{
+				Type: vinov1.ConditionTypeBuilt, Status: metav1.ConditionTrue, ObservedGeneration: observed,
+			}}},
+		}
+	}
+	domain := vinov1.BuilderDomain{Name: "default-vino-node-worker-0"}
+	r.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		pod("node01"), pod("node02"), pod("node03"),
+		config("node01", 1, 1, domain),
+		config("node02", 2, 2),
+		config("node03", 2, 1),
+		config("node04", 1, 1, domain),
+	).Build()
+
+	// vino-builder of node04 is not running, so its domains are not waited for
+	pending, err := r.RemoveDomains(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node01", "node03"}, pending)
+
+	updated := &vinov1.VinoBuilderConfig{}
+	key := func(nodeName string) client.ObjectKey {
+		return client.ObjectKey{Name: BuilderConfigName(vino, nodeName), Namespace: "default"}
+	}
+	require.NoError(t, r.Get(ctx, key("node01"), updated))
+	assert.Empty(t, updated.Spec.Builder.Domains)
+	require.NoError(t, r.Get(ctx, key("node04"), updated))
+	assert.Len(t, updated.Spec.Builder.Domains, 1)
+}
+
+func TestBuilderConfigName(t *testing.T) {
+	vino := func(name string) *vinov1.Vino { return &vinov1.Vino{ObjectMeta: metav1.ObjectMeta{Name: name}} }
+	assert.NotEqual(t, BuilderConfigName(vino("a-b"), "c"), BuilderConfigName(vino("a"), "b-c"))
+	assert.Equal(t, BuilderConfigName(vino("a"), "b-c"), BuilderConfigName(vino("a"), "b-c"))
+	assert.Regexp(t, "^a-b-c-[0-9a-f]{8}$", BuilderConfigName(vino("a"), "b-c"))
+
+	// FQDN of k8s node may be as long as config name can be
+	longName := strings.Repeat("node.example.", 19) + "com"
+	name := BuilderConfigName(vino("vino"), longName)
+	assert.Empty(t, validation.IsDNS1123Subdomain(name))
+	assert.NotEqual(t, name, BuilderConfigName(vino("vino"), longName+"2"))
+}
+
+func TestLongNodeNames(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, vinov1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	vino := &vinov1.Vino{ObjectMeta: metav1.ObjectMeta{Name: "vino", Namespace: "default"}}
+	r := &BMHManager{Namespace: "vino-system", Client: c, ViNO: vino, Logger: logr.Discard()}
+
+	nodeName := strings.Repeat("a", 40) + "." + strings.Repeat("b", 40) + ".example.com"
+	builder := vinov1.Builder{Domains: []vinov1.BuilderDomain{{Name: "worker-0", VNCPort: 5900}}}
+	require.NoError(t, r.applyBuilderConfig(ctx, builderRequest{nodeName: nodeName, builder: builder}))
+
+	config := &vinov1.VinoBuilderConfig{}
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: BuilderConfigName(vino, nodeName), Namespace: "default"}, config))
+	labelValue := config.Labels[vinov1.VinoLabelNodeName]
+	assert.Empty(t, validation.IsValidLabelValue(labelValue))
+	assert.Equal(t, vinov1.NodeNameLabelValue(nodeName), labelValue)
+	assert.NotEqual(t, labelValue, vinov1.NodeNameLabelValue(nodeName+"m"))
+	assert.Equal(t, nodeName, config.Spec.NodeName)
+
+	// configs of the node are found by the label
+	current, err := r.currentVNCPorts(ctx, nodeName)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"worker-0": 5900}, current)
+	other := &BMHManager{Client: c, ViNO: &vinov1.Vino{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}}}
+	others, err := other.otherBuilderConfigs(ctx, nodeName)
+	require.NoError(t, err)
+	assert.Len(t, others, 1)
+
+	// short node names are kept as they are
+	assert.Equal(t, "node01", vinov1.NodeNameLabelValue("node01"))
+}
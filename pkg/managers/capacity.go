@@ -0,0 +1,259 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+
+	vinov1 "vino/pkg/api/v1"
+	"vino/pkg/inventory"
+	"vino/pkg/libvirt"
+)
+
+// CapacityError is returned, if VMs requested on a k8s node don't fit resources of the node
+type CapacityError struct {
+	Node   string
+	Reason string
+}
+
+func (e *CapacityError) Error() string {
+	return fmt.Sprintf("node %s doesn't have capacity for VMs: %s", e.Node, e.Reason)
+}
+
+// hostInventory returns inventory of the host published by nodelabeler in node annotation, nil
+// if it is not published yet
+func hostInventory(node *corev1.Node) (*vinov1.HostInventory, error) {
+	data, ok := node.Annotations[vinov1.VinoHostInventoryAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	hostInventory := &vinov1.HostInventory{}
+	if err := json.Unmarshal([]byte(data), hostInventory); err != nil {
+		return nil, fmt.Errorf("failed to parse annotation '%s' of node %s: %w",
+			vinov1.VinoHostInventoryAnnotation, node.Name, err)
+	}
+	return hostInventory, nil
+}
+
+// sizedDomain is a domain with its resolved sizing
+type sizedDomain struct {
+	name   string
+	flavor vinov1.VinoFlavorSpec
+}
+
+// sizedDomains returns domains with their sizing, domains of unknown sizing are skipped
+func (r *BMHManager) sizedDomains(domains []vinov1.BuilderDomain) []sizedDomain {
+	sized := make([]sizedDomain, 0, len(domains))
+	for _, domain := range domains {
+		if domain.Flavor != nil {
+			sized = append(sized, sizedDomain{name: domain.Name, flavor: *domain.Flavor})
+			continue
+		}
+		// flavors of config map are always pinned to dedicated cores by vino-builder
+		if f, ok := r.flavors[domain.Role]; ok {
+			sized = append(sized, sizedDomain{
+				name: domain.Name,
+				flavor: vinov1.VinoFlavorSpec{
+					VCPUs:     f.VCPUs,
+					Memory:    f.Memory,
+					RootSize:  f.RootSize,
+					HugePages: f.HugePages,
+				},
+			})
+		}
+	}
+	return sized
+}
+
+// checkHostCapacity returns CapacityError, if the domains together with domains of other vino CRs
+// on the node don't fit CPUs, memory, huge pages or storage of the host. Capacity is not checked,
+// if nodelabeler hasn't published host inventory of the node yet
+func (r *BMHManager) checkHostCapacity(node *corev1.Node,
+	domains []vinov1.BuilderDomain,
+	others []vinov1.VinoBuilderConfig) error {
+	hostInventory, err := hostInventory(node)
+	if err != nil {
+		return err
+	}
+	if hostInventory == nil {
+		r.Logger.Info("Host inventory of node is not published, host capacity is not checked", "node", node.Name)
+		return nil
+	}
+
+	excluded := []string{r.ViNO.Spec.CPUConfiguration.CPUExclude}
+	all := append([]vinov1.BuilderDomain{}, domains...)
+	for _, other := range others {
+		excluded = append(excluded, other.Spec.Builder.CPUConfiguration.CPUExclude)
+		all = append(all, other.Spec.Builder.Domains...)
+	}
+	sized := r.sizedDomains(all)
+
+	reason, err := checkCPUs(hostInventory, excluded, sized)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		reason = checkMemory(hostInventory, sized)
+	}
+	if reason == "" {
+		reason = checkStorage(hostInventory, sized)
+	}
+	if reason != "" {
+		return &CapacityError{Node: node.Name, Reason: reason}
+	}
+	return nil
+}
+
+// checkCPUs assigns dedicated cores of every pinned domain on a single NUMA node, as vino-builder
+// does, and returns the reason domains don't fit the cores, that are not excluded
+func checkCPUs(hostInventory *vinov1.HostInventory, excluded []string, domains []sizedDomain) (string, error) {
+	excludedCPUs := map[int]struct{}{}
+	for _, list := range excluded {
+		cpus, err := inventory.ParseCPUList(list)
+		if err != nil {
+			return "", err
+		}
+		for _, cpu := range cpus {
+			excludedCPUs[cpu] = struct{}{}
+		}
+	}
+	free := map[int]int64{}
+	for _, node := range hostInventory.NUMANodes {
+		cpus, err := inventory.ParseCPUList(node.CPUs)
+		if err != nil {
+			return "", err
+		}
+		for _, cpu := range cpus {
+			if _, ok := excludedCPUs[cpu]; !ok {
+				free[node.ID]++
+			}
+		}
+	}
+
+	pinned := []sizedDomain{}
+	for _, domain := range domains {
+		if domain.flavor.CPUPinning != vinov1.CPUPinningNone {
+			pinned = append(pinned, domain)
+		}
+	}
+	// domains restricted to a NUMA node take their cores first, bigger domains go before smaller
+	sort.Slice(pinned, func(i, j int) bool {
+		a, b := pinned[i].flavor, pinned[j].flavor
+		if (a.NUMANode != nil) != (b.NUMANode != nil) {
+			return a.NUMANode != nil
+		}
+		if a.VCPUs != b.VCPUs {
+			return a.VCPUs > b.VCPUs
+		}
+		return pinned[i].name < pinned[j].name
+	})
+
+	for _, domain := range pinned {
+		vcpus := domain.flavor.VCPUs
+		if numaNode := domain.flavor.NUMANode; numaNode != nil {
+			if free[*numaNode] < vcpus {
+				return fmt.Sprintf("VM %s needs %d dedicated cores on NUMA node %d, %d are free",
+					domain.name, vcpus, *numaNode, free[*numaNode]), nil
+			}
+			free[*numaNode] -= vcpus
+			continue
+		}
+		best := -1
+		for _, node := range hostInventory.NUMANodes {
+			if free[node.ID] >= vcpus {
+				best = node.ID
+				break
+			}
+		}
+		if best < 0 {
+			return fmt.Sprintf("VM %s needs %d dedicated cores on one NUMA node, at most %d are free",
+				domain.name, vcpus, maxFree(free)), nil
+		}
+		free[best] -= vcpus
+	}
+	return "", nil
+}
+
+// maxFree returns the highest number of free cores of a NUMA node
+func maxFree(free map[int]int64) int64 {
+	var max int64
+	for _, cores := range free {
+		if cores > max {
+			max = cores
+		}
+	}
+	return max
+}
+
+// checkMemory returns the reason domains don't fit memory of the host. Memory of domains backed
+// by huge pages is taken from huge pages, of NUMA node they are restricted to, other domains share
+// the memory, that is not reserved for huge pages
+func checkMemory(hostInventory *vinov1.HostInventory, domains []sizedDomain) string {
+	hugePages := map[int]int64{}
+	var totalHugePages, memoryMiB int64
+	for _, node := range hostInventory.NUMANodes {
+		hugePages[node.ID] = node.HugePages
+		totalHugePages += node.HugePages
+		memoryMiB += node.MemoryMiB
+	}
+
+	usedHugePages := map[int]int64{}
+	var totalUsedHugePages, usedMemoryMiB int64
+	for _, domain := range domains {
+		if !domain.flavor.HugePages {
+			usedMemoryMiB += domain.flavor.Memory << 10
+			continue
+		}
+		totalUsedHugePages += domain.flavor.Memory
+		if numaNode := domain.flavor.NUMANode; numaNode != nil {
+			usedHugePages[*numaNode] += domain.flavor.Memory
+			if usedHugePages[*numaNode] > hugePages[*numaNode] {
+				return fmt.Sprintf("VMs need %d 1GiB huge pages on NUMA node %d, %d are reserved",
+					usedHugePages[*numaNode], *numaNode, hugePages[*numaNode])
+			}
+		}
+	}
+	if totalUsedHugePages > totalHugePages {
+		return fmt.Sprintf("VMs need %d 1GiB huge pages, %d are reserved", totalUsedHugePages, totalHugePages)
+	}
+	if available := memoryMiB - totalHugePages<<10; usedMemoryMiB > available {
+		return fmt.Sprintf("VMs need %dMiB of memory, %dMiB are not reserved for huge pages",
+			usedMemoryMiB, available)
+	}
+	return ""
+}
+
+// checkStorage returns the reason root disks of domains don't fit the default storage pool. Storage
+// is not checked, if the pool is not in host inventory
+func checkStorage(hostInventory *vinov1.HostInventory, domains []sizedDomain) string {
+	for _, pool := range hostInventory.StoragePools {
+		if pool.Name != libvirt.DefaultStoragePool {
+			continue
+		}
+		var rootSize int64
+		for _, domain := range domains {
+			rootSize += domain.flavor.RootSize
+		}
+		if rootSize > pool.CapacityGiB {
+			return fmt.Sprintf("VMs need %dGiB of storage pool %s, its capacity is %dGiB",
+				rootSize, pool.Name, pool.CapacityGiB)
+		}
+	}
+	return ""
+}
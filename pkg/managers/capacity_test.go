@@ -0,0 +1,167 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+func TestCheckHostCapacity(t *testing.T) {
+	vino := &vinov1.Vino{Spec: vinov1.VinoSpec{CPUConfiguration: vinov1.CPUConfiguration{CPUExclude: "0,8"}}}
+	r := &BMHManager{ViNO: vino, Logger: logr.Discard(), flavors: map[string]flavor{
+		"master":  {VCPUs: 2, Memory: 4},
+		"storage": {VCPUs: 1, Memory: 1, RootSize: 60},
+		"hp":      {VCPUs: 1, Memory: 6, HugePages: true},
+	}}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node01"}}
+
+	// capacity is not checked until host inventory is published
+	domains := []vinov1.BuilderDomain{{Name: "master-0", Role: "master", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 64}}}
+	require.NoError(t, r.checkHostCapacity(node, domains, nil))
+
+	numaNode := func(id int) *int { return &id }
+	data, err := json.Marshal(vinov1.HostInventory{
+		NUMANodes: []vinov1.NUMANodeInventory{
+			// 7 cores of every NUMA node are left after CPUExclude
+			{ID: 0, CPUs: "0-7", MemoryMiB: 32768, HugePages: 8},
+			{ID: 1, CPUs: "8-15", MemoryMiB: 32768},
+		},
+		StoragePools: []vinov1.StoragePoolInventory{{Name: "vino-default", CapacityGiB: 100}},
+	})
+	require.NoError(t, err)
+	node.Annotations = map[string]string{vinov1.VinoHostInventoryAnnotation: string(data)}
+
+	tests := []struct {
+		name     string
+		domains  []vinov1.BuilderDomain
+		others   []vinov1.VinoBuilderConfig
+		expected string
+	}{
+		{
+			name: "fits host",
+			domains: []vinov1.BuilderDomain{
+				{Name: "master-0", Role: "master"},
+				{Name: "worker-0", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 5, Memory: 8, RootSize: 50}},
+				{Name: "worker-1", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 5, Memory: 8, RootSize: 50}},
+				{Name: "hp-0", Flavor: &vinov1.VinoFlavorSpec{
+					VCPUs: 2, Memory: 8, HugePages: true, NUMANode: numaNode(0)}},
+				{Name: "floating-0", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 16, CPUPinning: vinov1.CPUPinningNone}},
+			},
+		},
+		{
+			name: "cores of a VM are taken from one NUMA node",
+			domains: []vinov1.BuilderDomain{
+				{Name: "worker-0", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 4}},
+				{Name: "worker-1", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 4}},
+				{Name: "worker-2", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 4}},
+			},
+			expected: "VM worker-2 needs 4 dedicated cores on one NUMA node, at most 3 are free",
+		},
+		{
+			name:    "cores of other vino CRs and their excluded cores are taken into account",
+			domains: []vinov1.BuilderDomain{{Name: "master-0", Role: "master", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 6}}},
+			others: []vinov1.VinoBuilderConfig{{Spec: vinov1.VinoBuilderConfigSpec{Builder: vinov1.Builder{
+				CPUConfiguration: vinov1.CPUConfiguration{CPUExclude: "1-2"},
+				Domains:          []vinov1.BuilderDomain{{Name: "other-0", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 6}}},
+			}}}},
+			expected: "VM other-0 needs 6 dedicated cores on one NUMA node, at most 5 are free",
+		},
+		{
+			name: "VM restricted to a NUMA node",
+			domains: []vinov1.BuilderDomain{
+				{Name: "worker-0", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 6}},
+				{Name: "numa-0", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 2, NUMANode: numaNode(1)}},
+				{Name: "numa-1", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 2, NUMANode: numaNode(1)}},
+				{Name: "numa-2", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 2, NUMANode: numaNode(1)}},
+				{Name: "numa-3", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 2, NUMANode: numaNode(1)}},
+			},
+			expected: "VM numa-3 needs 2 dedicated cores on NUMA node 1, 1 are free",
+		},
+		{
+			name: "huge pages of a NUMA node",
+			domains: []vinov1.BuilderDomain{{Name: "hp-0", Flavor: &vinov1.VinoFlavorSpec{
+				VCPUs: 1, Memory: 4, HugePages: true, NUMANode: numaNode(1)}}},
+			expected: "VMs need 4 1GiB huge pages on NUMA node 1, 0 are reserved",
+		},
+		{
+			name: "huge pages of the host",
+			domains: []vinov1.BuilderDomain{
+				{Name: "hp-0", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 1, Memory: 6, HugePages: true}},
+				{Name: "hp-1", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 1, Memory: 6, HugePages: true}},
+			},
+			expected: "VMs need 12 1GiB huge pages, 8 are reserved",
+		},
+		{
+			name: "memory is not reserved for huge pages",
+			domains: []vinov1.BuilderDomain{
+				{Name: "worker-0", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 1, Memory: 32}},
+				{Name: "worker-1", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 1, Memory: 32}},
+			},
+			expected: "VMs need 65536MiB of memory, 57344MiB are not reserved for huge pages",
+		},
+		{
+			name: "huge pages of config map flavors",
+			domains: []vinov1.BuilderDomain{
+				{Name: "hp-0", Role: "hp"},
+				{Name: "hp-1", Role: "hp"},
+			},
+			expected: "VMs need 12 1GiB huge pages, 8 are reserved",
+		},
+		{
+			name: "storage of config map flavors",
+			domains: []vinov1.BuilderDomain{
+				{Name: "storage-0", Role: "storage"},
+				{Name: "storage-1", Role: "storage"},
+			},
+			expected: "VMs need 120GiB of storage pool vino-default, its capacity is 100GiB",
+		},
+		{
+			name: "storage pool",
+			domains: []vinov1.BuilderDomain{
+				{Name: "worker-0", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 1, Memory: 1, RootSize: 60}},
+				{Name: "worker-1", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 1, Memory: 1, RootSize: 60}},
+			},
+			expected: "VMs need 120GiB of storage pool vino-default, its capacity is 100GiB",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.checkHostCapacity(node, tt.domains, tt.others)
+			if tt.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			capacityErr := &CapacityError{}
+			require.True(t, errors.As(err, &capacityErr))
+			assert.Equal(t, &CapacityError{Node: "node01", Reason: tt.expected}, capacityErr)
+		})
+	}
+
+	node.Annotations[vinov1.VinoHostInventoryAnnotation] = "{"
+	err = r.checkHostCapacity(node, nil, nil)
+	require.Error(t, err)
+	assert.False(t, errors.As(err, new(*CapacityError)))
+}
@@ -0,0 +1,282 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+// BMCCredentialsSecretName returns name of the secret in the runtime namespace, that holds a copy
+// of BMC credentials referenced by vino CR. vino-builder pods consume credentials from this secret,
+// because pods can't reference secrets from other namespaces.
+func BMCCredentialsSecretName(vino *vinov1.Vino) string {
+	return fmt.Sprintf("%s-%s-bmc-credentials", vino.Namespace, vino.Name)
+}
+
+// GetBMCCredentials returns BMC username and password of vino CR. If credentialsSecretRef is set,
+// credentials are read from the referenced secret, deprecated plain text credentials are used otherwise
+func GetBMCCredentials(ctx context.Context, c client.Client, vino *vinov1.Vino) (string, string, error) {
+	ref := vino.Spec.BMCCredentials.CredentialsSecretRef
+	if ref == nil {
+		return vino.Spec.BMCCredentials.Username, vino.Spec.BMCCredentials.Password, nil
+	}
+
+	key := client.ObjectKey{Name: ref.Name, Namespace: ref.Namespace}
+	if key.Namespace == "" {
+		key.Namespace = vino.Namespace
+	}
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, key, secret); err != nil {
+		return "", "", err
+	}
+	username, ok := secret.Data[vinov1.VinoBMCCredentialsUsernameKey]
+	if !ok {
+		return "", "", fmt.Errorf("BMC credentials secret %v has no key '%s'", key, vinov1.VinoBMCCredentialsUsernameKey)
+	}
+	password, ok := secret.Data[vinov1.VinoBMCCredentialsPasswordKey]
+	if !ok {
+		return "", "", fmt.Errorf("BMC credentials secret %v has no key '%s'", key, vinov1.VinoBMCCredentialsPasswordKey)
+	}
+	return string(username), string(password), nil
+}
+
+const (
+	// CredentialsPropagationDelay is the time given to kubelets to update htpasswd file mounted to sushy,
+	// before BMHs switch to new credentials and before old credentials are revoked
+	CredentialsPropagationDelay = 2 * time.Minute
+	// SushyConfigDir is a directory in sushy container, htpasswd secret is mounted to
+	SushyConfigDir = "/etc/vino-sushy"
+	// SushyConfigKey is a key of sushy emulator configuration file in htpasswd secret
+	SushyConfigKey = "emulator.conf"
+
+	// keys of BMH credentials secret, that hold generated credentials during rotation
+	pendingUsernameKey  = "pendingUsername"
+	pendingPasswordKey  = "pendingPassword" //nolint:gosec
+	pendingHtpasswdKey  = "pendingHtpasswd"
+	previousHtpasswdKey = "previousHtpasswd"
+
+	generatedPasswordBytes = 18
+	generatedSuffixBytes   = 4
+)
+
+// rotationStep is a step of generated credentials rotation, that is applied to BMH credentials secrets
+type rotationStep int
+
+const (
+	rotationNone rotationStep = iota
+	// rotationStart new credentials are generated and added to sushy
+	rotationStart
+	// rotationSwitch BMHs switch to new credentials, old ones are still accepted by sushy
+	rotationSwitch
+	// rotationFinish old credentials are removed from sushy
+	rotationFinish
+)
+
+// HtpasswdSecretName returns name of the secret in the runtime namespace, that holds htpasswd file
+// with generated credentials of all VMs of vino CR. The secret is mounted to sushy containers.
+func HtpasswdSecretName(vino *vinov1.Vino) string {
+	return fmt.Sprintf("%s-%s-bmc-htpasswd", vino.Namespace, vino.Name)
+}
+
+// EnsureHtpasswdSecret creates htpasswd secret without any credentials, unless it already exists,
+// so that sushy can start before credentials of VMs are generated
+func EnsureHtpasswdSecret(ctx context.Context, c client.Client, namespace string, vino *vinov1.Vino) error {
+	return ensureSecret(ctx, c, newHtpasswdSecret(vino, namespace, nil))
+}
+
+// newHtpasswdSecret returns htpasswd secret, that lists current, pending and previous credentials
+// from BMH credentials secrets, and sushy configuration, that enables authentication with it
+func newHtpasswdSecret(vino *vinov1.Vino, namespace string, bmhSecrets []*corev1.Secret) *corev1.Secret {
+	entries := []string{}
+	for _, secret := range bmhSecrets {
+		for _, key := range []string{vinov1.VinoBMCHtpasswdKey, pendingHtpasswdKey, previousHtpasswdKey} {
+			if entry, ok := secret.Data[key]; ok {
+				entries = append(entries, string(entry)+"\n")
+			}
+		}
+	}
+	sort.Strings(entries)
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      HtpasswdSecretName(vino),
+			Namespace: namespace,
+			Labels:    vinoLabels(vino),
+		},
+		Data: map[string][]byte{
+			vinov1.VinoBMCHtpasswdKey: []byte(strings.Join(entries, "")),
+			SushyConfigKey: []byte(fmt.Sprintf("SUSHY_EMULATOR_AUTH_FILE = '%s/%s'\n",
+				SushyConfigDir, vinov1.VinoBMCHtpasswdKey)),
+		},
+		Type: corev1.SecretTypeOpaque,
+	}
+}
+
+// ensureSecret creates the secret, unless a secret with the same name already exists
+func ensureSecret(ctx context.Context, c client.Client, secret *corev1.Secret) error {
+	err := c.Get(ctx, client.ObjectKeyFromObject(secret), &corev1.Secret{})
+	if apierror.IsNotFound(err) {
+		return c.Create(ctx, secret)
+	}
+	return err
+}
+
+// applySecret creates the secret or updates data and labels of the existing one
+func applySecret(ctx context.Context, c client.Client, secret *corev1.Secret) error {
+	existing := &corev1.Secret{}
+	err := c.Get(ctx, client.ObjectKeyFromObject(secret), existing)
+	switch {
+	case apierror.IsNotFound(err):
+		return c.Create(ctx, secret)
+	case err != nil:
+		return err
+	}
+	if existing.Labels == nil {
+		existing.Labels = make(map[string]string)
+	}
+	for label, value := range secret.Labels {
+		existing.Labels[label] = value
+	}
+	existing.Data = secret.Data
+	existing.StringData = nil
+	return c.Update(ctx, existing)
+}
+
+// nextRotationStep returns the step of credentials rotation, that should be applied during current
+// reconciliation. Rotation starts when rotation annotation differs from the observed one, every
+// following step is taken when CredentialsPropagationDelay has passed since the previous one.
+func nextRotationStep(vino *vinov1.Vino) rotationStep {
+	status := vino.Status.Credentials
+	propagated := status.RotationPhaseTransitionTime == nil ||
+		time.Since(status.RotationPhaseTransitionTime.Time) >= CredentialsPropagationDelay
+	switch status.RotationPhase {
+	case "":
+		if vino.Annotations[vinov1.VinoRotateCredentialsAnnotation] != status.ObservedRotation {
+			return rotationStart
+		}
+	case vinov1.CredentialsRotationSushyUpdated:
+		if propagated {
+			return rotationSwitch
+		}
+	case vinov1.CredentialsRotationBMHsUpdated:
+		if propagated {
+			return rotationFinish
+		}
+	}
+	return rotationNone
+}
+
+// advanceRotation records in vino status, that the step has been applied to all credentials
+func advanceRotation(vino *vinov1.Vino, step rotationStep) {
+	status := &vino.Status.Credentials
+	now := metav1.Now()
+	switch step {
+	case rotationStart:
+		status.ObservedRotation = vino.Annotations[vinov1.VinoRotateCredentialsAnnotation]
+		status.RotationPhase = vinov1.CredentialsRotationSushyUpdated
+		status.RotationPhaseTransitionTime = &now
+	case rotationSwitch:
+		status.RotationPhase = vinov1.CredentialsRotationBMHsUpdated
+		status.RotationPhaseTransitionTime = &now
+	case rotationFinish:
+		status.RotationPhase = ""
+		status.RotationPhaseTransitionTime = nil
+	}
+}
+
+// rotateBMHCredentials returns data of BMH credentials secret with the rotation step applied to it.
+// Credentials are generated if the secret has none yet, existing credentials are never changed
+// outside of rotation.
+func rotateBMHCredentials(bmhName string, data map[string][]byte, step rotationStep) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(data))
+	for key, value := range data {
+		result[key] = value
+	}
+
+	if len(result[vinov1.VinoBMCCredentialsUsernameKey]) == 0 || len(result[vinov1.VinoBMCHtpasswdKey]) == 0 {
+		if err := generateCredentials(bmhName, result, vinov1.VinoBMCCredentialsUsernameKey,
+			vinov1.VinoBMCCredentialsPasswordKey, vinov1.VinoBMCHtpasswdKey); err != nil {
+			return nil, err
+		}
+	}
+
+	switch step {
+	case rotationStart:
+		if _, pending := result[pendingUsernameKey]; !pending {
+			if err := generateCredentials(bmhName, result,
+				pendingUsernameKey, pendingPasswordKey, pendingHtpasswdKey); err != nil {
+				return nil, err
+			}
+		}
+	case rotationSwitch:
+		if _, pending := result[pendingUsernameKey]; pending {
+			result[previousHtpasswdKey] = result[vinov1.VinoBMCHtpasswdKey]
+			result[vinov1.VinoBMCCredentialsUsernameKey] = result[pendingUsernameKey]
+			result[vinov1.VinoBMCCredentialsPasswordKey] = result[pendingPasswordKey]
+			result[vinov1.VinoBMCHtpasswdKey] = result[pendingHtpasswdKey]
+			delete(result, pendingUsernameKey)
+			delete(result, pendingPasswordKey)
+			delete(result, pendingHtpasswdKey)
+		}
+	case rotationFinish:
+		delete(result, previousHtpasswdKey)
+	}
+	return result, nil
+}
+
+// generateCredentials stores random credentials of the VM and their htpasswd entry under the given keys
+func generateCredentials(bmhName string, data map[string][]byte, usernameKey, passwordKey, htpasswdKey string) error {
+	suffix := make([]byte, generatedSuffixBytes)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	password, err := randomString(generatedPasswordBytes)
+	if err != nil {
+		return err
+	}
+	// username changes on every rotation too, htpasswd file can't hold two entries for one user
+	username := fmt.Sprintf("%s-%s", bmhName, hex.EncodeToString(suffix))
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	data[usernameKey] = []byte(username)
+	data[passwordKey] = []byte(password)
+	data[htpasswdKey] = []byte(fmt.Sprintf("%s:%s", username, hash))
+	return nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
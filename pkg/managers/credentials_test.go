@@ -0,0 +1,251 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+// requireHtpasswdEntry checks that htpasswd entry under the key matches credentials under the other keys
+func requireHtpasswdEntry(t *testing.T, data map[string][]byte, usernameKey, passwordKey, htpasswdKey string) {
+	entry := strings.SplitN(string(data[htpasswdKey]), ":", 2)
+	require.Len(t, entry, 2)
+	require.Equal(t, string(data[usernameKey]), entry[0])
+	require.NoError(t, bcrypt.CompareHashAndPassword([]byte(entry[1]), data[passwordKey]))
+}
+
+func TestRotateBMHCredentials(t *testing.T) {
+	const bmhName = "default-vino-worker-0"
+	usernameKey := vinov1.VinoBMCCredentialsUsernameKey
+	passwordKey := vinov1.VinoBMCCredentialsPasswordKey
+	htpasswdKey := vinov1.VinoBMCHtpasswdKey
+
+	generated, err := rotateBMHCredentials(bmhName, nil, rotationNone)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(generated[usernameKey]), bmhName+"-"))
+	requireHtpasswdEntry(t, generated, usernameKey, passwordKey, htpasswdKey)
+
+	// existing credentials are kept outside of rotation
+	kept, err := rotateBMHCredentials(bmhName, generated, rotationNone)
+	require.NoError(t, err)
+	assert.Equal(t, generated, kept)
+
+	started, err := rotateBMHCredentials(bmhName, kept, rotationStart)
+	require.NoError(t, err)
+	assert.Equal(t, generated[usernameKey], started[usernameKey])
+	assert.NotEqual(t, generated[usernameKey], started[pendingUsernameKey])
+	requireHtpasswdEntry(t, started, pendingUsernameKey, pendingPasswordKey, pendingHtpasswdKey)
+
+	// repeated step doesn't generate pending credentials again
+	restarted, err := rotateBMHCredentials(bmhName, started, rotationStart)
+	require.NoError(t, err)
+	assert.Equal(t, started, restarted)
+
+	switched, err := rotateBMHCredentials(bmhName, started, rotationSwitch)
+	require.NoError(t, err)
+	assert.Equal(t, started[pendingUsernameKey], switched[usernameKey])
+	assert.Equal(t, started[pendingPasswordKey], switched[passwordKey])
+	assert.Equal(t, generated[htpasswdKey], switched[previousHtpasswdKey])
+	assert.NotContains(t, switched, pendingUsernameKey)
+	requireHtpasswdEntry(t, switched, usernameKey, passwordKey, htpasswdKey)
+
+	finished, err := rotateBMHCredentials(bmhName, switched, rotationFinish)
+	require.NoError(t, err)
+	assert.NotContains(t, finished, previousHtpasswdKey)
+	assert.Equal(t, switched[usernameKey], finished[usernameKey])
+}
+
+func TestNextRotationStep(t *testing.T) {
+	longAgo := metav1.NewTime(time.Now().Add(-2 * CredentialsPropagationDelay))
+	justNow := metav1.Now()
+	tests := []struct {
+		name       string
+		annotation string
+		status     vinov1.CredentialsStatus
+		expected   rotationStep
+	}{
+		{
+			name:     "no rotation requested",
+			expected: rotationNone,
+		},
+		{
+			name:       "rotation already observed",
+			annotation: "1",
+			status:     vinov1.CredentialsStatus{ObservedRotation: "1"},
+			expected:   rotationNone,
+		},
+		{
+			name:       "new rotation requested",
+			annotation: "2",
+			status:     vinov1.CredentialsStatus{ObservedRotation: "1"},
+			expected:   rotationStart,
+		},
+		{
+			name:       "sushy update is propagating",
+			annotation: "2",
+			status: vinov1.CredentialsStatus{
+				ObservedRotation:            "2",
+				RotationPhase:               vinov1.CredentialsRotationSushyUpdated,
+				RotationPhaseTransitionTime: &justNow,
+			},
+			expected: rotationNone,
+		},
+		{
+			name:       "sushy update has propagated",
+			annotation: "2",
+			status: vinov1.CredentialsStatus{
+				ObservedRotation:            "2",
+				RotationPhase:               vinov1.CredentialsRotationSushyUpdated,
+				RotationPhaseTransitionTime: &longAgo,
+			},
+			expected: rotationSwitch,
+		},
+		{
+			name:       "BMHs have switched",
+			annotation: "3",
+			status: vinov1.CredentialsStatus{
+				ObservedRotation:            "2",
+				RotationPhase:               vinov1.CredentialsRotationBMHsUpdated,
+				RotationPhaseTransitionTime: &longAgo,
+			},
+			expected: rotationFinish,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			vino := &vinov1.Vino{}
+			vino.Annotations = map[string]string{vinov1.VinoRotateCredentialsAnnotation: tt.annotation}
+			vino.Status.Credentials = tt.status
+			assert.Equal(t, tt.expected, nextRotationStep(vino))
+		})
+	}
+}
+
+func TestNewHtpasswdSecret(t *testing.T) {
+	vino := &vinov1.Vino{ObjectMeta: metav1.ObjectMeta{Name: "vino", Namespace: "default"}}
+	secrets := []*corev1.Secret{
+		{Data: map[string][]byte{vinov1.VinoBMCHtpasswdKey: []byte("b:hash")}},
+		{Data: map[string][]byte{
+			vinov1.VinoBMCHtpasswdKey: []byte("a:hash"),
+			previousHtpasswdKey:       []byte("c:hash"),
+		}},
+	}
+
+	secret := newHtpasswdSecret(vino, "vino-system", secrets)
+	assert.Equal(t, "default-vino-bmc-htpasswd", secret.Name)
+	assert.Equal(t, "vino-system", secret.Namespace)
+	assert.Equal(t, "a:hash\nb:hash\nc:hash\n", string(secret.Data[vinov1.VinoBMCHtpasswdKey]))
+	assert.Contains(t, string(secret.Data[SushyConfigKey]), SushyConfigDir+"/"+vinov1.VinoBMCHtpasswdKey)
+}
+
+func TestApplyReferencedBMCCredentials(t *testing.T) {
+	const bmhName = "default-vino-node01-worker-0"
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, vinov1.AddToScheme(scheme))
+	require.NoError(t, metal3.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	vino := &vinov1.Vino{
+		ObjectMeta: metav1.ObjectMeta{Name: "vino", Namespace: "default"},
+		Spec: vinov1.VinoSpec{BMCCredentials: vinov1.BMCCredentials{
+			CredentialsSecretRef: &vinov1.NamespacedName{Name: "bmc"},
+		}},
+	}
+	referenced := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "bmc", Namespace: "default", ResourceVersion: "1"},
+		Data: map[string][]byte{
+			vinov1.VinoBMCCredentialsUsernameKey: []byte("admin"),
+			vinov1.VinoBMCCredentialsPasswordKey: []byte("old"),
+		},
+	}
+	config := &vinov1.VinoBuilderConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            BuilderConfigName(vino, "node01"),
+			Namespace:       "default",
+			Labels:          vinoLabels(vino),
+			ResourceVersion: "1",
+		},
+		Spec: vinov1.VinoBuilderConfigSpec{NodeName: "node01"},
+		Status: vinov1.VinoBuilderConfigStatus{Domains: []vinov1.DomainStatus{
+			{Name: bmhName, State: vinov1.DomainStateRunning},
+		}},
+	}
+	// BMH is in use, its consumer must survive updates of vino managed fields
+	consumer := &corev1.ObjectReference{Kind: "Machine", Name: "machine-0"}
+	existingBMH := &metal3.BareMetalHost{
+		ObjectMeta: metav1.ObjectMeta{Name: bmhName, Namespace: "vino-system", ResourceVersion: "1"},
+		Spec:       metal3.BareMetalHostSpec{ConsumerRef: consumer, BootMACAddress: "52:54:00:00:00:01"},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(referenced, config, existingBMH).Build()
+
+	createBMHs := func() {
+		r := &BMHManager{Namespace: "vino-system", Client: c, ViNO: vino, Logger: logr.Discard()}
+		var err error
+		r.bmcUsername, r.bmcPassword, err = GetBMCCredentials(ctx, c, vino)
+		require.NoError(t, err)
+		credName, err := r.setBMHCredentials(ctx, bmhName)
+		require.NoError(t, err)
+		r.bmhList = []*metal3.BareMetalHost{{
+			ObjectMeta: metav1.ObjectMeta{Name: bmhName, Namespace: "vino-system", Labels: vinoLabels(vino)},
+			Spec: metal3.BareMetalHostSpec{
+				BMC:            metal3.BMCDetails{CredentialsName: credName},
+				BootMACAddress: "52:54:00:00:00:02",
+			},
+		}}
+		r.nodeStatuses = []vinov1.NodeStatus{{Name: "node01", VMs: []vinov1.VMStatus{{BMHName: bmhName}}}}
+		require.NoError(t, r.CreateBMHs(ctx))
+	}
+	bmhSecret := func() *corev1.Secret {
+		secret := &corev1.Secret{}
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Name: bmhName + "-credentials", Namespace: "vino-system"}, secret))
+		return secret
+	}
+
+	createBMHs()
+	assert.Equal(t, []byte("old"), bmhSecret().Data[vinov1.VinoBMCCredentialsPasswordKey])
+
+	// password changed in the referenced secret reaches BMH credentials
+	referenced.Data[vinov1.VinoBMCCredentialsPasswordKey] = []byte("new")
+	require.NoError(t, c.Update(ctx, referenced))
+	createBMHs()
+	secret := bmhSecret()
+	assert.Equal(t, []byte("admin"), secret.Data[vinov1.VinoBMCCredentialsUsernameKey])
+	assert.Equal(t, []byte("new"), secret.Data[vinov1.VinoBMCCredentialsPasswordKey])
+
+	bmh := &metal3.BareMetalHost{}
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(existingBMH), bmh))
+	assert.Equal(t, bmhName+"-credentials", bmh.Spec.BMC.CredentialsName)
+	assert.Equal(t, "52:54:00:00:00:02", bmh.Spec.BootMACAddress)
+	assert.Equal(t, consumer, bmh.Spec.ConsumerRef)
+	assert.Equal(t, vinoLabels(vino), bmh.Labels)
+}
@@ -0,0 +1,312 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+// totalPlacement returns true if Count of the node set is the total number of its VMs
+func totalPlacement(nodeSet vinov1.NodeSet) bool {
+	return nodeSet.Placement != nil && nodeSet.Placement.Mode == vinov1.PlacementTotal
+}
+
+// nodeSetSelects returns true if node selector of the node set matches the k8s node
+func nodeSetSelects(nodeSet vinov1.NodeSet, node *corev1.Node) bool {
+	return labels.SelectorFromSet(nodeSet.NodeSelector).Matches(labels.Set(node.Labels))
+}
+
+// maxPerNode returns the maximum number of VMs of the node set on a k8s node, zero if unlimited
+func maxPerNode(nodeSet vinov1.NodeSet) int {
+	if nodeSet.HostAntiAffinity {
+		return 1
+	}
+	return 0
+}
+
+// nodeSetCount returns the number of VMs of the node set on the k8s node
+func (r *BMHManager) nodeSetCount(nodeSet vinov1.NodeSet, node *corev1.Node) int {
+	if totalPlacement(nodeSet) {
+		return r.nodeSetCounts[nodeSet.Name][node.Name]
+	}
+	if !nodeSetSelects(nodeSet, node) {
+		return 0
+	}
+	if limit := maxPerNode(nodeSet); limit > 0 && nodeSet.Count > limit {
+		return limit
+	}
+	return nodeSet.Count
+}
+
+// placeNodeSets places VMs of node sets in Total mode on k8s nodes of vino-builder pods. Placements
+// recorded in vino status are kept as long as possible, so that VMs don't move between reconciles.
+// kept are k8s nodes, whose vino-builders are not running, but whose VMs are kept, they keep their
+// previous placements and get no new VMs. Node sets, whose VMs can't all be placed according to
+// their selectors and anti-affinity, are recorded as unsatisfiable, and as many of their VMs as
+// possible are placed
+func (r *BMHManager) placeNodeSets(ctx context.Context, pods []corev1.Pod, kept map[string]struct{}) error {
+	r.nodeSetCounts = map[string]map[string]int{}
+	r.placements = nil
+	r.unsatisfiable = map[string]string{}
+	previous := map[string]map[string]int{}
+	for _, placement := range r.ViNO.Status.Placements {
+		previous[placement.Name] = map[string]int{}
+		for _, node := range placement.Nodes {
+			previous[placement.Name][node.Name] = node.Count
+		}
+	}
+
+	nodes := make([]*corev1.Node, 0, len(pods))
+	for _, pod := range pods {
+		node, err := r.getNode(ctx, pod)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+	}
+
+	for _, nodeSet := range r.ViNO.Spec.Nodes {
+		if nodeSet.Count == 0 {
+			continue
+		}
+		if !totalPlacement(nodeSet) {
+			r.checkPerNodePlacement(nodeSet, nodes)
+			continue
+		}
+
+		// k8s node is the topology domain of its own, if topology key is not set
+		domains := map[string]string{}
+		for _, node := range nodes {
+			if !nodeSetSelects(nodeSet, node) {
+				continue
+			}
+			domain, ok := node.Name, true
+			if key := nodeSet.Placement.TopologyKey; key != "" {
+				domain, ok = node.Labels[key]
+			}
+			if ok {
+				domains[node.Name] = domain
+			}
+		}
+
+		maxSkew := nodeSet.Placement.MaxSkew
+		if maxSkew < 1 {
+			maxSkew = 1
+		}
+		keptCounts := keptPlacement(nodeSet.Count, kept, previous[nodeSet.Name])
+		keptTotal := 0
+		for _, count := range keptCounts {
+			keptTotal += count
+		}
+		counts := placeVMs(nodeSet.Count-keptTotal, maxSkew, maxPerNode(nodeSet), domains, previous[nodeSet.Name])
+		for nodeName, count := range keptCounts {
+			counts[nodeName] = count
+		}
+		r.nodeSetCounts[nodeSet.Name] = counts
+
+		placed := 0
+		status := vinov1.NodeSetPlacementStatus{Name: nodeSet.Name}
+		for nodeName, count := range counts {
+			if count > 0 {
+				status.Nodes = append(status.Nodes, vinov1.NodePlacement{Name: nodeName, Count: count})
+			}
+			placed += count
+		}
+		sort.Slice(status.Nodes, func(i, j int) bool { return status.Nodes[i].Name < status.Nodes[j].Name })
+		r.Logger.Info("Placed VMs of node set", "node set", nodeSet.Name, "placement", status.Nodes)
+		r.placements = append(r.placements, status)
+		if placed < nodeSet.Count {
+			r.unsatisfiable[nodeSet.Name] = fmt.Sprintf("%d of %d VMs are placed on %d eligible k8s nodes",
+				placed, nodeSet.Count, len(domains))
+		}
+	}
+	return nil
+}
+
+// keptPlacement returns previous numbers of VMs on kept k8s nodes. If they add up to more than
+// total, VMs are removed from the last nodes first
+func keptPlacement(total int, kept map[string]struct{}, previous map[string]int) map[string]int {
+	nodeNames := make([]string, 0, len(kept))
+	for nodeName := range kept {
+		if previous[nodeName] > 0 {
+			nodeNames = append(nodeNames, nodeName)
+		}
+	}
+	sort.Strings(nodeNames)
+	counts := map[string]int{}
+	for _, nodeName := range nodeNames {
+		count := previous[nodeName]
+		if count > total {
+			count = total
+		}
+		if count > 0 {
+			counts[nodeName] = count
+		}
+		total -= count
+	}
+	return counts
+}
+
+// checkPerNodePlacement records node set in PerNode mode as unsatisfiable, if none of the k8s
+// nodes match its selector, or its anti-affinity doesn't allow count VMs on a node
+func (r *BMHManager) checkPerNodePlacement(nodeSet vinov1.NodeSet, nodes []*corev1.Node) {
+	if limit := maxPerNode(nodeSet); limit > 0 && nodeSet.Count > limit {
+		r.unsatisfiable[nodeSet.Name] = fmt.Sprintf(
+			"host anti-affinity allows %d VMs on a k8s node, %d are requested", limit, nodeSet.Count)
+		return
+	}
+	for _, node := range nodes {
+		if nodeSetSelects(nodeSet, node) {
+			return
+		}
+	}
+	r.unsatisfiable[nodeSet.Name] = "no k8s nodes match node selector of the node set"
+}
+
+// UnsatisfiablePlacements returns the reasons VMs of node sets are not placed as requested, by
+// node set name
+func (r *BMHManager) UnsatisfiablePlacements() map[string]string {
+	return r.unsatisfiable
+}
+
+// Placements returns placements of node sets in Total mode
+func (r *BMHManager) Placements() []vinov1.NodeSetPlacementStatus {
+	return r.placements
+}
+
+// placeVMs returns numbers of VMs on k8s nodes, that add up to total, unless there are not
+// enough nodes to place them with at most limit VMs per node. Zero limit means no limit. domains
+// map names of k8s nodes, VMs can be placed on, to their topology domains. Previous numbers of
+// VMs on the nodes are kept, VMs are added to the least loaded domains and removed from the most
+// loaded ones, and are moved between domains only if spread of VMs exceeds max skew.
+func placeVMs(total, maxSkew, limit int, domains map[string]string, previous map[string]int) map[string]int {
+	counts := map[string]int{}
+	sum := 0
+	for nodeName := range domains {
+		counts[nodeName] = previous[nodeName]
+		if limit > 0 && counts[nodeName] > limit {
+			counts[nodeName] = limit
+		}
+		sum += counts[nodeName]
+	}
+	if len(domains) == 0 {
+		return counts
+	}
+
+	// nodes are ordered by domain and name, so that placement is deterministic
+	nodeNames := make([]string, 0, len(domains))
+	for nodeName := range domains {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	sort.Slice(nodeNames, func(i, j int) bool {
+		if domains[nodeNames[i]] != domains[nodeNames[j]] {
+			return domains[nodeNames[i]] < domains[nodeNames[j]]
+		}
+		return nodeNames[i] < nodeNames[j]
+	})
+
+	// pick returns the least loaded node of the least loaded domain, that has room for VMs, or
+	// the most loaded node of the most loaded domain, that has VMs. Ties are resolved to the
+	// first node, when VMs are added, and to the last one, when they are removed
+	pick := func(most bool) string {
+		domainCounts := domainCounts(counts, domains)
+		better := func(a, b int) bool {
+			if most {
+				return a >= b
+			}
+			return a < b
+		}
+		picked := ""
+		for _, nodeName := range nodeNames {
+			if (most && counts[nodeName] == 0) || (!most && limit > 0 && counts[nodeName] >= limit) {
+				continue
+			}
+			if picked == "" {
+				picked = nodeName
+				continue
+			}
+			domain, pickedDomain := domains[nodeName], domains[picked]
+			if domain != pickedDomain {
+				if better(domainCounts[domain], domainCounts[pickedDomain]) {
+					picked = nodeName
+				}
+				continue
+			}
+			if better(counts[nodeName], counts[picked]) {
+				picked = nodeName
+			}
+		}
+		return picked
+	}
+
+	for ; sum > total; sum-- {
+		counts[pick(true)]--
+	}
+	for ; sum < total; sum++ {
+		to := pick(false)
+		if to == "" {
+			// all nodes are full
+			break
+		}
+		counts[to]++
+	}
+	// VMs are moved only if the difference between their domains shrinks, so that they don't move
+	// back and forth within a domain or between equally loaded domains, when other domains are full
+	for {
+		from, to := pick(true), pick(false)
+		if from == "" || to == "" || domainSkew(counts, domains) <= maxSkew {
+			return counts
+		}
+		if domainCounts := domainCounts(counts, domains); domainCounts[domains[from]]-1 <
+			domainCounts[domains[to]]+1 {
+			return counts
+		}
+		counts[from]--
+		counts[to]++
+	}
+}
+
+// domainSkew returns the difference between the highest and the lowest numbers of VMs in
+// topology domains
+func domainSkew(counts map[string]int, domains map[string]string) int {
+	first := true
+	min, max := 0, 0
+	for _, count := range domainCounts(counts, domains) {
+		if first || count < min {
+			min = count
+		}
+		if first || count > max {
+			max = count
+		}
+		first = false
+	}
+	return max - min
+}
+
+// domainCounts returns numbers of VMs in topology domains
+func domainCounts(counts map[string]int, domains map[string]string) map[string]int {
+	domainCounts := map[string]int{}
+	for nodeName, domain := range domains {
+		domainCounts[domain] += counts[nodeName]
+	}
+	return domainCounts
+}
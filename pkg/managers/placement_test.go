@@ -0,0 +1,269 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+func TestPlaceVMs(t *testing.T) {
+	racks := map[string]string{"node01": "rack1", "node02": "rack1", "node03": "rack2", "node04": "rack3"}
+	tests := []struct {
+		name     string
+		total    int
+		maxSkew  int
+		limit    int
+		domains  map[string]string
+		previous map[string]int
+		expected map[string]int
+	}{
+		{
+			name:     "spreads VMs across domains",
+			total:    3,
+			maxSkew:  1,
+			domains:  racks,
+			expected: map[string]int{"node01": 1, "node02": 0, "node03": 1, "node04": 1},
+		},
+		{
+			name:     "spreads VMs across nodes within a domain",
+			total:    6,
+			maxSkew:  1,
+			domains:  racks,
+			expected: map[string]int{"node01": 1, "node02": 1, "node03": 2, "node04": 2},
+		},
+		{
+			name:     "keeps previous placement within max skew",
+			total:    3,
+			maxSkew:  2,
+			domains:  racks,
+			previous: map[string]int{"node01": 2, "node03": 1},
+			expected: map[string]int{"node01": 2, "node02": 0, "node03": 1, "node04": 0},
+		},
+		{
+			name:     "moves VMs when max skew is exceeded",
+			total:    3,
+			maxSkew:  1,
+			domains:  racks,
+			previous: map[string]int{"node01": 2, "node03": 1},
+			expected: map[string]int{"node01": 1, "node02": 0, "node03": 1, "node04": 1},
+		},
+		{
+			name:     "removes VMs from the most loaded domain",
+			total:    2,
+			maxSkew:  1,
+			domains:  racks,
+			previous: map[string]int{"node01": 1, "node02": 1, "node03": 1},
+			expected: map[string]int{"node01": 1, "node02": 0, "node03": 1, "node04": 0},
+		},
+		{
+			name:     "replaces VMs of nodes that are gone",
+			total:    3,
+			maxSkew:  1,
+			domains:  map[string]string{"node01": "rack1", "node03": "rack2", "node04": "rack3"},
+			previous: map[string]int{"node01": 1, "node02": 1, "node03": 1},
+			expected: map[string]int{"node01": 1, "node03": 1, "node04": 1},
+		},
+		{
+			name:     "places at most limit VMs on a node",
+			total:    3,
+			maxSkew:  1,
+			limit:    1,
+			domains:  map[string]string{"node01": "rack1", "node02": "rack1", "node03": "rack2"},
+			previous: map[string]int{"node01": 2},
+			expected: map[string]int{"node01": 1, "node02": 1, "node03": 1},
+		},
+		{
+			name:     "places fewer VMs if nodes are full",
+			total:    3,
+			maxSkew:  1,
+			limit:    1,
+			domains:  map[string]string{"node01": "rack1", "node03": "rack2"},
+			expected: map[string]int{"node01": 1, "node03": 1},
+		},
+		{
+			name:    "doesn't move VMs within a domain, if other domains are full",
+			total:   4,
+			maxSkew: 1,
+			limit:   1,
+			domains: map[string]string{
+				"node01": "rack1", "node02": "rack1", "node03": "rack1", "node04": "rack1", "node05": "rack2",
+			},
+			expected: map[string]int{"node01": 1, "node02": 1, "node03": 1, "node04": 0, "node05": 1},
+		},
+		{
+			name:     "places nothing without domains",
+			total:    3,
+			maxSkew:  1,
+			domains:  map[string]string{},
+			expected: map[string]int{},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, placeVMs(tt.total, tt.maxSkew, tt.limit, tt.domains, tt.previous))
+		})
+	}
+}
+
+func TestPlaceNodeSets(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := func(name, zone string) *corev1.Node {
+		labels := map[string]string{}
+		if zone != "" {
+			labels["zone"] = zone
+			labels["vino.role/worker"] = ""
+		}
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, ResourceVersion: "1"}}
+	}
+	nodes := []*corev1.Node{node("node01", "a"), node("node02", "b"), node("node03", "")}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(nodes[0], nodes[1], nodes[2]).Build()
+	pods := []corev1.Pod{}
+	for _, node := range nodes {
+		pods = append(pods, corev1.Pod{Spec: corev1.PodSpec{NodeName: node.Name}})
+	}
+
+	vino := &vinov1.Vino{
+		Spec: vinov1.VinoSpec{Nodes: []vinov1.NodeSet{
+			{Name: "worker", Count: 2, NodeSelector: map[string]string{"vino.role/worker": ""}},
+			{Name: "master", Count: 3, Placement: &vinov1.NodeSetPlacement{
+				Mode: vinov1.PlacementTotal, TopologyKey: "zone", MaxSkew: 1}},
+		}},
+		Status: vinov1.VinoStatus{Placements: []vinov1.NodeSetPlacementStatus{
+			{Name: "master", Nodes: []vinov1.NodePlacement{{Name: "node02", Count: 2}}},
+		}},
+	}
+	r := &BMHManager{Client: c, ViNO: vino, Logger: logr.Discard()}
+	require.NoError(t, r.placeNodeSets(ctx, pods, nil))
+
+	// node03 has no zone label and gets no VMs of the node set
+	assert.Equal(t, []vinov1.NodeSetPlacementStatus{{Name: "master", Nodes: []vinov1.NodePlacement{
+		{Name: "node01", Count: 1}, {Name: "node02", Count: 2},
+	}}}, r.Placements())
+	assert.Empty(t, r.UnsatisfiablePlacements())
+	assert.Equal(t, 1, r.nodeSetCount(vino.Spec.Nodes[1], nodes[0]))
+	assert.Equal(t, 0, r.nodeSetCount(vino.Spec.Nodes[1], nodes[2]))
+	// node sets in PerNode mode get count VMs on every node matching their selector
+	assert.Equal(t, 2, r.nodeSetCount(vino.Spec.Nodes[0], nodes[0]))
+	assert.Equal(t, 0, r.nodeSetCount(vino.Spec.Nodes[0], nodes[2]))
+
+	// no two masters share a node, so only two of them are placed
+	vino.Spec.Nodes[1].HostAntiAffinity = true
+	require.NoError(t, r.placeNodeSets(ctx, pods, nil))
+	assert.Equal(t, []vinov1.NodeSetPlacementStatus{{Name: "master", Nodes: []vinov1.NodePlacement{
+		{Name: "node01", Count: 1}, {Name: "node02", Count: 1},
+	}}}, r.Placements())
+	assert.Equal(t, map[string]string{
+		"master": "2 of 3 VMs are placed on 2 eligible k8s nodes",
+	}, r.UnsatisfiablePlacements())
+
+	vino.Spec.Nodes[0].HostAntiAffinity = true
+	vino.Spec.Nodes[1].Placement.TopologyKey = "rack"
+	require.NoError(t, r.placeNodeSets(ctx, pods, nil))
+	assert.Equal(t, map[string]string{
+		"worker": "host anti-affinity allows 1 VMs on a k8s node, 2 are requested",
+		"master": "0 of 3 VMs are placed on 0 eligible k8s nodes",
+	}, r.UnsatisfiablePlacements())
+	assert.Equal(t, 1, r.nodeSetCount(vino.Spec.Nodes[0], nodes[0]))
+
+	vino.Spec.Nodes[0].NodeSelector = map[string]string{"vino.role/storage": ""}
+	require.NoError(t, r.placeNodeSets(ctx, pods, nil))
+	assert.Equal(t, "host anti-affinity allows 1 VMs on a k8s node, 2 are requested",
+		r.UnsatisfiablePlacements()["worker"])
+	vino.Spec.Nodes[0].HostAntiAffinity = false
+	require.NoError(t, r.placeNodeSets(ctx, pods, nil))
+	assert.Equal(t, "no k8s nodes match node selector of the node set", r.UnsatisfiablePlacements()["worker"])
+}
+
+func TestPlacementsSurviveReconcile(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, vinov1.AddToScheme(scheme))
+
+	node := func(name, zone string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Labels: map[string]string{"zone": zone}, ResourceVersion: "1"}}
+	}
+	vino := &vinov1.Vino{
+		ObjectMeta: metav1.ObjectMeta{Name: "vino", Namespace: "default", ResourceVersion: "1"},
+		Spec: vinov1.VinoSpec{Nodes: []vinov1.NodeSet{{Name: "master", Count: 2, Placement: &vinov1.NodeSetPlacement{
+			Mode: vinov1.PlacementTotal, TopologyKey: "zone", MaxSkew: 1}}}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(vino, node("node01", "a"), node("node02", "b"), node("node03", "c")).Build()
+
+	// reconcile places VMs with a new manager and records placements in vino status, as the
+	// controller does
+	reconcile := func(kept map[string]struct{}, nodeNames ...string) []vinov1.NodeSetPlacementStatus {
+		latest := &vinov1.Vino{}
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(vino), latest))
+		pods := []corev1.Pod{}
+		for _, nodeName := range nodeNames {
+			pods = append(pods, corev1.Pod{Spec: corev1.PodSpec{NodeName: nodeName}})
+		}
+		r := &BMHManager{Client: c, ViNO: latest, Logger: logr.Discard()}
+		require.NoError(t, r.placeNodeSets(ctx, pods, kept))
+		latest.Status.Placements = r.Placements()
+		require.NoError(t, c.Status().Update(ctx, latest))
+		return r.Placements()
+	}
+	// placement returns placement of a VM on every k8s node
+	placement := func(nodeNames ...string) []vinov1.NodeSetPlacementStatus {
+		status := vinov1.NodeSetPlacementStatus{Name: "master"}
+		for _, nodeName := range nodeNames {
+			status.Nodes = append(status.Nodes, vinov1.NodePlacement{Name: nodeName, Count: 1})
+		}
+		return []vinov1.NodeSetPlacementStatus{status}
+	}
+
+	// VMs go to the first k8s nodes of the least loaded zones
+	assert.Equal(t, placement("node01", "node02"), reconcile(nil, "node01", "node02", "node03"))
+
+	// vino-builder of node01 restarts, its VM is kept and doesn't move
+	restarting := map[string]struct{}{"node01": {}}
+	assert.Equal(t, placement("node01", "node02"), reconcile(restarting, "node02", "node03"))
+	assert.Equal(t, placement("node01", "node02"), reconcile(nil, "node01", "node02", "node03"))
+
+	// node01 is gone and its VMs are no longer kept, its VM moves to node03
+	assert.Equal(t, placement("node02", "node03"), reconcile(nil, "node02", "node03"))
+
+	// VMs stay where they are, when node01 is back, although it would be picked first otherwise
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, placement("node02", "node03"), reconcile(nil, "node01", "node02", "node03"))
+	}
+
+	// VMs above count are removed from kept nodes too
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(vino), vino))
+	vino.Spec.Nodes[0].Count = 1
+	require.NoError(t, c.Update(ctx, vino))
+	kept := map[string]struct{}{"node02": {}, "node03": {}}
+	assert.Equal(t, placement("node02"), reconcile(kept, "node01"))
+}
@@ -0,0 +1,82 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"context"
+
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+// VMRealization is the state of VMs of vino CR, as reported by vino-builders
+type VMRealization struct {
+	// Pending are names of BMHs, whose VMs are not realized by vino-builders yet
+	Pending []string
+	// Failed are errors of VMs, that vino-builders have failed to realize, by BMH name
+	Failed map[string]string
+}
+
+// loadDomainStatuses reads state of domains reported by vino-builders to VinoBuilderConfigs of
+// vino CR, and failures of builds of the current config generations by k8s node name. Domains
+// reported for earlier generations of configs are ignored, until vino-builders realize the
+// current ones
+func (r *BMHManager) loadDomainStatuses(ctx context.Context) error {
+	r.domainStatuses = map[string]vinov1.DomainStatus{}
+	r.buildFailures = map[string]string{}
+	r.realization = VMRealization{Failed: map[string]string{}}
+	configList := &vinov1.VinoBuilderConfigList{}
+	if err := r.List(ctx, configList,
+		client.MatchingLabels(r.vinoLabels()), client.InNamespace(r.ViNO.Namespace)); err != nil {
+		return err
+	}
+	for _, config := range configList.Items {
+		if config.Status.ObservedGeneration >= config.Generation {
+			for _, domain := range config.Status.Domains {
+				r.domainStatuses[domain.Name] = domain
+			}
+		}
+		built := apimeta.FindStatusCondition(config.Status.Conditions, vinov1.ConditionTypeBuilt)
+		if built != nil && built.Status == metav1.ConditionFalse && built.ObservedGeneration == config.Generation {
+			r.buildFailures[config.Spec.NodeName] = built.Message
+		}
+	}
+	return nil
+}
+
+// domainRealized returns true if vino-builder has reported domain of the BMH as realized,
+// otherwise the BMH is recorded as pending or failed
+func (r *BMHManager) domainRealized(bmhName, nodeName string) bool {
+	status, reported := r.domainStatuses[bmhName]
+	switch {
+	case reported && status.Realized():
+		return true
+	case reported && status.Error != "":
+		r.realization.Failed[bmhName] = status.Error
+	case r.buildFailures[nodeName] != "":
+		r.realization.Failed[bmhName] = r.buildFailures[nodeName]
+	default:
+		r.realization.Pending = append(r.realization.Pending, bmhName)
+	}
+	return false
+}
+
+// VMRealization returns state of VMs, whose BMHs were not created by CreateBMHs
+func (r *BMHManager) VMRealization() VMRealization {
+	return r.realization
+}
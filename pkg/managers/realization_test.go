@@ -0,0 +1,109 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+func TestCreateRealizedBMHs(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, vinov1.AddToScheme(scheme))
+	require.NoError(t, metal3.AddToScheme(scheme))
+
+	vino := &vinov1.Vino{ObjectMeta: metav1.ObjectMeta{Name: "vino", Namespace: "default"}}
+	config := func(nodeName string, generation int64, status vinov1.VinoBuilderConfigStatus) *vinov1.VinoBuilderConfig {
+		return &vinov1.VinoBuilderConfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            BuilderConfigName(vino, nodeName),
+				Namespace:       "default",
+				Labels:          vinoLabels(vino),
+				Generation:      generation,
+				ResourceVersion: "1",
+			},
+			Spec:   vinov1.VinoBuilderConfigSpec{NodeName: nodeName},
+			Status: status,
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		config("node01", 1, vinov1.VinoBuilderConfigStatus{ObservedGeneration: 1, Domains: []vinov1.DomainStatus{
+			{Name: "default-vino-node01-worker-0", State: vinov1.DomainStateRunning},
+			{Name: "default-vino-node01-worker-1", State: vinov1.DomainStateDefined, Error: "volume is not created"},
+		}}),
+		// domains were realized for the previous generation of the config of node03
+		config("node03", 2, vinov1.VinoBuilderConfigStatus{ObservedGeneration: 1, Domains: []vinov1.DomainStatus{
+			{Name: "default-vino-node03-worker-0", State: vinov1.DomainStateRunning},
+		}}),
+		// build of the current generation has failed on node02
+		config("node02", 2, vinov1.VinoBuilderConfigStatus{ObservedGeneration: 2, Conditions: []metav1.Condition{{
+			Type:               vinov1.ConditionTypeBuilt,
+			Status:             metav1.ConditionFalse,
+			Reason:             vinov1.BuildFailedReason,
+			Message:            "playbook failed",
+			ObservedGeneration: 2,
+		}}}),
+	).Build()
+
+	bmh := func(name string) *metal3.BareMetalHost {
+		return &metal3.BareMetalHost{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "vino-system"}}
+	}
+	r := &BMHManager{
+		Namespace: "vino-system",
+		Client:    c,
+		ViNO:      vino,
+		Logger:    logr.Discard(),
+		bmhList: []*metal3.BareMetalHost{
+			bmh("default-vino-node01-worker-0"),
+			bmh("default-vino-node01-worker-1"),
+			bmh("default-vino-node01-worker-2"),
+			bmh("default-vino-node02-worker-0"),
+			bmh("default-vino-node03-worker-0"),
+		},
+		nodeStatuses: []vinov1.NodeStatus{
+			{Name: "node01", VMs: []vinov1.VMStatus{
+				{BMHName: "default-vino-node01-worker-0"},
+				{BMHName: "default-vino-node01-worker-1"},
+				{BMHName: "default-vino-node01-worker-2"},
+			}},
+			{Name: "node02", VMs: []vinov1.VMStatus{{BMHName: "default-vino-node02-worker-0"}}},
+			{Name: "node03", VMs: []vinov1.VMStatus{{BMHName: "default-vino-node03-worker-0"}}},
+		},
+	}
+
+	require.NoError(t, r.CreateBMHs(ctx))
+	bmhList := &metal3.BareMetalHostList{}
+	require.NoError(t, c.List(ctx, bmhList))
+	require.Len(t, bmhList.Items, 1)
+	assert.Equal(t, "default-vino-node01-worker-0", bmhList.Items[0].Name)
+
+	realization := r.VMRealization()
+	assert.Equal(t, []string{"default-vino-node01-worker-2", "default-vino-node03-worker-0"}, realization.Pending)
+	assert.Equal(t, map[string]string{
+		"default-vino-node01-worker-1": "volume is not created",
+		"default-vino-node02-worker-0": "playbook failed",
+	}, realization.Failed)
+}
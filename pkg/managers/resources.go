@@ -0,0 +1,156 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+const (
+	// FlavorsConfigMapName is the config map in the runtime namespace with VM flavors, it is
+	// mounted to vino-builder containers
+	FlavorsConfigMapName = "vino-flavors"
+	// FlavorsKey is the key of flavors definition in the flavors config map
+	FlavorsKey = "flavors.yaml"
+)
+
+// accountedResources are node resources, that VMs of all vino CRs on the node share
+var accountedResources = []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory}
+
+// flavor is the part of VM flavor, that is needed to account node resources and to check host
+// capacity
+type flavor struct {
+	VCPUs int64 `json:"vcpus"`
+	// Memory is in GiB
+	Memory int64 `json:"memory"`
+	// RootSize is in GB
+	RootSize  int64 `json:"rootSize"`
+	HugePages bool  `json:"hugepages,omitempty"`
+}
+
+type flavorsDefinition struct {
+	Flavors map[string]flavor `json:"flavors"`
+}
+
+// loadFlavors reads VM flavors from the config map mounted to vino-builder. Resources of VMs
+// are not accounted, if there is no flavors config map
+func (r *BMHManager) loadFlavors(ctx context.Context) error {
+	r.flavors = nil
+	cm := &corev1.ConfigMap{}
+	key := client.ObjectKey{Name: FlavorsConfigMapName, Namespace: r.Namespace}
+	err := r.Get(ctx, key, cm)
+	switch {
+	case apierror.IsNotFound(err):
+		r.Logger.Info("Flavors config map doesn't exist, node resources are not accounted", "config map", key)
+		return nil
+	case err != nil:
+		return err
+	}
+
+	definition := flavorsDefinition{}
+	if err = yaml.Unmarshal([]byte(cm.Data[FlavorsKey]), &definition); err != nil {
+		return fmt.Errorf("failed to parse key '%s' of flavors config map %v: %w", FlavorsKey, key, err)
+	}
+	r.flavors = definition.Flavors
+	return nil
+}
+
+// loadNodeSetFlavors resolves VinoFlavors referenced by node sets of vino CR
+func (r *BMHManager) loadNodeSetFlavors(ctx context.Context) error {
+	r.nodeSetFlavors = map[string]*vinov1.VinoFlavorSpec{}
+	for _, nodeSet := range r.ViNO.Spec.Nodes {
+		if nodeSet.Flavor == "" {
+			continue
+		}
+		vinoFlavor := &vinov1.VinoFlavor{}
+		err := r.Get(ctx, client.ObjectKey{Name: nodeSet.Flavor}, vinoFlavor)
+		switch {
+		case apierror.IsNotFound(err):
+			return fmt.Errorf("VinoFlavor %s of node set %s is not found", nodeSet.Flavor, nodeSet.Name)
+		case err != nil:
+			return err
+		}
+		r.nodeSetFlavors[nodeSet.Name] = &vinoFlavor.Spec
+	}
+	return nil
+}
+
+// domainResources returns CPU and memory requested by the domains. Domains are sized by their
+// VinoFlavor, or by the flavor of their role from flavors config map. Resources are nil if sizing
+// of some domain is unknown, because there is no flavors config map
+func (r *BMHManager) domainResources(domains []vinov1.BuilderDomain) (corev1.ResourceList, error) {
+	var vcpus, memory int64
+	for _, domain := range domains {
+		if domain.Flavor != nil {
+			vcpus += domain.Flavor.VCPUs
+			memory += domain.Flavor.Memory
+			continue
+		}
+		if r.flavors == nil {
+			return nil, nil
+		}
+		f, ok := r.flavors[domain.Role]
+		if !ok {
+			return nil, fmt.Errorf("flavor '%s' of domain %s is not defined in config map %s",
+				domain.Role, domain.Name, FlavorsConfigMapName)
+		}
+		vcpus += f.VCPUs
+		memory += f.Memory
+	}
+	return corev1.ResourceList{
+		corev1.ResourceCPU:    *resource.NewQuantity(vcpus, resource.DecimalSI),
+		corev1.ResourceMemory: *resource.NewQuantity(memory<<30, resource.BinarySI),
+	}, nil
+}
+
+// checkNodeResources returns CapacityError, if requested resources together with resources of other
+// vino CRs on the node exceed allocatable resources of the node
+func checkNodeResources(node *corev1.Node,
+	requested corev1.ResourceList,
+	others []vinov1.VinoBuilderConfig) error {
+	for _, name := range accountedResources {
+		quantity, ok := requested[name]
+		if !ok {
+			continue
+		}
+		allocatable, ok := node.Status.Allocatable[name]
+		if !ok {
+			continue
+		}
+		used := resource.Quantity{}
+		for _, other := range others {
+			if q, ok := other.Spec.Resources[name]; ok {
+				used.Add(q)
+			}
+		}
+		total := used.DeepCopy()
+		total.Add(quantity)
+		if total.Cmp(allocatable) > 0 {
+			return &CapacityError{Node: node.Name, Reason: fmt.Sprintf(
+				"requested %s %s, used by other vino CRs %s, allocatable %s",
+				name, quantity.String(), used.String(), allocatable.String())}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+func TestNodeResources(t *testing.T) {
+	ctx := context.Background()
+	vino := &vinov1.Vino{ObjectMeta: metav1.ObjectMeta{Name: "vino", Namespace: "default"}}
+	r := &BMHManager{Namespace: "vino-system", Client: fake.NewClientBuilder().Build(), ViNO: vino, Logger: logr.Discard()}
+
+	// resources are not accounted without flavors
+	require.NoError(t, r.loadFlavors(ctx))
+	resources, err := r.domainResources([]vinov1.BuilderDomain{{Name: "worker-0", Role: "worker"}})
+	require.NoError(t, err)
+	assert.Nil(t, resources)
+
+	r.Client = fake.NewClientBuilder().WithObjects(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: FlavorsConfigMapName, Namespace: "vino-system", ResourceVersion: "1"},
+		Data: map[string]string{
+			FlavorsKey: "flavors:\n  master:\n    vcpus: 2\n    memory: 4\n    rootSize: 30\n    hugepages: true\n" +
+				"  worker:\n    vcpus: 1\n    memory: 2\n",
+		},
+	}).Build()
+	require.NoError(t, r.loadFlavors(ctx))
+	assert.Equal(t, flavor{VCPUs: 2, Memory: 4, RootSize: 30, HugePages: true}, r.flavors["master"])
+	resources, err = r.domainResources([]vinov1.BuilderDomain{
+		{Name: "master-0", Role: "master"},
+		{Name: "worker-0", Role: "worker"},
+		{Name: "worker-1", Role: "worker"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), resources.Cpu().Value())
+	assert.Equal(t, int64(8<<30), resources.Memory().Value())
+
+	_, err = r.domainResources([]vinov1.BuilderDomain{{Name: "storage-0", Role: "storage"}})
+	assert.Error(t, err)
+
+	// domains sized by VinoFlavor don't need flavors config map
+	r.flavors = nil
+	resources, err = r.domainResources([]vinov1.BuilderDomain{
+		{Name: "storage-0", Role: "storage", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 4, Memory: 8}},
+		{Name: "storage-1", Role: "storage", Flavor: &vinov1.VinoFlavorSpec{VCPUs: 4, Memory: 8}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), resources.Cpu().Value())
+	assert.Equal(t, int64(16<<30), resources.Memory().Value())
+	resources = corev1.ResourceList{
+		corev1.ResourceCPU:    *resource.NewQuantity(4, resource.DecimalSI),
+		corev1.ResourceMemory: *resource.NewQuantity(8<<30, resource.BinarySI),
+	}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node01"},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("8"),
+			corev1.ResourceMemory: resource.MustParse("16Gi"),
+		}},
+	}
+	others := []vinov1.VinoBuilderConfig{{Spec: vinov1.VinoBuilderConfigSpec{Resources: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("4"),
+		corev1.ResourceMemory: resource.MustParse("8Gi"),
+	}}}}
+	assert.NoError(t, checkNodeResources(node, resources, others))
+
+	// VMs of another vino CR leave not enough memory
+	others[0].Spec.Resources[corev1.ResourceMemory] = resource.MustParse("9Gi")
+	assert.Error(t, checkNodeResources(node, resources, others))
+}
+
+func TestLoadNodeSetFlavors(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, vinov1.AddToScheme(scheme))
+	vino := &vinov1.Vino{
+		ObjectMeta: metav1.ObjectMeta{Name: "vino", Namespace: "default"},
+		Spec: vinov1.VinoSpec{Nodes: []vinov1.NodeSet{
+			{Name: "master"},
+			{Name: "worker", Flavor: "large"},
+			{Name: "storage", Flavor: "large"},
+		}},
+	}
+	large := &vinov1.VinoFlavor{
+		ObjectMeta: metav1.ObjectMeta{Name: "large", ResourceVersion: "1"},
+		Spec:       vinov1.VinoFlavorSpec{VCPUs: 4, Memory: 8, RootSize: 40},
+	}
+	r := &BMHManager{
+		Namespace: "vino-system",
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(large).Build(),
+		ViNO:      vino,
+		Logger:    logr.Discard(),
+	}
+	require.NoError(t, r.loadNodeSetFlavors(ctx))
+	assert.Equal(t, map[string]*vinov1.VinoFlavorSpec{"worker": &large.Spec, "storage": &large.Spec},
+		r.nodeSetFlavors)
+
+	vino.Spec.Nodes[0].Flavor = "small"
+	err := r.loadNodeSetFlavors(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "VinoFlavor small of node set master is not found")
+}
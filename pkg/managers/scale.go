@@ -0,0 +1,306 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+// NodeRemovedPauseValue is the value of BMH paused annotation, that vino sets on BMHs of k8s nodes
+// removed from vino CR. vino resumes only BMHs paused with this value
+const NodeRemovedPauseValue = "vino.airshipit.org/node-removed"
+
+// NodeRemovalGracePeriod returns how long VMs of a k8s node removed from vino CR are kept
+func NodeRemovalGracePeriod(vino *vinov1.Vino) time.Duration {
+	if vino.Spec.NodeRemovalGracePeriod == nil {
+		return vinov1.VinoDefaultNodeRemovalGracePeriod
+	}
+	return vino.Spec.NodeRemovalGracePeriod.Duration
+}
+
+// NextNodeRemoval returns time left until grace period of the next removed k8s node in vino
+// status passes, or zero if no nodes are being removed
+func NextNodeRemoval(vino *vinov1.Vino, now time.Time) time.Duration {
+	var next time.Duration
+	for _, nodeStatus := range vino.Status.Nodes {
+		if nodeStatus.RemovedAt == nil {
+			continue
+		}
+		left := nodeStatus.RemovedAt.Add(NodeRemovalGracePeriod(vino)).Sub(now)
+		if left <= 0 {
+			// removal is overdue, reconcile again as soon as possible
+			left = time.Second
+		}
+		if next == 0 || left < next {
+			next = left
+		}
+	}
+	return next
+}
+
+// handleRemovedNodes processes k8s nodes from vino status, that vino-builders no longer run on.
+// VMs of a node, that still matches node selector, are kept, since its vino-builder is expected
+// to come back. BMHs of a node, that is gone or no longer matches node selector, are paused
+// until grace period passes, and are deleted together with IPAM allocations of the node after
+// it. BMHs of nodes, that are back, are resumed. Statuses of nodes, whose VMs are kept, are added
+// to node statuses, and their names are returned, so that their vino-builder configs are kept.
+func (r *BMHManager) handleRemovedNodes(ctx context.Context,
+	scheduled map[string]struct{}) (map[string]struct{}, error) {
+	kept := map[string]struct{}{}
+	now := metav1.Now()
+	for _, nodeStatus := range r.ViNO.Status.Nodes {
+		if _, ok := scheduled[nodeStatus.Name]; ok {
+			if nodeStatus.RemovedAt != nil {
+				r.Logger.Info("Node is back, resuming its BaremetalHosts", "node", nodeStatus.Name)
+				if err := r.setBMHsPaused(ctx, nodeStatus, false); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		left, err := r.nodeLeft(ctx, nodeStatus.Name)
+		if err != nil {
+			return nil, err
+		}
+		nodeStatus.BuilderReady = false
+		if !left {
+			r.Logger.Info("vino-builder is not running on node, keeping its VMs", "node", nodeStatus.Name)
+			kept[nodeStatus.Name] = struct{}{}
+			r.nodeStatuses = append(r.nodeStatuses, nodeStatus)
+			continue
+		}
+
+		if nodeStatus.RemovedAt == nil {
+			nodeStatus.RemovedAt = &now
+		}
+		if now.Time.Before(nodeStatus.RemovedAt.Add(NodeRemovalGracePeriod(r.ViNO))) {
+			r.Logger.Info("Node is removed, pausing its BaremetalHosts until grace period passes",
+				"node", nodeStatus.Name, "removed at", nodeStatus.RemovedAt)
+			if err = r.setBMHsPaused(ctx, nodeStatus, true); err != nil {
+				return nil, err
+			}
+			kept[nodeStatus.Name] = struct{}{}
+			r.nodeStatuses = append(r.nodeStatuses, nodeStatus)
+			continue
+		}
+
+		r.Logger.Info("Grace period of removed node has passed, deleting its VMs", "node", nodeStatus.Name)
+		if err = r.removeNode(ctx, nodeStatus); err != nil {
+			return nil, err
+		}
+	}
+	return kept, nil
+}
+
+// nodeLeft returns true if the k8s node doesn't exist or no longer matches node selector
+func (r *BMHManager) nodeLeft(ctx context.Context, nodeName string) (bool, error) {
+	node := &corev1.Node{}
+	err := r.Get(ctx, types.NamespacedName{Name: nodeName}, node)
+	switch {
+	case apierror.IsNotFound(err):
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+	if r.ViNO.Spec.NodeSelector == nil {
+		return false, nil
+	}
+	selector := labels.SelectorFromSet(r.ViNO.Spec.NodeSelector.MatchLabels)
+	return !selector.Matches(labels.Set(node.Labels)), nil
+}
+
+// setBMHsPaused pauses or resumes BMHs of VMs on the k8s node. Only BMHs paused by vino are
+// resumed, pause set by someone else is kept
+func (r *BMHManager) setBMHsPaused(ctx context.Context, nodeStatus vinov1.NodeStatus, paused bool) error {
+	for _, vm := range nodeStatus.VMs {
+		bmh := &metal3.BareMetalHost{}
+		err := r.Get(ctx, types.NamespacedName{Name: vm.BMHName, Namespace: r.Namespace}, bmh)
+		switch {
+		case apierror.IsNotFound(err):
+			continue
+		case err != nil:
+			return err
+		}
+
+		value, isPaused := bmh.Annotations[metal3.PausedAnnotation]
+		if paused == isPaused || (!paused && value != NodeRemovedPauseValue) {
+			continue
+		}
+		patch := client.MergeFrom(bmh.DeepCopy())
+		if paused {
+			if bmh.Annotations == nil {
+				bmh.Annotations = map[string]string{}
+			}
+			bmh.Annotations[metal3.PausedAnnotation] = NodeRemovedPauseValue
+		} else {
+			delete(bmh.Annotations, metal3.PausedAnnotation)
+		}
+		r.Logger.Info("Setting BaremetalHost paused", "BMH", client.ObjectKeyFromObject(bmh), "paused", paused)
+		if err = r.Patch(ctx, bmh, patch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeNode deletes BMHs of VMs on the k8s node with their secrets, vino-builder config of the
+// node, and releases IPAM allocations of the VMs and of the node. Allocations of the node, that
+// are still used by VMs of other vino CRs, are kept
+func (r *BMHManager) removeNode(ctx context.Context, nodeStatus vinov1.NodeStatus) error {
+	prefixes := make([]string, 0, len(nodeStatus.VMs))
+	for _, vm := range nodeStatus.VMs {
+		if err := r.deleteVMObjects(ctx, vm.BMHName); err != nil {
+			return err
+		}
+		prefixes = append(prefixes, vm.BMHName+"/")
+	}
+
+	config := &vinov1.VinoBuilderConfig{ObjectMeta: metav1.ObjectMeta{
+		Name:      BuilderConfigName(r.ViNO, nodeStatus.Name),
+		Namespace: r.ViNO.Namespace,
+	}}
+	if err := r.Delete(ctx, config); err != nil && !apierror.IsNotFound(err) {
+		return err
+	}
+
+	if err := r.Ipam.ReleaseByPrefixes(ctx, prefixes); err != nil {
+		return err
+	}
+	others, err := r.otherBuilderConfigs(ctx, nodeStatus.Name)
+	if err != nil {
+		return err
+	}
+	return r.Ipam.ReleaseHost(ctx, nodeStatus.Name, usedSubnets(others))
+}
+
+// deleteVMObjects deletes BMH of the VM with its network data and credentials secrets
+func (r *BMHManager) deleteVMObjects(ctx context.Context, bmhName string) error {
+	objects := []client.Object{
+		&metal3.BareMetalHost{ObjectMeta: metav1.ObjectMeta{Name: bmhName, Namespace: r.Namespace}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("%s-network-data", bmhName), Namespace: r.Namespace}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("%s-credentials", bmhName), Namespace: r.Namespace}},
+	}
+	for _, obj := range objects {
+		r.Logger.Info("Deleting object created for VM",
+			"kind", fmt.Sprintf("%T", obj), "object", client.ObjectKeyFromObject(obj))
+		if err := r.Delete(ctx, obj); err != nil && !apierror.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// scaleDownNodeSet removes VMs of the node set on the k8s node, whose indexes are not lower than
+// count of the node set, starting from the highest index. VMs, whose BMHs are protected from
+// deletion or are in use, are kept, and their names are returned, so that they are requested
+// from vino-builder together with VMs within count. Other VMs of the node set are not touched
+func (r *BMHManager) scaleDownNodeSet(ctx context.Context,
+	nodeName, prefix string, nodeSet vinov1.NodeSet) ([]string, error) {
+	type indexedVM struct {
+		index   int
+		bmhName string
+	}
+	surplus := []indexedVM{}
+	for _, nodeStatus := range r.ViNO.Status.Nodes {
+		if nodeStatus.Name != nodeName {
+			continue
+		}
+		for _, vm := range nodeStatus.VMs {
+			if vm.Role != nodeSet.Name {
+				continue
+			}
+			index, err := strconv.Atoi(strings.TrimPrefix(vm.BMHName, fmt.Sprintf("%s-%s-", prefix, nodeSet.Name)))
+			if err != nil || index < nodeSet.Count {
+				continue
+			}
+			surplus = append(surplus, indexedVM{index: index, bmhName: vm.BMHName})
+		}
+	}
+	sort.Slice(surplus, func(i, j int) bool { return surplus[i].index > surplus[j].index })
+
+	kept := []string{}
+	for _, vm := range surplus {
+		bmh := &metal3.BareMetalHost{}
+		err := r.Get(ctx, types.NamespacedName{Name: vm.bmhName, Namespace: r.Namespace}, bmh)
+		switch {
+		case apierror.IsNotFound(err):
+			bmh = nil
+		case err != nil:
+			return nil, err
+		}
+		if reason := scaleDownBlocker(bmh, nodeSet.ForceScaleDown); reason != "" {
+			r.Logger.Info("Keeping VM above node set count", "BMH", vm.bmhName, "reason", reason)
+			r.scaleDownBlocked[vm.bmhName] = reason
+			kept = append(kept, vm.bmhName)
+			continue
+		}
+
+		r.Logger.Info("Removing VM above node set count", "BMH", vm.bmhName, "count", nodeSet.Count)
+		if err = r.deleteVMObjects(ctx, vm.bmhName); err != nil {
+			return nil, err
+		}
+		if err = r.Ipam.ReleaseByPrefix(ctx, vm.bmhName+"/"); err != nil {
+			return nil, err
+		}
+	}
+	// kept VMs are requested in the order of their indexes
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+	return kept, nil
+}
+
+// scaleDownBlocker returns the reason BMH of a VM above node set count can't be deleted, or an
+// empty string if it can. Deletion protection annotation is honored even if scale down is forced
+func scaleDownBlocker(bmh *metal3.BareMetalHost, force bool) string {
+	switch {
+	case bmh == nil:
+		return ""
+	case bmh.Annotations[vinov1.VinoDeletionProtectionAnnotation] == "true":
+		return "BMH is protected from deletion"
+	case force:
+		return ""
+	case bmh.Spec.ConsumerRef != nil:
+		return fmt.Sprintf("BMH is consumed by %s %s", bmh.Spec.ConsumerRef.Kind, bmh.Spec.ConsumerRef.Name)
+	}
+	switch bmh.Status.Provisioning.State {
+	case metal3.StateProvisioning, metal3.StateProvisioned, metal3.StateExternallyProvisioned:
+		return fmt.Sprintf("BMH is %s", bmh.Status.Provisioning.State)
+	}
+	return ""
+}
+
+// ScaleDownBlocked returns reasons VMs above count of their node sets are kept, by BMH name
+func (r *BMHManager) ScaleDownBlocked() map[string]string {
+	return r.scaleDownBlocked
+}
@@ -0,0 +1,243 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	vinov1 "vino/pkg/api/v1"
+	"vino/pkg/ipam"
+)
+
+func TestHandleRemovedNodes(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, vinov1.AddToScheme(scheme))
+	require.NoError(t, metal3.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	selected := map[string]string{"vino": "true"}
+	removedAt := metav1.NewTime(time.Now().Add(-time.Hour))
+	vino := &vinov1.Vino{
+		ObjectMeta: metav1.ObjectMeta{Name: "vino", Namespace: "default"},
+		Spec: vinov1.VinoSpec{
+			NodeSelector:           &vinov1.NodeSelector{MatchLabels: selected},
+			NodeRemovalGracePeriod: &metav1.Duration{Duration: 10 * time.Minute},
+		},
+		Status: vinov1.VinoStatus{Nodes: []vinov1.NodeStatus{
+			// vino-builder runs on the node, its BMH was paused when the node was removed
+			{Name: "back", RemovedAt: &removedAt, VMs: []vinov1.VMStatus{{BMHName: "back-worker-0"}}},
+			// vino-builder is restarting on the node, that still matches node selector
+			{Name: "restarting", BuilderReady: true, VMs: []vinov1.VMStatus{{BMHName: "restarting-worker-0"}}},
+			// node no longer matches node selector
+			{Name: "unlabeled", BuilderReady: true, VMs: []vinov1.VMStatus{{BMHName: "unlabeled-worker-0"}}},
+			// node is deleted and grace period has passed
+			{Name: "deleted", RemovedAt: &removedAt, VMs: []vinov1.VMStatus{{BMHName: "deleted-worker-0"}}},
+		}},
+	}
+	bmh := func(name string, annotations map[string]string) *metal3.BareMetalHost {
+		return &metal3.BareMetalHost{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: "vino-system", Annotations: annotations, ResourceVersion: "1"}}
+	}
+	secret := func(name string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "vino-system", ResourceVersion: "1"}}
+	}
+	node := func(name string, labels map[string]string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, ResourceVersion: "1"}}
+	}
+	pool := &vinov1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "ippool-10-0-0-0-24", Namespace: "vino-system", ResourceVersion: "1"},
+		Spec: vinov1.IPPoolSpec{
+			Subnet: "10.0.0.0/24",
+			AllocatedIPs: []vinov1.AllocatedIP{
+				{IP: "10.0.0.10", AllocatedTo: "deleted-worker-0/pxe"},
+				{IP: "10.0.0.20", AllocatedTo: "restarting-worker-0/pxe"},
+				{IP: "10.0.0.1", AllocatedTo: "deleted"},
+			},
+			AllocatedRanges: []vinov1.AllocatedRange{
+				{AllocatedTo: "deleted", Range: vinov1.Range{Start: "10.0.0.10", Stop: "10.0.0.19"}},
+				{AllocatedTo: "restarting", Range: vinov1.Range{Start: "10.0.0.20", Stop: "10.0.0.29"}},
+			},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		node("back", selected),
+		node("restarting", selected),
+		node("unlabeled", nil),
+		bmh("back-worker-0", map[string]string{metal3.PausedAnnotation: NodeRemovedPauseValue}),
+		bmh("restarting-worker-0", nil),
+		bmh("unlabeled-worker-0", nil),
+		bmh("deleted-worker-0", nil),
+		secret("deleted-worker-0-network-data"),
+		secret("deleted-worker-0-credentials"),
+		pool,
+	).Build()
+
+	r := &BMHManager{
+		Namespace: "vino-system",
+		Client:    c,
+		ViNO:      vino,
+		Ipam:      ipam.NewIpam(logr.Discard(), c, "vino-system"),
+		Logger:    logr.Discard(),
+	}
+	kept, err := r.handleRemovedNodes(ctx, map[string]struct{}{"back": {}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"restarting": {}, "unlabeled": {}}, kept)
+
+	statuses := map[string]vinov1.NodeStatus{}
+	for _, nodeStatus := range r.nodeStatuses {
+		statuses[nodeStatus.Name] = nodeStatus
+	}
+	require.Len(t, statuses, 2)
+	assert.False(t, statuses["restarting"].BuilderReady)
+	assert.Nil(t, statuses["restarting"].RemovedAt)
+	assert.False(t, statuses["unlabeled"].BuilderReady)
+	require.NotNil(t, statuses["unlabeled"].RemovedAt)
+
+	annotations := func(name string) map[string]string {
+		host := &metal3.BareMetalHost{}
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Name: name, Namespace: "vino-system"}, host))
+		return host.Annotations
+	}
+	assert.NotContains(t, annotations("back-worker-0"), metal3.PausedAnnotation)
+	assert.NotContains(t, annotations("restarting-worker-0"), metal3.PausedAnnotation)
+	assert.Equal(t, NodeRemovedPauseValue, annotations("unlabeled-worker-0")[metal3.PausedAnnotation])
+
+	err = c.Get(ctx, client.ObjectKey{Name: "deleted-worker-0", Namespace: "vino-system"}, &metal3.BareMetalHost{})
+	assert.True(t, apierror.IsNotFound(err))
+	for _, name := range []string{"deleted-worker-0-network-data", "deleted-worker-0-credentials"} {
+		err = c.Get(ctx, client.ObjectKey{Name: name, Namespace: "vino-system"}, &corev1.Secret{})
+		assert.True(t, apierror.IsNotFound(err))
+	}
+
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(pool), pool))
+	require.Len(t, pool.Spec.AllocatedIPs, 1)
+	assert.Equal(t, "restarting-worker-0/pxe", pool.Spec.AllocatedIPs[0].AllocatedTo)
+	// released ranges stay in the pool without an owner
+	require.Len(t, pool.Spec.AllocatedRanges, 2)
+	assert.Empty(t, pool.Spec.AllocatedRanges[0].AllocatedTo)
+	assert.Equal(t, "restarting", pool.Spec.AllocatedRanges[1].AllocatedTo)
+
+	// grace period of the unlabeled node passes, when it ends
+	vino.Status.Nodes = r.nodeStatuses
+	next := NextNodeRemoval(vino, time.Now())
+	assert.True(t, next > 9*time.Minute && next <= 10*time.Minute, next)
+	assert.Equal(t, time.Second, NextNodeRemoval(vino, time.Now().Add(time.Hour)))
+	assert.Equal(t, time.Duration(0), NextNodeRemoval(&vinov1.Vino{}, time.Now()))
+}
+
+func TestScaleDownNodeSet(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, vinov1.AddToScheme(scheme))
+	require.NoError(t, metal3.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	vms := []vinov1.VMStatus{}
+	for _, name := range []string{"worker-0", "worker-1", "worker-2", "worker-3", "worker-4", "master-0"} {
+		vms = append(vms, vinov1.VMStatus{BMHName: "default-vino-node01-" + name, Role: name[:len(name)-2]})
+	}
+	vino := &vinov1.Vino{
+		ObjectMeta: metav1.ObjectMeta{Name: "vino", Namespace: "default"},
+		Status:     vinov1.VinoStatus{Nodes: []vinov1.NodeStatus{{Name: "node01", VMs: vms}}},
+	}
+	provisioned := func(name string) *metal3.BareMetalHost {
+		bmh := &metal3.BareMetalHost{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: "vino-system", ResourceVersion: "1"}}
+		bmh.Status.Provisioning.State = metal3.StateProvisioned
+		return bmh
+	}
+	protected := provisioned("default-vino-node01-worker-3")
+	protected.Annotations = map[string]string{vinov1.VinoDeletionProtectionAnnotation: "true"}
+	pool := &vinov1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "ippool-10-0-0-0-24", Namespace: "vino-system", ResourceVersion: "1"},
+		Spec: vinov1.IPPoolSpec{
+			Subnet: "10.0.0.0/24",
+			AllocatedIPs: []vinov1.AllocatedIP{
+				{IP: "10.0.0.11", AllocatedTo: "default-vino-node01-worker-1/pxe"},
+				{IP: "10.0.0.12", AllocatedTo: "default-vino-node01-worker-2/pxe"},
+			},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&metal3.BareMetalHost{ObjectMeta: metav1.ObjectMeta{
+			Name: "default-vino-node01-worker-1", Namespace: "vino-system", ResourceVersion: "1"}},
+		provisioned("default-vino-node01-worker-2"),
+		protected,
+		provisioned("default-vino-node01-worker-4"),
+		pool,
+	).Build()
+	r := &BMHManager{
+		Namespace:        "vino-system",
+		Client:           c,
+		ViNO:             vino,
+		Ipam:             ipam.NewIpam(logr.Discard(), c, "vino-system"),
+		Logger:           logr.Discard(),
+		scaleDownBlocked: map[string]string{},
+	}
+	exists := func(name string) bool {
+		err := c.Get(ctx, client.ObjectKey{Name: name, Namespace: "vino-system"}, &metal3.BareMetalHost{})
+		if apierror.IsNotFound(err) {
+			return false
+		}
+		require.NoError(t, err)
+		return true
+	}
+
+	// provisioned and protected VMs are kept
+	kept, err := r.scaleDownNodeSet(ctx, "node01", "default-vino-node01", vinov1.NodeSet{Name: "worker", Count: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"default-vino-node01-worker-2", "default-vino-node01-worker-3", "default-vino-node01-worker-4",
+	}, kept)
+	assert.False(t, exists("default-vino-node01-worker-1"))
+	assert.Equal(t, map[string]string{
+		"default-vino-node01-worker-2": "BMH is provisioned",
+		"default-vino-node01-worker-3": "BMH is protected from deletion",
+		"default-vino-node01-worker-4": "BMH is provisioned",
+	}, r.ScaleDownBlocked())
+
+	// forced scale down keeps only protected VMs
+	r.scaleDownBlocked = map[string]string{}
+	kept, err = r.scaleDownNodeSet(ctx, "node01", "default-vino-node01",
+		vinov1.NodeSet{Name: "worker", Count: 1, ForceScaleDown: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default-vino-node01-worker-3"}, kept)
+	assert.False(t, exists("default-vino-node01-worker-2"))
+	assert.True(t, exists("default-vino-node01-worker-3"))
+	assert.False(t, exists("default-vino-node01-worker-4"))
+
+	// pool without allocations is deleted
+	err = c.Get(ctx, client.ObjectKeyFromObject(pool), pool)
+	assert.True(t, apierror.IsNotFound(err))
+
+	// VMs within count and VMs of other node sets are not touched
+	kept, err = r.scaleDownNodeSet(ctx, "node01", "default-vino-node01", vinov1.NodeSet{Name: "master", Count: 1})
+	require.NoError(t, err)
+	assert.Empty(t, kept)
+}
@@ -0,0 +1,120 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+const (
+	// VNCPasswordsDir is a directory in vino-builder container, VNC passwords secret is mounted to
+	VNCPasswordsDir = "/etc/vino-vnc"
+
+	// VNC authentication uses only the first 8 characters of a password
+	generatedVNCPasswordBytes = 6
+)
+
+// VNCPasswordsSecretName returns name of the secret in the runtime namespace, that holds VNC
+// passwords of all VMs of vino CR by BMH name. The secret is mounted to vino-builder containers.
+func VNCPasswordsSecretName(vino *vinov1.Vino) string {
+	return fmt.Sprintf("%s-%s-vnc-passwords", vino.Namespace, vino.Name)
+}
+
+// EnsureVNCPasswordsSecret creates VNC passwords secret without any passwords, unless it already
+// exists, so that vino-builder pods can start before VMs are requested
+func EnsureVNCPasswordsSecret(ctx context.Context, c client.Client, namespace string, vino *vinov1.Vino) error {
+	return ensureSecret(ctx, c, newVNCPasswordsSecret(vino, namespace, nil))
+}
+
+func newVNCPasswordsSecret(vino *vinov1.Vino, namespace string, passwords map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      VNCPasswordsSecretName(vino),
+			Namespace: namespace,
+			Labels:    vinoLabels(vino),
+		},
+		Data: passwords,
+		Type: corev1.SecretTypeOpaque,
+	}
+}
+
+// loadVNCPasswords reads passwords already assigned to VMs, so that they survive reconciliation
+func (r *BMHManager) loadVNCPasswords(ctx context.Context) error {
+	r.vncPasswords = make(map[string][]byte)
+	r.nodeSetVNCPasswords = make(map[string][]byte)
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Name: VNCPasswordsSecretName(r.ViNO), Namespace: r.Namespace}, secret)
+	switch {
+	case apierror.IsNotFound(err):
+		r.existingVNCPasswords = map[string][]byte{}
+		return nil
+	case err != nil:
+		return err
+	}
+	r.existingVNCPasswords = secret.Data
+	return nil
+}
+
+// setVNCPassword assigns VNC password to the VM. Password is taken from the secret referenced
+// by the node set, otherwise the password already generated for the VM is kept, or a new one
+// is generated.
+func (r *BMHManager) setVNCPassword(ctx context.Context, node vinov1.NodeSet, bmhName string) error {
+	if ref := node.VNCPasswordSecretRef; ref != nil {
+		password, ok := r.nodeSetVNCPasswords[node.Name]
+		if !ok {
+			secret := &corev1.Secret{}
+			key := client.ObjectKey{Name: ref.Name, Namespace: ref.Namespace}
+			if key.Namespace == "" {
+				key.Namespace = r.ViNO.Namespace
+			}
+			if err := r.Get(ctx, key, secret); err != nil {
+				return err
+			}
+			if password, ok = secret.Data[vinov1.VinoVNCPasswordKey]; !ok {
+				return fmt.Errorf("VNC password secret %v has no key '%s'", key, vinov1.VinoVNCPasswordKey)
+			}
+			r.nodeSetVNCPasswords[node.Name] = password
+		}
+		r.vncPasswords[bmhName] = password
+		return nil
+	}
+
+	if password, ok := r.existingVNCPasswords[bmhName]; ok {
+		r.vncPasswords[bmhName] = password
+		return nil
+	}
+	password, err := randomString(generatedVNCPasswordBytes)
+	if err != nil {
+		return err
+	}
+	r.vncPasswords[bmhName] = []byte(password)
+	return nil
+}
+
+// applyVNCPasswords stores passwords of all VMs in VNC passwords secret, passwords of
+// VMs that no longer exist are removed
+func (r *BMHManager) applyVNCPasswords(ctx context.Context) error {
+	secret := newVNCPasswordsSecret(r.ViNO, r.Namespace, r.vncPasswords)
+	r.Logger.Info("Applying VNC passwords secret", "secret", client.ObjectKeyFromObject(secret))
+	return applySecret(ctx, r.Client, secret)
+}
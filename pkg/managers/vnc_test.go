@@ -0,0 +1,75 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+func TestVNCPasswords(t *testing.T) {
+	ctx := context.Background()
+	vino := &vinov1.Vino{ObjectMeta: metav1.ObjectMeta{Name: "vino", Namespace: "default"}}
+	c := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "default-vino-vnc-passwords",
+				Namespace:       "vino-system",
+				ResourceVersion: "1",
+			},
+			Data: map[string][]byte{
+				"node01-worker-0": []byte("existing"),
+				"node01-worker-9": []byte("removed"),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "vnc-password", Namespace: "default", ResourceVersion: "1"},
+			Data:       map[string][]byte{vinov1.VinoVNCPasswordKey: []byte("shared")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "no-password", Namespace: "default", ResourceVersion: "1"},
+		},
+	).Build()
+	r := &BMHManager{Namespace: "vino-system", Client: c, ViNO: vino, Logger: logr.Discard()}
+	require.NoError(t, r.loadVNCPasswords(ctx))
+
+	workers := vinov1.NodeSet{Name: "worker"}
+	require.NoError(t, r.setVNCPassword(ctx, workers, "node01-worker-0"))
+	require.NoError(t, r.setVNCPassword(ctx, workers, "node01-worker-1"))
+	masters := vinov1.NodeSet{Name: "master", VNCPasswordSecretRef: &vinov1.NamespacedName{Name: "vnc-password"}}
+	require.NoError(t, r.setVNCPassword(ctx, masters, "node01-master-0"))
+	broken := vinov1.NodeSet{Name: "broken", VNCPasswordSecretRef: &vinov1.NamespacedName{Name: "no-password"}}
+	assert.Error(t, r.setVNCPassword(ctx, broken, "node01-broken-0"))
+
+	require.NoError(t, r.applyVNCPasswords(ctx))
+	secret := &corev1.Secret{}
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "default-vino-vnc-passwords", Namespace: "vino-system"},
+		secret))
+	assert.Equal(t, []byte("existing"), secret.Data["node01-worker-0"])
+	assert.Len(t, secret.Data["node01-worker-1"], 8)
+	assert.Equal(t, []byte("shared"), secret.Data["node01-master-0"])
+	assert.NotContains(t, secret.Data, "node01-worker-9")
+	assert.Equal(t, "vino", secret.Labels[vinov1.VinoLabelDSNameSelector])
+}
@@ -0,0 +1,207 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reporter
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/go-logr/logr"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+// DefaultStoragePool is libvirt storage pool, vino-builder creates domain volumes in
+const DefaultStoragePool = "vino-default"
+
+// Virsh runs virsh commands against libvirt on the node
+type Virsh interface {
+	Run(ctx context.Context, args ...string) (string, error)
+}
+
+// ExecVirsh runs virsh binary of vino-builder container
+type ExecVirsh struct{}
+
+// Run returns output of virsh command, output is included into the error if command fails
+func (ExecVirsh) Run(ctx context.Context, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, "virsh", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("virsh %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// Reporter inspects libvirt domains built by vino-builder, and reports their state to the status
+// of VinoBuilderConfig they were built from, so that vino controller creates BMHs only for VMs
+// that actually exist
+type Reporter struct {
+	client.Client
+	Virsh       Virsh
+	StoragePool string
+	Logger      logr.Logger
+}
+
+// Report records state of domains of the builder in status of the config. Generation is the
+// generation of the config the builder was taken from, buildErr is set if the build has failed.
+// It returns true if the build succeeded and all domains are realized.
+func (r *Reporter) Report(ctx context.Context,
+	key client.ObjectKey,
+	generation int64,
+	builder vinov1.Builder,
+	buildErr error) (bool, error) {
+	domains, err := r.DomainStatuses(ctx, builder.Domains)
+	if err != nil {
+		return false, err
+	}
+	condition := builtCondition(generation, domains, buildErr)
+	r.Logger.Info("Reporting state of domains", "config", key, "generation", generation,
+		"built", condition.Status, "message", condition.Message)
+
+	built := condition.Status == metav1.ConditionTrue
+	return built, retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		config := &vinov1.VinoBuilderConfig{}
+		if err := r.Get(ctx, key, config); err != nil {
+			return err
+		}
+		config.Status.ObservedGeneration = generation
+		config.Status.Domains = domains
+		apimeta.SetStatusCondition(&config.Status.Conditions, condition)
+		return r.Status().Update(ctx, config)
+	})
+}
+
+// DomainStatuses returns state of the domains in libvirt, problems with a domain are recorded
+// in its status, error is returned only if libvirt can't be queried at all
+func (r *Reporter) DomainStatuses(ctx context.Context,
+	domains []vinov1.BuilderDomain) ([]vinov1.DomainStatus, error) {
+	pool := r.StoragePool
+	if pool == "" {
+		pool = DefaultStoragePool
+	}
+	volList, err := r.Virsh.Run(ctx, "vol-list", pool)
+	if err != nil {
+		return nil, err
+	}
+	volumes := map[string]struct{}{}
+	for _, row := range tableRows(volList) {
+		volumes[row[0]] = struct{}{}
+	}
+
+	statuses := make([]vinov1.DomainStatus, 0, len(domains))
+	for _, domain := range domains {
+		statuses = append(statuses, r.domainStatus(ctx, domain, pool, volumes))
+	}
+	return statuses, nil
+}
+
+func (r *Reporter) domainStatus(ctx context.Context,
+	domain vinov1.BuilderDomain,
+	pool string,
+	volumes map[string]struct{}) vinov1.DomainStatus {
+	status := vinov1.DomainStatus{Name: domain.Name}
+	state, err := r.Virsh.Run(ctx, "domstate", domain.Name)
+	if err != nil {
+		status.State = vinov1.DomainStateNotDefined
+		status.Error = err.Error()
+		return status
+	}
+	status.State = vinov1.DomainStateDefined
+	if strings.TrimSpace(state) == "running" {
+		status.State = vinov1.DomainStateRunning
+	}
+
+	errs := []string{}
+	uuid, err := r.Virsh.Run(ctx, "domuuid", domain.Name)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	status.UUID = strings.TrimSpace(uuid)
+
+	ifList, err := r.Virsh.Run(ctx, "domiflist", domain.Name)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	bootMACFound := false
+	for _, row := range tableRows(ifList) {
+		// MAC address is the last column
+		mac := strings.ToLower(row[len(row)-1])
+		status.MACAddresses = append(status.MACAddresses, mac)
+		bootMACFound = bootMACFound || mac == strings.ToLower(domain.BootMACAddress)
+	}
+	if err == nil && domain.BootMACAddress != "" && !bootMACFound {
+		errs = append(errs, fmt.Sprintf("boot MAC address %s is not attached to the domain", domain.BootMACAddress))
+	}
+
+	// domain volume is named after the domain
+	if _, exists := volumes[domain.Name]; exists {
+		status.Volumes = []string{domain.Name}
+	} else {
+		errs = append(errs, fmt.Sprintf("volume %s is not created in storage pool %s", domain.Name, pool))
+	}
+	status.Error = strings.Join(errs, "; ")
+	return status
+}
+
+// builtCondition returns Built condition of the config for the domain statuses
+func builtCondition(generation int64, domains []vinov1.DomainStatus, buildErr error) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               vinov1.ConditionTypeBuilt,
+		Status:             metav1.ConditionTrue,
+		Reason:             vinov1.BuildSucceededReason,
+		Message:            "VMs are built",
+		ObservedGeneration: generation,
+	}
+	notRealized := 0
+	for _, domain := range domains {
+		if !domain.Realized() {
+			notRealized++
+		}
+	}
+	switch {
+	case buildErr != nil:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = vinov1.BuildFailedReason
+		condition.Message = fmt.Sprintf("Build failed: %v", buildErr)
+	case notRealized > 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = vinov1.BuildFailedReason
+		condition.Message = fmt.Sprintf("%d of %d domains are not realized", notRealized, len(domains))
+	}
+	return condition
+}
+
+// tableRows returns fields of rows of a table printed by virsh, header and separator are skipped
+func tableRows(output string) [][]string {
+	rows := [][]string{}
+	inBody := false
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "---") {
+			inBody = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if !inBody || len(fields) == 0 {
+			continue
+		}
+		rows = append(rows, fields)
+	}
+	return rows
+}
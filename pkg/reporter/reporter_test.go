@@ -0,0 +1,135 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	vinov1 "vino/pkg/api/v1"
+)
+
+// fakeVirsh returns canned outputs of virsh commands, commands without output fail
+type fakeVirsh map[string]string
+
+func (v fakeVirsh) Run(_ context.Context, args ...string) (string, error) {
+	command := strings.Join(args, " ")
+	out, ok := v[command]
+	if !ok {
+		return "", fmt.Errorf("virsh %s failed: domain not found", command)
+	}
+	return out, nil
+}
+
+const volList = ` Name                         Path
+-----------------------------------------------------------------------------
+ default-vino-node01-master-0 /var/lib/libvirt/vino-pool/default-vino-node01-master-0
+ default-vino-node01-worker-0 /var/lib/libvirt/vino-pool/default-vino-node01-worker-0
+`
+
+const ifList = ` Interface   Type      Source   Model    MAC
+-------------------------------------------------------------
+ vnet0       network   pxe      virtio   52:54:00:AA:00:01
+ vnet1       network   mgmt     virtio   52:54:00:aa:00:02
+`
+
+func TestReport(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, vinov1.AddToScheme(scheme))
+	key := client.ObjectKey{Name: "vino-node01", Namespace: "default"}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&vinov1.VinoBuilderConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace, ResourceVersion: "1"},
+	}).Build()
+	r := &Reporter{
+		Client: c,
+		Virsh: fakeVirsh{
+			"vol-list vino-default":                  volList,
+			"domstate default-vino-node01-master-0":  "running\n",
+			"domuuid default-vino-node01-master-0":   "8d1b6a62-3c3f-4f0e-9f55-4ab1b4f0e0a1\n",
+			"domiflist default-vino-node01-master-0": ifList,
+			"domstate default-vino-node01-worker-0":  "shut off\n",
+			"domuuid default-vino-node01-worker-0":   "0f6a44d0-6f5c-43f1-8f3f-1c1a9b6f0b52\n",
+			"domiflist default-vino-node01-worker-0": ifList,
+		},
+		Logger: logr.Discard(),
+	}
+	builder := vinov1.Builder{Domains: []vinov1.BuilderDomain{
+		{Name: "default-vino-node01-master-0", BootMACAddress: "52:54:00:aa:00:01"},
+		{Name: "default-vino-node01-worker-0", BootMACAddress: "52:54:00:aa:00:09"},
+		{Name: "default-vino-node01-worker-1"},
+	}}
+
+	built, err := r.Report(ctx, key, 3, builder, nil)
+	require.NoError(t, err)
+	assert.False(t, built)
+	config := &vinov1.VinoBuilderConfig{}
+	require.NoError(t, c.Get(ctx, key, config))
+	assert.Equal(t, int64(3), config.Status.ObservedGeneration)
+	require.Len(t, config.Status.Domains, 3)
+
+	master := config.Status.Domains[0]
+	assert.True(t, master.Realized())
+	assert.Equal(t, vinov1.DomainStateRunning, master.State)
+	assert.Equal(t, "8d1b6a62-3c3f-4f0e-9f55-4ab1b4f0e0a1", master.UUID)
+	assert.Equal(t, []string{"52:54:00:aa:00:01", "52:54:00:aa:00:02"}, master.MACAddresses)
+	assert.Equal(t, []string{"default-vino-node01-master-0"}, master.Volumes)
+
+	worker := config.Status.Domains[1]
+	assert.False(t, worker.Realized())
+	assert.Equal(t, vinov1.DomainStateDefined, worker.State)
+	assert.Contains(t, worker.Error, "boot MAC address 52:54:00:aa:00:09")
+
+	missing := config.Status.Domains[2]
+	assert.False(t, missing.Realized())
+	assert.Equal(t, vinov1.DomainStateNotDefined, missing.State)
+	assert.NotEmpty(t, missing.Error)
+
+	condition := apimeta.FindStatusCondition(config.Status.Conditions, vinov1.ConditionTypeBuilt)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, "2 of 3 domains are not realized", condition.Message)
+
+	// failed build is reported even if all domains exist
+	builder.Domains = builder.Domains[:1]
+	built, err = r.Report(ctx, key, 4, builder, errors.New("playbook failed"))
+	require.NoError(t, err)
+	assert.False(t, built)
+	require.NoError(t, c.Get(ctx, key, config))
+	condition = apimeta.FindStatusCondition(config.Status.Conditions, vinov1.ConditionTypeBuilt)
+	require.NotNil(t, condition)
+	assert.Equal(t, vinov1.BuildFailedReason, condition.Reason)
+	assert.Equal(t, int64(4), condition.ObservedGeneration)
+
+	built, err = r.Report(ctx, key, 4, builder, nil)
+	require.NoError(t, err)
+	assert.True(t, built)
+	require.NoError(t, c.Get(ctx, key, config))
+	condition = apimeta.FindStatusCondition(config.Status.Conditions, vinov1.ConditionTypeBuilt)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+}
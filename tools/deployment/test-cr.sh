@@ -20,6 +20,7 @@ worker_copy_label="airshipit.org/k8s-role=worker"
 # Label all nodes with the same rack/label. We are ok with this for this simple test.
 kubectl label node --overwrite=true --all $server_label $rack_label
 
+kubectl apply -f config/samples/bmc-credentials-secret.yaml
 kubectl apply -f config/samples/vino_cr_4_workers_1_cp.yaml
 kubectl apply -f config/samples/ippool.yaml
 kubectl apply -f config/samples/network-template-secret.yaml
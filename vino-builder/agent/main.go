@@ -0,0 +1,103 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"vino/pkg/agent"
+	vinov1 "vino/pkg/api/v1"
+	"vino/pkg/reporter"
+)
+
+const templatesDir = "/var/lib/vino-builder"
+
+// vino-builder-agent runs in vino-builder container. It watches VinoBuilderConfigs of its node,
+// realizes the config of its vino CR with the playbook whenever configs change, and reports state
+// of libvirt domains to the config status.
+func main() {
+	var nodeName, owner, dataFile, readinessFile, playbook, lockFile, storagePool string
+	var resync time.Duration
+	flag.StringVar(&nodeName, "node-name", os.Getenv("HOSTNAME"), "Name of k8s node vino-builder runs on.")
+	flag.StringVar(&owner, "owner", os.Getenv("VINO_OWNER"), "<namespace>/<name> of vino CR to realize VMs of.")
+	flag.StringVar(&dataFile, "data-file", templatesDir+"/dynamic.yaml", "Path to write builder data to.")
+	flag.StringVar(&readinessFile, "readiness-file", "/tmp/healthy",
+		"File that exists while VMs of the latest config are realized.")
+	flag.StringVar(&playbook, "playbook", "/playbooks/vino-builder.yaml", "Path to vino-builder playbook.")
+	flag.StringVar(&lockFile, "lock-file", "/var/lib/libvirt/vino-builder.lock",
+		"Lock file, that serializes playbook runs of vino-builders on the node.")
+	flag.StringVar(&storagePool, "storage-pool", reporter.DefaultStoragePool,
+		"Libvirt storage pool with domain volumes.")
+	flag.DurationVar(&resync, "resync-period", 10*time.Minute, "Resync period of VinoBuilderConfigs informer.")
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New())
+	log := ctrl.Log.WithName("vino-builder-agent")
+
+	parts := strings.Split(owner, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || nodeName == "" {
+		log.Error(errors.New("invalid flags"), "owner must be <namespace>/<name> and node name must be set",
+			"owner", owner, "node", nodeName)
+		os.Exit(1)
+	}
+
+	scheme := runtime.NewScheme()
+	if err := vinov1.AddToScheme(scheme); err != nil {
+		log.Error(err, "unable to create scheme")
+		os.Exit(1)
+	}
+	config := ctrl.GetConfigOrDie()
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		log.Error(err, "unable to create client")
+		os.Exit(1)
+	}
+	informer, err := agent.NewConfigInformer(config, scheme, nodeName, resync)
+	if err != nil {
+		log.Error(err, "unable to create VinoBuilderConfigs informer")
+		os.Exit(1)
+	}
+
+	a := &agent.Agent{
+		Owner: client.ObjectKey{Namespace: parts[0], Name: parts[1]},
+		Realizer: agent.PlaybookRealizer{
+			Playbook: playbook,
+			VarFiles: []string{
+				templatesDir + "/flavors/flavors.yaml",
+				templatesDir + "/flavor-templates/flavor-templates.yaml",
+				templatesDir + "/network-templates/network-templates.yaml",
+				templatesDir + "/storage-templates/storage-templates.yaml",
+			},
+			LockFile: lockFile,
+		},
+		Reporter:      &reporter.Reporter{Client: c, Virsh: reporter.ExecVirsh{}, StoragePool: storagePool, Logger: log},
+		DataFile:      dataFile,
+		ReadinessFile: readinessFile,
+		Logger:        log.WithValues("node", nodeName, "vino", owner),
+	}
+	if err = a.Run(ctrl.SetupSignalHandler(), informer); err != nil {
+		log.Error(err, "vino-builder agent failed")
+		os.Exit(1)
+	}
+}
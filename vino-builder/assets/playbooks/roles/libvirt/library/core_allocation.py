@@ -33,6 +33,13 @@ description:
 '''
 
 PATH_SYS_DEVICES_NODE = "/sys/devices/system/node"
+# core state is kept on the host, so that vino-builders of all vino CRs on
+# the host share it
+PATH_CORE_STATE = "/var/lib/libvirt/vino-cores.json"
+# core state was kept here by earlier vino-builders, it is moved to
+# PATH_CORE_STATE on the first run, so that cores pinned before the upgrade
+# are not assigned again
+PATH_LEGACY_CORE_STATE = "/etc/libvirt/vino-cores.json"
 
 def _parse_range(rng):
     parts = rng.split('-')
@@ -60,16 +67,25 @@ def get_numa_cores():
                 numa_core_dict[numa] = parsed_range_list
     return numa_core_dict
 
+def _node_flavor(node, flavors):
+    """Return flavor of the node, resolved by vino controller or taken by node role"""
+    return node.get("flavor") or flavors[node["role"]]
+
+def load_core_state():
+    """Return core state of the host, state kept at the legacy path is used
+    if there is no state at the current one"""
+    for path in (PATH_CORE_STATE, PATH_LEGACY_CORE_STATE):
+        try:
+            with open(path, 'r') as f:
+                return json.loads(f.read())
+        except (IOError, OSError, ValueError):
+            continue
+    return {}
+
 def allocate_cores(nodes, flavors, exclude_cpu):
     """Return"""
 
-    core_state = {}
-
-    try:
-        f = open('/etc/libvirt/vino-cores.json', 'r')
-        core_state = json.loads(f.read())
-    except:
-        pass
+    core_state = load_core_state()
 
     # instantiate initial inventory - we don't support the inventory
     # changing (e.g. adding cores)
@@ -92,12 +108,28 @@ def allocate_cores(nodes, flavors, exclude_cpu):
     if 'assignments' not in core_state:
         core_state['assignments'] = {}
 
+    # nodes are domains of all vino CRs on the host, cores of domains that are
+    # no longer requested by any of them, or no longer need dedicated cores,
+    # are added back to available
+    pinned_names = set(node["name"] for node in nodes if _node_flavor(node, flavors).get("cpuPinning", "Dedicated") != "None")
+    for node_name in list(core_state['assignments'].keys()):
+        if node_name in pinned_names:
+            continue
+        cores = core_state['assignments'].pop(node_name)
+        for numa in core_state['available']:
+            if set(cores).issubset(core_state['inventory'][numa]):
+                core_state['available'][numa] = sorted(core_state['available'][numa] + cores)
+                break
+
     # walk the nodes, consuming inventory or discovering previous allocations
     # address the case where previous != desired - delete previous, re-run
     for node in nodes:
 
-        flavor = node["role"]
-        vcpus = flavors[flavor]['vcpus']
+        # domains sized by VinoFlavor carry their flavor, others use flavor of their role
+        flavor = _node_flavor(node, flavors)
+        if flavor.get("cpuPinning", "Dedicated") == "None":
+            continue
+        vcpus = flavor['vcpus']
 
 
         # generate a unique name such as master-0, master-1
@@ -119,6 +151,8 @@ def allocate_cores(nodes, flavors, exclude_cpu):
         # allocate the cores
         allocated=False
         for numa in core_state['available']:
+            if flavor.get("numaNode") is not None and numa != "node%d" % flavor["numaNode"]:
+                continue
             if core_count <= len(core_state['available'][numa]):
                 allocated=True
                 cores_to_use = core_state['available'][numa][:core_count]
@@ -132,8 +166,11 @@ def allocate_cores(nodes, flavors, exclude_cpu):
 
     # return a dict of nodes: cores
     # or error if insufficient
-    with open('/etc/libvirt/vino-cores.json', 'w') as f:
+    with open(PATH_CORE_STATE, 'w') as f:
         f.write(json.dumps(core_state))
+    # legacy state is removed only after it is saved at the current path
+    if os.path.exists(PATH_LEGACY_CORE_STATE):
+        os.remove(PATH_LEGACY_CORE_STATE)
 
     return core_state['assignments']
 
@@ -0,0 +1,92 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/yaml"
+
+	vinov1 "vino/pkg/api/v1"
+	"vino/pkg/libvirt"
+)
+
+type flavorsDefinition struct {
+	Flavors map[string]libvirt.Flavor `json:"flavors"`
+}
+
+// vino-builder-render renders libvirt XML of storage pool, networks, domains and their volumes
+// from builder data, without touching libvirt. Files are written to the output directory as
+// <pool|network|volume|domain>-<name>.xml
+func main() {
+	var builderData, flavorsFile, coresFile, outputDir string
+	opts := libvirt.Options{CreationTime: time.Now()}
+	flag.StringVar(&builderData, "builder-data", "", "Path to builder data.")
+	flag.StringVar(&flavorsFile, "flavors", "/var/lib/vino-builder/flavors/flavors.yaml", "Path to VM flavors.")
+	flag.StringVar(&coresFile, "cores", "", "Path to JSON map of domain names to allocated host cores.")
+	flag.StringVar(&outputDir, "output-dir", ".", "Directory to write XML files to.")
+	flag.StringVar(&opts.StoragePool, "storage-pool", libvirt.DefaultStoragePool, "Storage pool of domain volumes.")
+	flag.StringVar(&opts.VNCPasswordDir, "vnc-password-dir", libvirt.DefaultVNCPasswordDir,
+		"Directory with VNC passwords of domains.")
+	flag.StringVar(&opts.PXEBootImageHost, "pxe-boot-image-host", "",
+		"PXE boot image host, if builder data doesn't define it.")
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New())
+	log := ctrl.Log.WithName("vino-builder-render")
+
+	builder := vinov1.Builder{}
+	definition := flavorsDefinition{}
+	cores := map[string][]int{}
+	for path, obj := range map[string]interface{}{builderData: &builder, flavorsFile: &definition, coresFile: &cores} {
+		if path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = yaml.Unmarshal(data, obj)
+		}
+		if err != nil {
+			log.Error(err, "unable to read input", "path", path)
+			os.Exit(1)
+		}
+	}
+
+	rendered, err := libvirt.Render(builder, definition.Flavors, cores, opts)
+	if err != nil {
+		log.Error(err, "unable to render libvirt XML")
+		os.Exit(1)
+	}
+	for kind, objects := range map[string]map[string][]byte{
+		"pool":    rendered.StoragePools,
+		"network": rendered.Networks,
+		"volume":  rendered.Volumes,
+		"domain":  rendered.Domains,
+	} {
+		for name, xml := range objects {
+			path := filepath.Join(outputDir, kind+"-"+name+".xml")
+			if err = ioutil.WriteFile(path, xml, 0600); err != nil {
+				log.Error(err, "unable to write libvirt XML", "path", path)
+				os.Exit(1)
+			}
+		}
+	}
+}